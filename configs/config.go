@@ -1,26 +1,87 @@
 package configs
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
-var config *viper.Viper
+var (
+	store *Store
 
+	validatorsMu sync.Mutex
+	validators   []func(*viper.Viper) error
+)
+
+// Init loads the config file into the process wide store and watches it
+// for changes.
 func Init() {
-	var err error
-	config = viper.New()
-	config.SetConfigType("yaml")
-	config.AddConfigPath("/etc/app/config/")
-	// Uncomment below line for local development
-	// config.AddConfigPath("./configs/")
-	// config.AddConfigPath("./../configs/")
-	setDefaults(config)
-	err = config.ReadInConfig()
+	newConfig, err := load()
 	if err != nil {
 		log.Fatal("Fatal error config file: ", err)
 	}
+	if err = Validate(newConfig); err != nil {
+		log.Fatal("Invalid config: ", err)
+	}
+	store = NewStore(newConfig)
+	store.Watch()
+}
+
+func load() (*viper.Viper, error) {
+	newConfig := viper.New()
+	newConfig.SetConfigType("yaml")
+	newConfig.AddConfigPath("/etc/app/config/")
+	// Uncomment below line for local development
+	// newConfig.AddConfigPath("./configs/")
+	// newConfig.AddConfigPath("./../configs/")
+	setDefaults(newConfig)
+	err := newConfig.ReadInConfig()
+	return newConfig, err
+}
+
+// RegisterValidator adds a check that every config, initial or reloaded,
+// has to pass.
+func RegisterValidator(validator func(*viper.Viper) error) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	validators = append(validators, validator)
+}
+
+// Validate runs the built-in and registered validators against a config.
+func Validate(newConfig *viper.Viper) error {
+	if _, err := log.ParseLevel(newConfig.GetString("log.level")); err != nil {
+		return err
+	}
+	if newConfig.GetInt("failsafe_interval") < 0 {
+		return errors.New("failsafe_interval cannot be negative")
+	}
+	if !newConfig.IsSet("issuer") {
+		return errors.New("config has no issuer section")
+	}
+	for name := range newConfig.GetStringMap("issuer") {
+		// Issuers of other types than hydra are validated by their services
+		if issuerType := newConfig.GetString("issuer." + name + ".type"); issuerType != "" && issuerType != "hydra" {
+			continue
+		}
+		u, err := url.ParseRequestURI(newConfig.GetString("issuer." + name + ".url"))
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("issuer %s has an invalid url", name)
+		}
+	}
+
+	validatorsMu.Lock()
+	checks := append([]func(*viper.Viper) error{}, validators...)
+	validatorsMu.Unlock()
+	for _, check := range checks {
+		if err := check(newConfig); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func setDefaults(config *viper.Viper) {
@@ -31,6 +92,20 @@ func setDefaults(config *viper.Viper) {
 	config.SetDefault("keto.write.url", "localhost:4467")
 }
 
+// GetStore returns the process wide store loaded by Init.
+func GetStore() *Store {
+	return store
+}
+
+// GetConfig returns the current config of the process wide store.
 func GetConfig() *viper.Viper {
-	return config
+	if store == nil {
+		return nil
+	}
+	return store.Get()
+}
+
+// OnReload registers a reload hook on the process wide store.
+func OnReload(hook func(*viper.Viper)) {
+	store.OnReload(hook)
 }
@@ -0,0 +1,110 @@
+package configs
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/livspaceeng/ozone/internal/metrics"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Store holds the current config of an ozone instance. The config can be
+// swapped atomically, and reload hooks let the instance rebuild whatever it
+// derived from the previous one.
+type Store struct {
+	config    atomic.Value
+	watchOnce sync.Once
+
+	hooksMu     sync.Mutex
+	reloadHooks []func(*viper.Viper)
+	validators  []func(*viper.Viper) error
+}
+
+// NewStore returns a store serving the given config as is.
+func NewStore(config *viper.Viper) *Store {
+	store := &Store{}
+	store.config.Store(config)
+	return store
+}
+
+// Get returns the current config.
+func (s *Store) Get() *viper.Viper {
+	return s.config.Load().(*viper.Viper)
+}
+
+// OnReload registers a hook that is called with the new config after it
+// was validated and swapped in.
+func (s *Store) OnReload(hook func(*viper.Viper)) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.reloadHooks = append(s.reloadHooks, hook)
+}
+
+// OnValidate registers a validator that a new config also has to pass to be
+// reloaded, for what depends on the running instance rather than the config
+// alone.
+func (s *Store) OnValidate(validator func(*viper.Viper) error) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.validators = append(s.validators, validator)
+}
+
+// Reload validates a new config and swaps it in. An invalid config is
+// rejected and the previous config keeps serving.
+func (s *Store) Reload(newConfig *viper.Viper) error {
+	if err := s.validate(newConfig); err != nil {
+		log.Error("Rejected config reload, keeping previous config: ", err)
+		metrics.ConfigReloads.WithLabelValues("failure").Inc()
+		return err
+	}
+	s.config.Store(newConfig)
+
+	s.hooksMu.Lock()
+	hooks := append([]func(*viper.Viper){}, s.reloadHooks...)
+	s.hooksMu.Unlock()
+	for _, hook := range hooks {
+		hook(newConfig)
+	}
+	log.Info("Config reloaded from ", newConfig.ConfigFileUsed())
+	metrics.ConfigReloads.WithLabelValues("success").Inc()
+	return nil
+}
+
+func (s *Store) validate(newConfig *viper.Viper) error {
+	if err := Validate(newConfig); err != nil {
+		return err
+	}
+	s.hooksMu.Lock()
+	validators := append([]func(*viper.Viper) error{}, s.validators...)
+	s.hooksMu.Unlock()
+	for _, validator := range validators {
+		if err := validator(newConfig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watch reloads the config whenever the file it was read from changes.
+func (s *Store) Watch() {
+	path := s.Get().ConfigFileUsed()
+	if path == "" {
+		return
+	}
+	s.watchOnce.Do(func() {
+		watcher := viper.New()
+		watcher.SetConfigFile(path)
+		watcher.OnConfigChange(func(e fsnotify.Event) {
+			newConfig, err := load()
+			if err != nil {
+				log.Error("Rejected config reload, keeping previous config: ", err)
+				metrics.ConfigReloads.WithLabelValues("failure").Inc()
+				return
+			}
+			s.Reload(newConfig)
+		})
+		watcher.WatchConfig()
+	})
+}
@@ -25,6 +25,26 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/.well-known/jwks.json": {
+            "get": {
+                "description": "public keys verifying the assertions of allowed checks, empty when assertions are disabled",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "assertion keys",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.JSONWebKeySet"
+                        }
+                    }
+                }
+            }
+        },
         "/auth/check": {
             "get": {
                 "description": "check token and policy",
@@ -63,7 +83,13 @@ const docTemplate = `{
                     {
                         "type": "string",
                         "description": "Default value is Bouncer. Use 'accounts' value for Accounts Hydra",
-                        "name": "hydra",
+                        "name": "issuer",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "answer at a snapshot no older than this snaptoken",
+                        "name": "snaptoken",
                         "in": "query"
                     },
                     {
@@ -72,6 +98,12 @@ const docTemplate = `{
                         "name": "Authorization",
                         "in": "header",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "allow or deny, compared against the decision in shadow mode",
+                        "name": "X-Legacy-Decision",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -79,6 +111,20 @@ const docTemplate = `{
                         "description": "OK",
                         "schema": {
                             "type": "string"
+                        },
+                        "headers": {
+                            "X-Ozone-Assertion": {
+                                "type": "string",
+                                "description": "JWT asserting the allowed check, signed with a key of /.well-known/jwks.json, when enabled"
+                            },
+                            "X-Ozone-Shadow-Decision": {
+                                "type": "string",
+                                "description": "allow, deny or error, in shadow mode"
+                            },
+                            "X-Ozone-Shadow-Status": {
+                                "type": "string",
+                                "description": "status the check would have answered with, in shadow mode"
+                            }
                         }
                     },
                     "400": {
@@ -106,6 +152,85 @@ const docTemplate = `{
                         }
                     }
                 }
+            },
+            "post": {
+                "description": "check token and policy, with the policy in the body rather than the URL",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "auth check over POST",
+                "parameters": [
+                    {
+                        "description": "permission to check",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.CheckRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer \u003cBouncer_access_token\u003e",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "allow or deny, compared against the decision in shadow mode",
+                        "name": "X-Legacy-Decision",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Decision"
+                        },
+                        "headers": {
+                            "X-Ozone-Assertion": {
+                                "type": "string",
+                                "description": "JWT asserting the allowed check, signed with a key of /.well-known/jwks.json, when enabled"
+                            },
+                            "X-Ozone-Shadow-Decision": {
+                                "type": "string",
+                                "description": "allow, deny or error, in shadow mode"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/model.Decision"
+                        }
+                    },
+                    "424": {
+                        "description": "Failed Dependency",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
             }
         },
         "/auth/expand": {
@@ -150,6 +275,24 @@ const docTemplate = `{
                         "in": "query",
                         "required": true
                     },
+                    {
+                        "type": "string",
+                        "description": "answer at a snapshot no older than this snaptoken",
+                        "name": "snaptoken",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "tree (default), flat or dot",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Default value is Bouncer. Use 'accounts' value for Accounts Hydra",
+                        "name": "issuer",
+                        "in": "query"
+                    },
                     {
                         "type": "string",
                         "description": "Bearer \u003cBouncer_access_token\u003e",
@@ -162,7 +305,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/model.ExpandTree"
                         }
                     },
                     "400": {
@@ -183,6 +326,24 @@ const docTemplate = `{
                             "$ref": "#/definitions/model.KetoResponse"
                         }
                     },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -192,6 +353,107 @@ const docTemplate = `{
                 }
             }
         },
+        "/auth/explain": {
+            "get": {
+                "description": "find the tuples and subject sets granting a permission to a subject, or report that none do, the subject of the token needs the configured admin permission",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "explain a decision",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "resource",
+                        "name": "object",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "access-type",
+                        "name": "relation",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "subject to explain, defaults to the subject of the token",
+                        "name": "subject_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "answer at a snapshot no older than this snaptoken",
+                        "name": "snaptoken",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Default value is Bouncer. Use 'accounts' value for Accounts Hydra",
+                        "name": "issuer",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer \u003cBouncer_access_token\u003e",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Explanation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
+                    "424": {
+                        "description": "Failed Dependency",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    }
+                }
+            }
+        },
         "/auth/relation_tuples": {
             "get": {
                 "description": "query relation tuple",
@@ -255,6 +517,18 @@ const docTemplate = `{
                         "in": "query",
                         "required": true
                     },
+                    {
+                        "type": "string",
+                        "description": "answer at a snapshot no older than this snaptoken",
+                        "name": "snaptoken",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Default value is Bouncer. Use 'accounts' value for Accounts Hydra",
+                        "name": "issuer",
+                        "in": "query"
+                    },
                     {
                         "type": "string",
                         "description": "Bearer \u003cBouncer_access_token\u003e",
@@ -288,6 +562,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/model.KetoResponse"
                         }
                     },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -295,11 +575,9 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/health": {
-            "get": {
-                "description": "check health",
+            },
+            "patch": {
+                "description": "insert and delete relation tuples atomically, the subject of the token needs the configured write permission. The snaptoken of the write is only returned when keto.read.protocol is grpc, as the REST write API of Keto returns none",
                 "consumes": [
                     "application/json"
                 ],
@@ -307,35 +585,464 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "health"
+                    "auth"
                 ],
-                "summary": "health check",
+                "summary": "write relation tuples",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Default value is Bouncer. Use 'accounts' value for Accounts Hydra",
+                        "name": "issuer",
+                        "in": "query"
+                    },
                     {
                         "type": "string",
                         "description": "Bearer \u003cBouncer_access_token\u003e",
                         "name": "Authorization",
                         "in": "header",
                         "required": true
+                    },
+                    {
+                        "description": "relation tuples to insert or delete",
+                        "name": "deltas",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.RelationTupleDelta"
+                            }
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/model.WriteResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
+                    "424": {
+                        "description": "Failed Dependency",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "model.KetoResponse": {
-            "type": "object",
-            "properties": {
-                "allowed": {
-                    "type": "boolean",
+        },
+        "/auth/relation_tuples/list": {
+            "get": {
+                "description": "list relation tuples matching every given field",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "list relation tuples",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "resource",
+                        "name": "object",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "access-type",
+                        "name": "relation",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "subject",
+                        "name": "subject_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "subject_set namespace",
+                        "name": "subject_set.namespace",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "subject_set object",
+                        "name": "subject_set.object",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "subject_set relation",
+                        "name": "subject_set.relation",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_page_token of the previous page",
+                        "name": "page_token",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "tuples per page",
+                        "name": "page_size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "answer at a snapshot no older than this snaptoken",
+                        "name": "snaptoken",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Default value is Bouncer. Use 'accounts' value for Accounts Hydra",
+                        "name": "issuer",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer \u003cBouncer_access_token\u003e",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.RelationTuples"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
+                    "424": {
+                        "description": "Failed Dependency",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/model.KetoResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/health": {
+            "get": {
+                "description": "check health",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "health check",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer \u003cBouncer_access_token\u003e",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/health/ready": {
+            "get": {
+                "description": "check readiness of every Keto backend",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "readiness check",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "model.CheckRequest": {
+            "type": "object",
+            "properties": {
+                "context": {
+                    "description": "Context holds attributes of the request supplied by the caller",
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "issuer": {
+                    "type": "string",
+                    "example": "bouncer"
+                },
+                "namespace": {
+                    "type": "string",
+                    "example": "com.livspace.auth"
+                },
+                "object": {
+                    "type": "string",
+                    "example": "com.livspace.auth;bouncer;users"
+                },
+                "relation": {
+                    "type": "string",
+                    "example": "get"
+                },
+                "snaptoken": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.ConditionResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "office-network"
+                },
+                "result": {
+                    "type": "string",
+                    "enum": [
+                        "met",
+                        "not_met",
+                        "error"
+                    ],
+                    "example": "met"
+                }
+            }
+        },
+        "model.Decision": {
+            "type": "object",
+            "properties": {
+                "allowed": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "client_id": {
+                    "type": "string",
+                    "example": "bouncer-web"
+                },
+                "condition": {
+                    "description": "Condition is set when a condition applies to the permission",
+                    "$ref": "#/definitions/model.ConditionResult"
+                },
+                "issuer": {
+                    "type": "string",
+                    "example": "bouncer"
+                },
+                "mode": {
+                    "type": "string",
+                    "example": "enforce"
+                },
+                "permission": {
+                    "$ref": "#/definitions/model.SubjectSet"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "Subject has the permission"
+                },
+                "subject": {
+                    "type": "string",
+                    "example": "com.livspace.auth;bouncer;users;9338"
+                }
+            }
+        },
+        "model.ExpandTree": {
+            "type": "object",
+            "properties": {
+                "children": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.ExpandTree"
+                    }
+                },
+                "tuple": {
+                    "$ref": "#/definitions/model.RelationTuple"
+                },
+                "type": {
+                    "type": "string",
+                    "enum": [
+                        "union",
+                        "exclusion",
+                        "intersection",
+                        "leaf",
+                        "tuple_to_subject_set",
+                        "computed_subject_set",
+                        "not",
+                        "unspecified"
+                    ],
+                    "example": "union"
+                }
+            }
+        },
+        "model.Explanation": {
+            "type": "object",
+            "properties": {
+                "allowed": {
+                    "type": "boolean"
+                },
+                "permission": {
+                    "$ref": "#/definitions/model.SubjectSet"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "Subject is granted through the listed tuples"
+                },
+                "subject_id": {
+                    "type": "string",
+                    "example": "com.livspace.auth;bouncer;users;9338"
+                },
+                "subject_sets": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.SubjectSet"
+                    }
+                },
+                "tuples": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.RelationTuple"
+                    }
+                }
+            }
+        },
+        "model.JSONWebKey": {
+            "type": "object",
+            "properties": {
+                "alg": {
+                    "type": "string",
+                    "example": "ES256"
+                },
+                "crv": {
+                    "type": "string",
+                    "example": "P-256"
+                },
+                "kid": {
+                    "type": "string"
+                },
+                "kty": {
+                    "type": "string",
+                    "example": "EC"
+                },
+                "use": {
+                    "type": "string",
+                    "example": "sig"
+                },
+                "x": {
+                    "type": "string"
+                },
+                "y": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.JSONWebKeySet": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.JSONWebKey"
+                    }
+                }
+            }
+        },
+        "model.KetoResponse": {
+            "type": "object",
+            "properties": {
+                "allowed": {
+                    "type": "boolean",
                     "format": "bool",
                     "example": true
                 },
@@ -361,6 +1068,87 @@ const docTemplate = `{
                     "example": "403"
                 }
             }
+        },
+        "model.RelationTuple": {
+            "type": "object",
+            "properties": {
+                "namespace": {
+                    "type": "string",
+                    "example": "com.livspace.auth"
+                },
+                "object": {
+                    "type": "string",
+                    "example": "com.livspace.auth;bouncer;users"
+                },
+                "relation": {
+                    "type": "string",
+                    "example": "get"
+                },
+                "subject_id": {
+                    "type": "string",
+                    "example": "com.livspace.auth;bouncer;users;9338"
+                },
+                "subject_set": {
+                    "$ref": "#/definitions/model.SubjectSet"
+                }
+            }
+        },
+        "model.RelationTupleDelta": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string",
+                    "enum": [
+                        "insert",
+                        "delete"
+                    ],
+                    "example": "insert"
+                },
+                "relation_tuple": {
+                    "$ref": "#/definitions/model.RelationTuple"
+                }
+            }
+        },
+        "model.RelationTuples": {
+            "type": "object",
+            "properties": {
+                "next_page_token": {
+                    "type": "string"
+                },
+                "relation_tuples": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.RelationTuple"
+                    }
+                }
+            }
+        },
+        "model.SubjectSet": {
+            "type": "object",
+            "properties": {
+                "namespace": {
+                    "type": "string",
+                    "example": "com.livspace.auth"
+                },
+                "object": {
+                    "type": "string",
+                    "example": "com.livspace.auth;bouncer;users"
+                },
+                "relation": {
+                    "type": "string",
+                    "example": "member"
+                }
+            }
+        },
+        "model.WriteResponse": {
+            "type": "object",
+            "properties": {
+                "snaptoken": {
+                    "description": "Snaptoken of the write, only returned when keto.read.protocol is grpc",
+                    "type": "string",
+                    "example": "v42"
+                }
+            }
         }
     }
 }`
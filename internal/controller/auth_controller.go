@@ -1,30 +1,58 @@
 package controller
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/livspaceeng/ozone/internal/logging"
+	"github.com/livspaceeng/ozone/internal/metrics"
+	"github.com/livspaceeng/ozone/internal/model"
 	service "github.com/livspaceeng/ozone/internal/services"
 	"github.com/livspaceeng/ozone/internal/utils"
 )
 
+const (
+	TreeFormat = "tree"
+	FlatFormat = "flat"
+	DotFormat  = "dot"
+)
+
 type AuthController interface {
 	Check(c *gin.Context)
+	CheckDecision(c *gin.Context)
 	Query(c *gin.Context)
 	Expand(c *gin.Context)
+	List(c *gin.Context)
+	Write(c *gin.Context)
+	Explain(c *gin.Context)
 }
 
 type authController struct {
-	hydraService service.HydraService
-	ketoService  service.KetoService
+	hydraService   service.HydraService
+	ketoService    service.KetoService
+	explainService service.ExplainService
+	enforcement    *service.Enforcement
+	assertions     *service.Assertions
+	conditions     *service.Conditions
+	now            func() time.Time
 }
 
-func NewAuthController(hydraSvc service.HydraService, ketoSvc service.KetoService) AuthController {
+// NewAuthController returns an AuthController showing conditions the time
+// returned by now.
+func NewAuthController(hydraSvc service.HydraService, ketoSvc service.KetoService, explainSvc service.ExplainService, enforcement *service.Enforcement, assertions *service.Assertions, conditions *service.Conditions, now func() time.Time) AuthController {
 	return &authController{
-		hydraService: hydraSvc,
-		ketoService:  ketoSvc,
+		hydraService:   hydraSvc,
+		ketoService:    ketoSvc,
+		explainService: explainSvc,
+		enforcement:    enforcement,
+		assertions:     assertions,
+		conditions:     conditions,
+		now:            now,
 	}
 }
 
@@ -39,8 +67,13 @@ func NewAuthController(hydraSvc service.HydraService, ketoSvc service.KetoServic
 // @Param        object         query      string  true  "resource"
 // @Param        relation       query      string  true  "access-type"
 // @Param        issuer         query      string  false "Default value is Bouncer. Use 'accounts' value for Accounts Hydra"
+// @Param        snaptoken      query      string  false "answer at a snapshot no older than this snaptoken"
 // @Param        Authorization  header     string  true  "Bearer <Bouncer_access_token>"
+// @Param        X-Legacy-Decision  header  string  false "allow or deny, compared against the decision in shadow mode"
 // @Success      200         {string}  model.KetoResponse
+// @Header       200         {string}  X-Ozone-Shadow-Decision  "allow, deny or error, in shadow mode"
+// @Header       200         {string}  X-Ozone-Shadow-Status    "status the check would have answered with, in shadow mode"
+// @Header       200         {string}  X-Ozone-Assertion        "JWT asserting the allowed check, signed with a key of /.well-known/jwks.json, when enabled"
 // @Failure      400         {object}  model.KetoResponse
 // @Failure      401         {object}  model.KetoResponse
 // @Failure      403         {object}  model.KetoResponse
@@ -51,8 +84,8 @@ func (a authController) Check(c *gin.Context) {
 	headers := c.Request.Header
 	bearer := headers.Get("Authorization")
 	var (
-		namespace, relation, object, issuer string = "", "", "", ""
-		hasIssuer                           bool   = false
+		namespace, relation, object, issuer, snaptoken string = "", "", "", "", ""
+		hasIssuer                                      bool   = false
 	)
 	queries := strings.Split(c.Request.URL.RawQuery, "&")
 	for _, query := range queries {
@@ -68,29 +101,68 @@ func (a authController) Check(c *gin.Context) {
 		} else if strings.HasPrefix(query, "issuer=") {
 			hasIssuer = true
 			issuer = strings.Split(query, "=")[1]
+		} else if strings.HasPrefix(query, utils.SnaptokenString) {
+			snaptoken = strings.Split(query, "=")[1]
+			snaptoken, _ = url.QueryUnescape(snaptoken)
 		}
 	}
 
-	hydraStatus, hydraResponse, err := a.hydraService.GetSubjectByToken(c.Request.Context(), issuer, hasIssuer, bearer)
-	if hydraStatus == http.StatusFailedDependency {
-		c.JSON(hydraStatus, err)
+	ctx := utils.WithSnaptoken(utils.WithClientIP(c.Request.Context(), c.ClientIP()), snaptoken)
+	status, hydraResponse, body, _ := a.check(ctx, bearer, issuer, hasIssuer, namespace, relation, object, requestAttributes(c, a.now()), nil)
+	if status == http.StatusOK && a.assertions.Enabled() {
+		a.assert(c, hydraResponse, model.SubjectSet{Namespace: namespace, Object: object, Relation: relation})
+	}
+	if a.enforcement.Mode(c.FullPath(), namespace) == service.ShadowMode {
+		a.shadow(c, namespace, status, hydraResponse.Subject)
 		return
-	} else if hydraStatus == http.StatusUnauthorized || hydraStatus == http.StatusBadRequest {
-		c.JSON(hydraStatus, err.Error())
+	}
+	c.JSON(status, body)
+}
+
+// assert sets the assertion header of an allowed check. Failing to mint one
+// is logged and does not fail the check.
+func (a authController) assert(c *gin.Context, hydraResponse model.HydraResponse, permission model.SubjectSet) {
+	assertion, err := a.assertions.Mint(hydraResponse, permission)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Unable to mint assertion: ", err)
 		return
 	}
+	c.Header(a.assertions.Header(), assertion)
+}
+
+// check resolves the bearer token, checks the policy and, when Keto allows,
+// the condition of the permission, returning the status and body Check
+// answers with when enforcing along with the condition outcome, if any.
+func (a authController) check(ctx context.Context, bearer string, issuer string, hasIssuer bool, namespace string, relation string, object string, attributes service.RequestAttributes, checkContext map[string]interface{}) (int, model.HydraResponse, interface{}, *model.ConditionResult) {
+	//Hydra
+	hydraStatus, hydraResponse, err := a.hydraService.Introspect(ctx, issuer, hasIssuer, bearer)
+	if hydraStatus == http.StatusFailedDependency {
+		return hydraStatus, model.HydraResponse{}, err, nil
+	} else if hydraStatus == http.StatusUnauthorized || hydraStatus == http.StatusBadRequest {
+		return hydraStatus, model.HydraResponse{}, err.Error(), nil
+	}
 
 	//Keto
-	ketoStatus, ketoResponse, err := a.ketoService.ValidatePolicy(c.Request.Context(), namespace, relation, object, hydraResponse)
+	ketoStatus, ketoResponse, err := a.ketoService.ValidatePolicy(ctx, namespace, relation, object, hydraResponse.Subject)
 
-	if ketoStatus == http.StatusOK || ketoStatus == http.StatusForbidden {
-		c.JSON(ketoStatus, ketoResponse)
-		return
+	if ketoStatus == http.StatusOK {
+		//Condition
+		condition := a.conditions.Evaluate(ctx, service.ConditionInput{
+			Token:      hydraResponse,
+			Permission: model.SubjectSet{Namespace: namespace, Object: object, Relation: relation},
+			Request:    attributes,
+			Context:    checkContext,
+		})
+		if condition != nil && condition.Result != service.ConditionMet {
+			return http.StatusForbidden, hydraResponse, conditionReason(condition), condition
+		}
+		return ketoStatus, hydraResponse, ketoResponse, condition
+	} else if ketoStatus == http.StatusForbidden {
+		return ketoStatus, hydraResponse, ketoResponse, nil
 	} else if ketoStatus == http.StatusFailedDependency {
-		c.JSON(ketoStatus, err)
-		return
+		return ketoStatus, hydraResponse, err, nil
 	} else {
-		c.JSON(ketoStatus, err.Error())
+		return ketoStatus, hydraResponse, err.Error(), nil
 	}
 }
 
@@ -108,15 +180,18 @@ func (a authController) Check(c *gin.Context) {
 // @Param        subject_set.namespace   query      string  true  "subject_set namespace"
 // @Param        subject_set.object      query      string  true  "subject_set object"
 // @Param        subject_set.relation    query      string  true  "subject_set relation"
+// @Param        snaptoken               query      string  false "answer at a snapshot no older than this snaptoken"
+// @Param        issuer                  query      string  false "Default value is Bouncer. Use 'accounts' value for Accounts Hydra"
 // @Param        Authorization           header     string  true  "Bearer <Bouncer_access_token>"
 // @Success      200             {string}  model.KetoResponse
 // @Failure      400             {object}  model.KetoResponse
 // @Failure      401             {object}  model.KetoResponse
 // @Failure      403             {object}  model.KetoResponse
 // @Failure      500             {object}  model.KetoResponse
+// @Failure      429             {object}  model.KetoResponse
 // @Router       /auth/relation_tuples [get]
 func (a authController) Query(c *gin.Context) {
-	var namespace, relation, object, subjectId, subjectSetNamespace, subjectSetRelation, subjectSetObject string = "", "", "", "", "", "", ""
+	var namespace, relation, object, subjectId, subjectSetNamespace, subjectSetRelation, subjectSetObject, snaptoken string = "", "", "", "", "", "", "", ""
 	queries := strings.Split(c.Request.URL.RawQuery, "&")
 	for _, query := range queries {
 		if strings.HasPrefix(query, utils.NamespaceString) {
@@ -140,6 +215,9 @@ func (a authController) Query(c *gin.Context) {
 		} else if strings.HasPrefix(query, "subject-set-object=") {
 			subjectSetObject = strings.Split(query, "=")[1]
 			subjectSetObject, _ = url.QueryUnescape(subjectSetObject)
+		} else if strings.HasPrefix(query, utils.SnaptokenString) {
+			snaptoken = strings.Split(query, "=")[1]
+			snaptoken, _ = url.QueryUnescape(snaptoken)
 		}
 	}
 
@@ -148,10 +226,14 @@ func (a authController) Query(c *gin.Context) {
 		ketoResponse string
 		err          error
 	)
+	if !inTenant(c, service.QueryOperation, namespace) {
+		return
+	}
+	ctx := utils.WithSnaptoken(c.Request.Context(), snaptoken)
 	if len(subjectId) > 0 {
-		ketoStatus, ketoResponse, err = a.ketoService.ValidatePolicy(c.Request.Context(), namespace, relation, object, subjectId)
+		ketoStatus, ketoResponse, err = a.ketoService.ValidatePolicy(ctx, namespace, relation, object, subjectId)
 	} else {
-		ketoStatus, ketoResponse, err = a.ketoService.ValidatePolicyWithSet(c.Request.Context(), namespace, relation, object, subjectSetNamespace, subjectSetRelation, subjectSetObject)
+		ketoStatus, ketoResponse, err = a.ketoService.ValidatePolicyWithSet(ctx, namespace, relation, object, subjectSetNamespace, subjectSetRelation, subjectSetObject)
 	}
 
 	if ketoStatus == http.StatusOK || ketoStatus == http.StatusForbidden {
@@ -176,17 +258,23 @@ func (a authController) Query(c *gin.Context) {
 // @Param        max-depth      query     integer  true  "max-depth to expand tuple"
 // @Param        object         query     string   true  "resource"
 // @Param        relation       query     string   true  "access-type"
+// @Param        snaptoken      query     string   false "answer at a snapshot no older than this snaptoken"
+// @Param        format         query     string   false "tree (default), flat or dot"
+// @Param        issuer         query     string   false "Default value is Bouncer. Use 'accounts' value for Accounts Hydra"
 // @Param        Authorization  header    string   true  "Bearer <Bouncer_access_token>"
-// @Success      200             {string}  model.KetoResponse
+// @Success      200             {object}  model.ExpandTree
 // @Failure      400             {object}  model.KetoResponse
 // @Failure      401             {object}  model.KetoResponse
 // @Failure      403             {object}  model.KetoResponse
+// @Failure      404             {object}  model.KetoResponse
+// @Failure      422             {object}  model.KetoResponse
 // @Failure      500             {object}  model.KetoResponse
+// @Failure      429             {object}  model.KetoResponse
 // @Router       /auth/expand [get]
 func (a authController) Expand(c *gin.Context) {
 	var (
-		namespace, relation, object, maxDepth string = "", "", "", ""
-		hasDepth                              bool   = false
+		namespace, relation, object, maxDepth, snaptoken, format string = "", "", "", "", "", ""
+		hasDepth                                                 bool   = false
 	)
 	queries := strings.Split(c.Request.URL.RawQuery, "&")
 	for _, query := range queries {
@@ -202,18 +290,254 @@ func (a authController) Expand(c *gin.Context) {
 		} else if strings.HasPrefix(query, utils.RelationString) {
 			relation = strings.Split(query, "=")[1]
 			relation, _ = url.QueryUnescape(relation)
+		} else if strings.HasPrefix(query, utils.SnaptokenString) {
+			snaptoken = strings.Split(query, "=")[1]
+			snaptoken, _ = url.QueryUnescape(snaptoken)
+		} else if strings.HasPrefix(query, "format=") {
+			format = strings.Split(query, "=")[1]
+		}
+	}
+	if format != "" && format != TreeFormat && format != FlatFormat && format != DotFormat {
+		c.JSON(http.StatusBadRequest, utils.InvalidError)
+		return
+	}
+
+	if !inTenant(c, service.ExpandOperation, namespace) {
+		return
+	}
+	ctx := utils.WithSnaptoken(c.Request.Context(), snaptoken)
+	ketoStatus, ketoResponse, err := a.ketoService.ExpandPolicy(ctx, namespace, relation, object, maxDepth, hasDepth)
+	if ketoStatus == http.StatusFailedDependency {
+		c.JSON(ketoStatus, err)
+		return
+	} else if ketoStatus != http.StatusOK {
+		c.JSON(ketoStatus, err.Error())
+		return
+	}
+
+	switch format {
+	case FlatFormat:
+		flat, err := service.FlattenExpandTree(*ketoResponse)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, err.Error())
+			return
 		}
+		c.JSON(http.StatusOK, flat)
+	case DotFormat:
+		c.Data(http.StatusOK, "text/vnd.graphviz; charset=utf-8", []byte(service.ExpandTreeToDot(*ketoResponse)))
+	default:
+		c.JSON(http.StatusOK, ketoResponse)
 	}
+}
 
-	ketoStatus, ketoResponse, err := a.ketoService.ExpandPolicy(c.Request.Context(), namespace, relation, object, maxDepth, hasDepth)
+// AuthController godoc
+// @Summary      list relation tuples
+// @Schemes      http
+// @Description  list relation tuples matching every given field
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        namespace               query      string   false  "namespace"
+// @Param        object                  query      string   false  "resource"
+// @Param        relation                query      string   false  "access-type"
+// @Param        subject_id              query      string   false  "subject"
+// @Param        subject_set.namespace   query      string   false  "subject_set namespace"
+// @Param        subject_set.object      query      string   false  "subject_set object"
+// @Param        subject_set.relation    query      string   false  "subject_set relation"
+// @Param        page_token              query      string   false  "next_page_token of the previous page"
+// @Param        page_size               query      integer  false  "tuples per page"
+// @Param        snaptoken               query      string   false  "answer at a snapshot no older than this snaptoken"
+// @Param        issuer                  query      string   false  "Default value is Bouncer. Use 'accounts' value for Accounts Hydra"
+// @Param        Authorization           header     string   true   "Bearer <Bouncer_access_token>"
+// @Success      200             {object}  model.RelationTuples
+// @Failure      400             {object}  model.KetoResponse
+// @Failure      401             {object}  model.KetoResponse
+// @Failure      403             {object}  model.KetoResponse
+// @Failure      424             {object}  model.KetoResponse
+// @Failure      429             {object}  model.KetoResponse
+// @Router       /auth/relation_tuples/list [get]
+func (a authController) List(c *gin.Context) {
+	params := rawQuery(c)
+	query := model.RelationTuple{
+		Namespace: params.Get("namespace"),
+		Object:    params.Get("object"),
+		Relation:  params.Get("relation"),
+		SubjectId: params.Get("subject_id"),
+	}
+	if params.Has("subject_set.namespace") || params.Has("subject_set.object") || params.Has("subject_set.relation") {
+		query.SubjectSet = &model.SubjectSet{
+			Namespace: params.Get("subject_set.namespace"),
+			Object:    params.Get("subject_set.object"),
+			Relation:  params.Get("subject_set.relation"),
+		}
+	}
 
-	if ketoStatus == http.StatusOK || ketoStatus == http.StatusNotFound {
+	if !inTenant(c, service.QueryOperation, query.Namespace) {
+		return
+	}
+	ctx := utils.WithSnaptoken(c.Request.Context(), params.Get("snaptoken"))
+	ketoStatus, ketoResponse, err := a.ketoService.ListRelationTuples(ctx, query, params.Get("page_token"), params.Get("page_size"))
+	if ketoStatus == http.StatusOK {
 		c.JSON(ketoStatus, ketoResponse)
 		return
-	} else if ketoStatus == http.StatusFailedDependency {
-		c.JSON(ketoStatus, err)
+	}
+	c.JSON(ketoStatus, err.Error())
+}
+
+// AuthController godoc
+// @Summary      write relation tuples
+// @Schemes      http
+// @Description  insert and delete relation tuples atomically, the subject of the token needs the configured write permission. The snaptoken of the write is only returned when keto.read.protocol is grpc, as the REST write API of Keto returns none
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        issuer         query     string  false "Default value is Bouncer. Use 'accounts' value for Accounts Hydra"
+// @Param        Authorization  header    string  true  "Bearer <Bouncer_access_token>"
+// @Param        deltas         body      []model.RelationTupleDelta  true  "relation tuples to insert or delete"
+// @Success      200             {object}  model.WriteResponse
+// @Failure      400             {object}  model.KetoResponse
+// @Failure      401             {object}  model.KetoResponse
+// @Failure      403             {object}  model.KetoResponse
+// @Failure      424             {object}  model.KetoResponse
+// @Failure      429             {object}  model.KetoResponse
+// @Router       /auth/relation_tuples [patch]
+func (a authController) Write(c *gin.Context) {
+	_, hasIssuer := c.GetQuery("issuer")
+	ctx := utils.WithClientIP(c.Request.Context(), c.ClientIP())
+	hydraStatus, subject, err := a.hydraService.GetSubjectByToken(ctx, c.Query("issuer"), hasIssuer, c.GetHeader("Authorization"))
+	if hydraStatus != http.StatusOK {
+		c.JSON(hydraStatus, err.Error())
 		return
-	} else {
+	}
+
+	permission, configured := a.enforcement.WritePermission()
+	if !configured {
+		c.JSON(http.StatusForbidden, "Writes are not enabled")
+		return
+	}
+	if !a.authorize(c, ctx, subject, permission, "Subject is not allowed to write relation tuples") {
+		return
+	}
+
+	var deltas []model.RelationTupleDelta
+	if err := c.ShouldBindJSON(&deltas); err != nil {
+		c.JSON(http.StatusBadRequest, "Invalid relation tuple deltas")
+		return
+	}
+	namespaces := make([]string, 0, len(deltas))
+	for _, delta := range deltas {
+		namespaces = append(namespaces, delta.RelationTuple.Namespace)
+	}
+	if !inTenant(c, service.WriteOperation, namespaces...) {
+		return
+	}
+	ketoStatus, writeResponse, err := a.ketoService.PatchRelationTuples(ctx, deltas)
+	if ketoStatus == http.StatusOK {
+		c.JSON(ketoStatus, writeResponse)
+		return
+	}
+	c.JSON(ketoStatus, err.Error())
+}
+
+// AuthController godoc
+// @Summary      explain a decision
+// @Schemes      http
+// @Description  find the tuples and subject sets granting a permission to a subject, or report that none do, the subject of the token needs the configured admin permission
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        namespace      query     string  true  "namespace"
+// @Param        object         query     string  true  "resource"
+// @Param        relation       query     string  true  "access-type"
+// @Param        subject_id     query     string  false "subject to explain, defaults to the subject of the token"
+// @Param        snaptoken      query     string  false "answer at a snapshot no older than this snaptoken"
+// @Param        issuer         query     string  false "Default value is Bouncer. Use 'accounts' value for Accounts Hydra"
+// @Param        Authorization  header    string  true  "Bearer <Bouncer_access_token>"
+// @Success      200             {object}  model.Explanation
+// @Failure      400             {object}  model.KetoResponse
+// @Failure      401             {object}  model.KetoResponse
+// @Failure      403             {object}  model.KetoResponse
+// @Failure      422             {object}  model.KetoResponse
+// @Failure      424             {object}  model.KetoResponse
+// @Router       /auth/explain [get]
+func (a authController) Explain(c *gin.Context) {
+	params := rawQuery(c)
+	ctx := utils.WithClientIP(c.Request.Context(), c.ClientIP())
+	hydraStatus, subject, err := a.hydraService.GetSubjectByToken(ctx, params.Get("issuer"), params.Has("issuer"), c.GetHeader("Authorization"))
+	if hydraStatus != http.StatusOK {
+		c.JSON(hydraStatus, err.Error())
+		return
+	}
+
+	permission, configured := a.enforcement.AdminPermission()
+	if !configured {
+		c.JSON(http.StatusForbidden, "Explain is not enabled")
+		return
+	}
+	if !a.authorize(c, ctx, subject, permission, "Subject is not allowed to explain decisions") {
+		return
+	}
+
+	subjectId := params.Get("subject_id")
+	if subjectId == "" {
+		subjectId = subject
+	}
+	ctx = utils.WithSnaptoken(ctx, params.Get("snaptoken"))
+	explainStatus, explanation, err := a.explainService.Explain(ctx, params.Get("namespace"), params.Get("relation"), params.Get("object"), subjectId)
+	if explainStatus == http.StatusOK {
+		c.JSON(explainStatus, explanation)
+		return
+	}
+	c.JSON(explainStatus, err.Error())
+}
+
+// authorize checks that subject has permission, and responds with denied
+// or the Keto error when it does not.
+func (a authController) authorize(c *gin.Context, ctx context.Context, subject string, permission model.SubjectSet, denied string) bool {
+	ketoStatus, _, err := a.ketoService.ValidatePolicy(ctx, permission.Namespace, permission.Relation, permission.Object, subject)
+	if ketoStatus == http.StatusForbidden {
+		c.JSON(ketoStatus, denied)
+		return false
+	} else if ketoStatus != http.StatusOK {
 		c.JSON(ketoStatus, err.Error())
+		return false
+	}
+	return true
+}
+
+// inTenant refuses the request when its tenant, if any, has no grant for
+// operation on one of namespaces.
+func inTenant(c *gin.Context, operation string, namespaces ...string) bool {
+	tenant, found := service.TenantFromContext(c.Request.Context())
+	if !found {
+		return true
+	}
+	for _, namespace := range namespaces {
+		if namespace == "" {
+			metrics.TenantRejections.WithLabelValues(tenant.Name, "namespace").Inc()
+			c.JSON(http.StatusForbidden, fmt.Sprintf("Tenant %s has to name a namespace to %s", tenant.Name, operation))
+			return false
+		}
+		if !tenant.Allows(operation, namespace) {
+			metrics.TenantRejections.WithLabelValues(tenant.Name, "namespace").Inc()
+			c.JSON(http.StatusForbidden, fmt.Sprintf("Tenant %s may not %s namespace %s", tenant.Name, operation, namespace))
+			return false
+		}
+	}
+	return true
+}
+
+// rawQuery splits the query on & only, so that unescaped semicolons in
+// Keto object ids are kept as part of the value.
+func rawQuery(c *gin.Context) url.Values {
+	params := url.Values{}
+	for _, query := range strings.Split(c.Request.URL.RawQuery, "&") {
+		name, value, _ := strings.Cut(query, "=")
+		name, _ = url.QueryUnescape(name)
+		value, _ = url.QueryUnescape(value)
+		if name != "" {
+			params.Add(name, value)
+		}
 	}
+	return params
 }
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/livspaceeng/ozone/internal/model"
+	service "github.com/livspaceeng/ozone/internal/services"
+	"github.com/livspaceeng/ozone/internal/utils"
+)
+
+// AuthController godoc
+// @Summary      auth check over POST
+// @Schemes      http
+// @Description  check token and policy, with the policy in the body rather than the URL
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request        body       model.CheckRequest  true  "permission to check"
+// @Param        Authorization  header     string  true  "Bearer <Bouncer_access_token>"
+// @Param        X-Legacy-Decision  header  string  false "allow or deny, compared against the decision in shadow mode"
+// @Success      200         {object}  model.Decision
+// @Header       200         {string}  X-Ozone-Shadow-Decision  "allow, deny or error, in shadow mode"
+// @Header       200         {string}  X-Ozone-Assertion        "JWT asserting the allowed check, signed with a key of /.well-known/jwks.json, when enabled"
+// @Failure      400         {string}  string
+// @Failure      401         {string}  string
+// @Failure      403         {object}  model.Decision
+// @Failure      424         {string}  string
+// @Router       /auth/check [post]
+func (a authController) CheckDecision(c *gin.Context) {
+	var request model.CheckRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.Namespace == "" || request.Object == "" || request.Relation == "" {
+		c.JSON(http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	ctx := utils.WithSnaptoken(utils.WithClientIP(c.Request.Context(), c.ClientIP()), request.Snaptoken)
+	status, hydraResponse, body, condition := a.check(ctx, c.GetHeader("Authorization"), request.Issuer, request.Issuer != "", request.Namespace, request.Relation, request.Object, requestAttributes(c, a.now()), request.Context)
+	permission := model.SubjectSet{Namespace: request.Namespace, Object: request.Object, Relation: request.Relation}
+	if status == http.StatusOK && a.assertions.Enabled() {
+		a.assert(c, hydraResponse, permission)
+	}
+
+	decision := model.Decision{
+		Allowed:    status == http.StatusOK,
+		Mode:       a.enforcement.Mode(c.FullPath(), request.Namespace),
+		Subject:    hydraResponse.Subject,
+		ClientId:   hydraResponse.ClientId,
+		Issuer:     hydraResponse.Issuer,
+		Permission: permission,
+		Condition:  condition,
+	}
+	if decision.Mode == service.ShadowMode {
+		a.recordShadow(c, request.Namespace, status)
+		decision.Allowed = true
+		decision.Reason = fmt.Sprintf("Not enforced in shadow mode, the check answered %d", status)
+		c.JSON(http.StatusOK, decision)
+		return
+	}
+
+	switch {
+	case condition != nil && condition.Result != service.ConditionMet:
+		decision.Reason = conditionReason(condition)
+	case status == http.StatusOK:
+		decision.Reason = "Subject has the permission"
+	case status == http.StatusForbidden:
+		decision.Reason = "Subject does not have the permission"
+	default:
+		if err, isError := body.(error); isError {
+			body = err.Error()
+		}
+		c.JSON(status, body)
+		return
+	}
+	c.JSON(status, decision)
+}
@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/livspaceeng/ozone/internal/model"
+	service "github.com/livspaceeng/ozone/internal/services"
+)
+
+// hiddenHeaders are the request headers never shown to conditions.
+var hiddenHeaders = map[string]bool{"authorization": true, "cookie": true}
+
+// requestAttributes describes the checked request, received at now, to
+// conditions.
+func requestAttributes(c *gin.Context, now time.Time) service.RequestAttributes {
+	headers := make(map[string]string, len(c.Request.Header))
+	for name := range c.Request.Header {
+		name = strings.ToLower(name)
+		if !hiddenHeaders[name] {
+			headers[name] = c.Request.Header.Get(name)
+		}
+	}
+	return service.RequestAttributes{
+		IP:      c.ClientIP(),
+		Method:  c.Request.Method,
+		Path:    c.Request.URL.Path,
+		Headers: headers,
+		Time:    now,
+	}
+}
+
+// conditionReason tells why a condition denied a check allowed by Keto.
+func conditionReason(condition *model.ConditionResult) string {
+	if condition.Result == service.ConditionError {
+		return fmt.Sprintf("Condition %s could not be evaluated", condition.Name)
+	}
+	return fmt.Sprintf("Condition %s is not met", condition.Name)
+}
@@ -4,16 +4,23 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/livspaceeng/ozone/internal/metrics"
+	"github.com/livspaceeng/ozone/internal/utils"
 )
 
 type HealthController interface {
 	Status(c *gin.Context)
+	Ready(c *gin.Context)
 }
 
-type healthController struct{}
+type healthController struct {
+	ketoRouter *utils.KetoRouter
+}
 
-func NewHealthController() HealthController {
-	return &healthController{}
+func NewHealthController(ketoRouter *utils.KetoRouter) HealthController {
+	return &healthController{
+		ketoRouter: ketoRouter,
+	}
 }
 
 // HealthController godoc
@@ -23,9 +30,35 @@ func NewHealthController() HealthController {
 // @Tags         health
 // @Accept       json
 // @Produce      json
-// @Param        Authorization  header    string   true  "Bearer <Bouncer_access_token>" 
+// @Param        Authorization  header    string   true  "Bearer <Bouncer_access_token>"
 // @Success      200  {string}  OK!
 // @Router       /health [get]
 func (h healthController) Status(c *gin.Context) {
 	c.String(http.StatusOK, "OK!")
 }
+
+// HealthController godoc
+// @Summary      readiness check
+// @Schemes      http
+// @Description  check readiness of every Keto backend
+// @Tags         health
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  map[string]string
+// @Failure      503  {object}  map[string]string
+// @Router       /health/ready [get]
+func (h healthController) Ready(c *gin.Context) {
+	status := http.StatusOK
+	backends := make(map[string]string)
+	for name, err := range h.ketoRouter.Health(c.Request.Context()) {
+		if err != nil {
+			status = http.StatusServiceUnavailable
+			backends[name] = err.Error()
+			metrics.KetoBackendUp.WithLabelValues(name).Set(0)
+			continue
+		}
+		backends[name] = "ok"
+		metrics.KetoBackendUp.WithLabelValues(name).Set(1)
+	}
+	c.JSON(status, backends)
+}
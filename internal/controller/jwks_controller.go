@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	service "github.com/livspaceeng/ozone/internal/services"
+)
+
+type JWKSController interface {
+	Keys(c *gin.Context)
+}
+
+type jwksController struct {
+	assertions *service.Assertions
+}
+
+func NewJWKSController(assertions *service.Assertions) JWKSController {
+	return &jwksController{
+		assertions: assertions,
+	}
+}
+
+// JWKSController godoc
+// @Summary      assertion keys
+// @Schemes      http
+// @Description  public keys verifying the assertions of allowed checks, empty when assertions are disabled
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  model.JSONWebKeySet
+// @Router       /.well-known/jwks.json [get]
+func (j jwksController) Keys(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, j.assertions.JWKS())
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/livspaceeng/ozone/internal/logging"
+	"github.com/livspaceeng/ozone/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	AllowDecision = "allow"
+	DenyDecision  = "deny"
+	ErrorDecision = "error"
+
+	ShadowDecisionHeader = "X-Ozone-Shadow-Decision"
+	ShadowStatusHeader   = "X-Ozone-Shadow-Status"
+	ShadowMismatchHeader = "X-Ozone-Shadow-Mismatch"
+)
+
+// decision classifies the status Check would have answered with.
+func decision(status int) string {
+	switch {
+	case status == http.StatusOK:
+		return AllowDecision
+	case status == http.StatusFailedDependency || status >= http.StatusInternalServerError:
+		return ErrorDecision
+	default:
+		return DenyDecision
+	}
+}
+
+// shadow answers a Check in shadow mode: the request is always allowed, the
+// real decision is logged, counted and returned in response headers, and
+// compared against the legacy decision header when the caller sent one.
+func (a authController) shadow(c *gin.Context, namespace string, status int, subject string) {
+	a.recordShadow(c, namespace, status)
+	c.JSON(http.StatusOK, subject)
+}
+
+// recordShadow logs, counts and sets the headers of a shadow decision.
+func (a authController) recordShadow(c *gin.Context, namespace string, status int) {
+	ctx := c.Request.Context()
+	shadowDecision := decision(status)
+	fields := log.Fields{"mode": "shadow", "shadow_decision": shadowDecision, "shadow_status": status}
+	metrics.ShadowDecisions.WithLabelValues(namespace, shadowDecision).Inc()
+	c.Header(ShadowDecisionHeader, shadowDecision)
+	c.Header(ShadowStatusHeader, strconv.Itoa(status))
+
+	if legacy := strings.ToLower(strings.TrimSpace(c.GetHeader(a.enforcement.LegacyHeader()))); legacy != "" {
+		fields["legacy_decision"] = legacy
+		if legacy != shadowDecision {
+			metrics.ShadowMismatches.WithLabelValues(namespace).Inc()
+			c.Header(ShadowMismatchHeader, "true")
+			logging.FromContext(ctx).WithFields(fields).Warn("Shadow decision differs from legacy decision for namespace: ", namespace)
+		}
+	}
+	logging.AddFields(ctx, fields)
+	logging.FromContext(ctx).Info("Shadow decision for namespace: ", namespace)
+}
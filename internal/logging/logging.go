@@ -0,0 +1,109 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	"github.com/livspaceeng/ozone/configs"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	JSONFormat = "json"
+	TextFormat = "text"
+)
+
+type contextKey string
+
+const loggerKey contextKey = "logger"
+
+// requestLogger is shared through the request context so that fields added
+// deeper in the stack (issuer, subject) show up in every later log line.
+type requestLogger struct {
+	entry *log.Entry
+}
+
+func init() {
+	configs.RegisterValidator(func(config *viper.Viper) error {
+		_, err := formatter(config)
+		return err
+	})
+}
+
+// Configure applies log.level, log.format and log.sampling to the standard
+// logger. It is called at startup and on every config reload.
+func Configure(config *viper.Viper) error {
+	level, err := log.ParseLevel(config.GetString("log.level"))
+	if err != nil {
+		return err
+	}
+	logFormatter, err := formatter(config)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(level)
+	log.SetFormatter(logFormatter)
+	return nil
+}
+
+func formatter(config *viper.Viper) (log.Formatter, error) {
+	var logFormatter log.Formatter
+	switch format := config.GetString("log.format"); format {
+	case "", JSONFormat:
+		logFormatter = &log.JSONFormatter{}
+	case TextFormat:
+		logFormatter = &log.TextFormatter{}
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	rate := 1.0
+	if config.IsSet("log.sampling.rate") {
+		rate = config.GetFloat64("log.sampling.rate")
+	}
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("log.sampling.rate must be between 0 and 1")
+	}
+	if rate < 1 {
+		logFormatter = &samplingFormatter{Formatter: logFormatter, rate: rate}
+	}
+	return logFormatter, nil
+}
+
+// samplingFormatter keeps only a fraction of info and more verbose entries.
+// Warnings and errors are never dropped.
+type samplingFormatter struct {
+	log.Formatter
+	rate float64
+}
+
+func (f *samplingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	if entry.Level >= log.InfoLevel && rand.Float64() >= f.rate {
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
+}
+
+// WithLogger returns a copy of ctx carrying a request scoped logger.
+func WithLogger(ctx context.Context, entry *log.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey, &requestLogger{entry: entry})
+}
+
+// FromContext returns the request scoped logger of ctx, or the standard
+// logger outside of a request.
+func FromContext(ctx context.Context) *log.Entry {
+	if logger, ok := ctx.Value(loggerKey).(*requestLogger); ok {
+		return logger.entry
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// AddFields adds fields to the request scoped logger of ctx, so that they
+// are carried by every following log line of the request.
+func AddFields(ctx context.Context, fields log.Fields) {
+	if logger, ok := ctx.Value(loggerKey).(*requestLogger); ok {
+		logger.entry = logger.entry.WithFields(fields)
+	}
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "ozone"
+
+// NegativeCacheSource labels rejections answered from the negative token cache.
+const NegativeCacheSource = "negative_cache"
+
+var (
+	// UpstreamRequests counts calls that actually left ozone for Hydra or Keto.
+	UpstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_requests_total",
+		Help:      "Number of requests sent to an upstream (hydra, keto).",
+	}, []string{"upstream"})
+
+	// CoalescedRequests counts callers that were served by another in-flight call for the same key.
+	CoalescedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "coalesced_requests_total",
+		Help:      "Number of upstream calls avoided by joining an identical in-flight call.",
+	}, []string{"upstream"})
+
+	// InvalidTokens counts rejected tokens by where the rejection came from.
+	InvalidTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "invalid_tokens_total",
+		Help:      "Number of requests rejected because of an invalid or inactive token.",
+	}, []string{"source"})
+
+	// RateLimitedRequests counts requests rejected by the rate limiter.
+	RateLimitedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Number of requests rejected with 429 by the rate limiter.",
+	}, []string{"route", "key"})
+
+	// ConfigReloads counts config file reloads by result (success, failure).
+	ConfigReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Number of config reloads, by result.",
+	}, []string{"result"})
+
+	// KetoRequests counts Keto calls by backend cluster.
+	KetoRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "keto_requests_total",
+		Help:      "Number of requests sent to Keto, by backend.",
+	}, []string{"backend"})
+
+	// KetoBackendUp is 1 when the last readiness check of a Keto backend passed.
+	KetoBackendUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "keto_backend_up",
+		Help:      "Whether the last readiness check of a Keto backend passed.",
+	}, []string{"backend"})
+
+	// ShadowDecisions counts decisions evaluated but not enforced in shadow mode.
+	ShadowDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shadow_decisions_total",
+		Help:      "Number of shadow mode decisions, by namespace and decision (allow, deny, error).",
+	}, []string{"namespace", "decision"})
+
+	// ShadowMismatches counts shadow decisions that differ from the legacy decision header.
+	ShadowMismatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shadow_mismatches_total",
+		Help:      "Number of shadow mode decisions differing from the legacy decision, by namespace.",
+	}, []string{"namespace"})
+
+	// KetoDecisionCache counts Keto check decisions by cache result (hit, miss, bypass).
+	KetoDecisionCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "keto_decision_cache_total",
+		Help:      "Number of Keto checks by decision cache result (hit, miss, bypass).",
+	}, []string{"result"})
+
+	// TenantRequests counts requests by tenant and route.
+	TenantRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tenant_requests_total",
+		Help:      "Number of requests by tenant and route.",
+	}, []string{"tenant", "route"})
+
+	// TenantRejections counts requests refused by tenancy, by reason (unknown, quota, namespace).
+	TenantRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tenant_rejections_total",
+		Help:      "Number of requests refused by tenancy, by tenant and reason (unknown, quota, namespace).",
+	}, []string{"tenant", "reason"})
+
+	// APIKeyLastUsed is the unix time an API key of a client was last used.
+	APIKeyLastUsed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "apikey_last_used_timestamp_seconds",
+		Help:      "Unix time an API key was last used, by issuer and client_id.",
+	}, []string{"issuer", "client_id"})
+
+	// TokenCache counts token cache lookups and stores by issuer and result (hit, miss, negative_hit, uncached, refresh).
+	TokenCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_cache_total",
+		Help:      "Number of token cache lookups and stores, by issuer and result (hit, miss, negative_hit, uncached, refresh).",
+	}, []string{"issuer", "result"})
+
+	// TokenCacheTTL observes the TTL tokens are cached for, by issuer.
+	TokenCacheTTL = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "token_cache_ttl_seconds",
+		Help:      "TTL introspection responses are cached for, by issuer.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	}, []string{"issuer"})
+)
@@ -0,0 +1,31 @@
+package model
+
+// Assertion is the claim set of the internal JWT ozone mints on an allowed
+// check, for services behind ozone to trust without introspecting again.
+type Assertion struct {
+	Issuer      string     `json:"iss" example:"ozone"`
+	Subject     string     `json:"sub" example:"com.livspace.auth;bouncer;users;9338"`
+	Audience    string     `json:"aud,omitempty" example:"livspace-services"`
+	IssuedAt    int64      `json:"iat" example:"1674130274"`
+	Expiry      int64      `json:"exp" example:"1674130334"`
+	Id          string     `json:"jti" example:"0f8fad5bd9cb469fa16570867728950e"`
+	ClientId    string     `json:"client_id,omitempty" example:"client-123"`
+	Scope       string     `json:"scope,omitempty" example:"offline"`
+	TokenIssuer string     `json:"token_issuer" example:"bouncer"`
+	Permission  SubjectSet `json:"permission"`
+}
+
+// JSONWebKey is the public part of an assertion signing key.
+type JSONWebKey struct {
+	Kty string `json:"kty" example:"EC"`
+	Crv string `json:"crv" example:"P-256"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Kid string `json:"kid"`
+	Use string `json:"use" example:"sig"`
+	Alg string `json:"alg" example:"ES256"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
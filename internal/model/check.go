@@ -0,0 +1,35 @@
+package model
+
+// CheckRequest is the body of a check over POST. The subject is the one of
+// the Authorization header, or of the client certificate.
+type CheckRequest struct {
+	Namespace string `json:"namespace" example:"com.livspace.auth"`
+	Object    string `json:"object" example:"com.livspace.auth;bouncer;users"`
+	Relation  string `json:"relation" example:"get"`
+	Issuer    string `json:"issuer,omitempty" example:"bouncer"`
+	Snaptoken string `json:"snaptoken,omitempty"`
+	// Context holds attributes of the request supplied by the caller
+	Context map[string]interface{} `json:"context,omitempty"`
+}
+
+// Decision is the typed answer of a check over POST. Allowed is what the
+// caller has to enforce, always true in shadow mode.
+type Decision struct {
+	Allowed    bool       `json:"allowed" example:"true"`
+	Mode       string     `json:"mode" example:"enforce"`
+	Subject    string     `json:"subject" example:"com.livspace.auth;bouncer;users;9338"`
+	ClientId   string     `json:"client_id,omitempty" example:"bouncer-web"`
+	Issuer     string     `json:"issuer,omitempty" example:"bouncer"`
+	Permission SubjectSet `json:"permission"`
+	Reason     string     `json:"reason" example:"Subject has the permission"`
+	// Condition is set when a condition applies to the permission
+	Condition *ConditionResult `json:"condition,omitempty"`
+}
+
+// ConditionResult is the outcome of the condition a check allowed by Keto
+// also had to pass.
+type ConditionResult struct {
+	Name   string `json:"name" example:"office-network"`
+	Result string `json:"result" example:"met" enums:"met,not_met,error"`
+	Error  string `json:"error,omitempty"`
+}
@@ -0,0 +1,22 @@
+package model
+
+// ExpandTree is a node of the tree Keto expands a subject set into. Leaves
+// carry a subject id or a subject set that was not expanded further.
+type ExpandTree struct {
+	Type     string         `json:"type" example:"union" enums:"union,exclusion,intersection,leaf,tuple_to_subject_set,computed_subject_set,not,unspecified"`
+	Tuple    *RelationTuple `json:"tuple,omitempty"`
+	Children []ExpandTree   `json:"children,omitempty"`
+}
+
+// ExpandSubject is a subject granted by an expand tree, with the subject sets
+// leading from the expanded one to it.
+type ExpandSubject struct {
+	SubjectId  string       `json:"subject_id,omitempty" example:"com.livspace.auth;bouncer;users;9338"`
+	SubjectSet *SubjectSet  `json:"subject_set,omitempty"`
+	Path       []SubjectSet `json:"path"`
+}
+
+// FlatExpandTree is the deduplicated set of subjects granted by an expand tree.
+type FlatExpandTree struct {
+	Subjects []ExpandSubject `json:"subjects"`
+}
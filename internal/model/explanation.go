@@ -0,0 +1,11 @@
+package model
+
+// Explanation tells why a subject has, or does not have, a permission.
+type Explanation struct {
+	Allowed     bool            `json:"allowed"`
+	SubjectId   string          `json:"subject_id" example:"com.livspace.auth;bouncer;users;9338"`
+	Permission  SubjectSet      `json:"permission"`
+	Tuples      []RelationTuple `json:"tuples"`
+	SubjectSets []SubjectSet    `json:"subject_sets"`
+	Reason      string          `json:"reason" example:"Subject is granted through the listed tuples"`
+}
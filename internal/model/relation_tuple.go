@@ -0,0 +1,30 @@
+package model
+
+type SubjectSet struct {
+	Namespace string `json:"namespace" example:"com.livspace.auth"`
+	Object    string `json:"object" example:"com.livspace.auth;bouncer;users"`
+	Relation  string `json:"relation" example:"member"`
+}
+
+type RelationTuple struct {
+	Namespace  string      `json:"namespace" example:"com.livspace.auth"`
+	Object     string      `json:"object" example:"com.livspace.auth;bouncer;users"`
+	Relation   string      `json:"relation" example:"get"`
+	SubjectId  string      `json:"subject_id,omitempty" example:"com.livspace.auth;bouncer;users;9338"`
+	SubjectSet *SubjectSet `json:"subject_set,omitempty"`
+}
+
+type RelationTuples struct {
+	RelationTuples []RelationTuple `json:"relation_tuples"`
+	NextPageToken  string          `json:"next_page_token"`
+}
+
+type RelationTupleDelta struct {
+	Action        string        `json:"action" example:"insert" enums:"insert,delete"`
+	RelationTuple RelationTuple `json:"relation_tuple"`
+}
+
+type WriteResponse struct {
+	// Snaptoken of the write, only returned when keto.read.protocol is grpc
+	Snaptoken string `json:"snaptoken,omitempty" example:"v42"`
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/services"
+	"github.com/livspaceeng/ozone/internal/utils"
+	"github.com/patrickmn/go-cache"
+	"github.com/spf13/viper"
+)
+
+// App is one ozone instance: its config and every dependency the router
+// needs. Dependencies that are not injected are built from the config.
+type App struct {
+	Config         *configs.Store
+	HttpClient     *http.Client
+	Cache          *cache.Cache
+	Clock          func() time.Time
+	KetoRouter     *utils.KetoRouter
+	HydraService   services.HydraService
+	KetoService    services.KetoService
+	Schema         *services.Schema
+	ExplainService services.ExplainService
+	Enforcement    *services.Enforcement
+	Tenancy        *services.Tenancy
+	Assertions     *services.Assertions
+	Conditions     *services.Conditions
+}
+
+type Option func(*App)
+
+func WithHttpClient(httpClient *http.Client) Option {
+	return func(app *App) {
+		app.HttpClient = httpClient
+	}
+}
+
+func WithCache(cacheClient *cache.Cache) Option {
+	return func(app *App) {
+		app.Cache = cacheClient
+	}
+}
+
+func WithClock(now func() time.Time) Option {
+	return func(app *App) {
+		app.Clock = now
+	}
+}
+
+func WithKetoRouter(ketoRouter *utils.KetoRouter) Option {
+	return func(app *App) {
+		app.KetoRouter = ketoRouter
+	}
+}
+
+func WithHydraService(hydraSvc services.HydraService) Option {
+	return func(app *App) {
+		app.HydraService = hydraSvc
+	}
+}
+
+func WithKetoService(ketoSvc services.KetoService) Option {
+	return func(app *App) {
+		app.KetoService = ketoSvc
+	}
+}
+
+func NewApp(config *configs.Store, opts ...Option) (*App, error) {
+	app := &App{Config: config}
+	for _, opt := range opts {
+		opt(app)
+	}
+
+	if app.HttpClient == nil {
+		app.HttpClient = utils.NewTracingClient()
+	}
+	if app.Cache == nil {
+		app.Cache = cache.New(5*time.Minute, 10*time.Minute)
+	}
+	if app.Clock == nil {
+		app.Clock = time.Now
+	}
+	if app.KetoRouter == nil {
+		ketoRouter, err := utils.NewKetoRouter(config.Get(), app.HttpClient)
+		if err != nil {
+			return nil, err
+		}
+		config.OnValidate(ketoRouter.Validate)
+		config.OnReload(func(newConfig *viper.Viper) {
+			ketoRouter.Reload(newConfig)
+		})
+		app.KetoRouter = ketoRouter
+	}
+	if app.Enforcement == nil {
+		enforcement, err := services.NewEnforcement(config)
+		if err != nil {
+			return nil, err
+		}
+		app.Enforcement = enforcement
+	}
+	if app.Tenancy == nil {
+		tenancy, err := services.NewTenancy(config)
+		if err != nil {
+			return nil, err
+		}
+		app.Tenancy = tenancy
+	}
+	if app.Assertions == nil {
+		assertions, err := services.NewAssertions(config, app.Clock)
+		if err != nil {
+			return nil, err
+		}
+		app.Assertions = assertions
+	}
+	if app.Conditions == nil {
+		conditions, err := services.NewConditions(config)
+		if err != nil {
+			return nil, err
+		}
+		app.Conditions = conditions
+	}
+	if app.HydraService == nil {
+		app.HydraService = services.NewHydraService(config, app.HttpClient, app.Cache, app.Clock)
+	}
+	if app.Schema == nil {
+		schema, err := services.NewSchema(config, app.KetoRouter, app.Clock)
+		if err != nil {
+			return nil, err
+		}
+		app.Schema = schema
+	}
+	if app.KetoService == nil {
+		if utils.KetoProtocol(config.Get()) == utils.GrpcProtocol {
+			app.KetoService = services.NewKetoGrpcService(config, app.KetoRouter, app.Schema)
+		} else {
+			app.KetoService = services.NewKetoService(config, app.KetoRouter, app.Schema)
+		}
+	}
+	if app.ExplainService == nil {
+		app.ExplainService = services.NewExplainService(config, app.KetoService)
+	}
+	return app, nil
+}
+
+// Run serves the app on server.address, over TLS when server.tls.enabled.
+func (app *App) Run() error {
+	config := app.Config.Get()
+	router := NewRouter(app)
+	if !config.GetBool("server.tls.enabled") {
+		return router.Run(config.GetString("server.address"))
+	}
+	tlsConfig, err := utils.NewServerTLSConfig(config)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Addr:      config.GetString("server.address"),
+		Handler:   router,
+		TLSConfig: tlsConfig,
+	}
+	return server.ListenAndServeTLS("", "")
+}
@@ -1,51 +1,62 @@
 package server
 
 import (
-	"net/http"
-	"time"
-
 	"github.com/gin-gonic/gin"
-	"github.com/livspaceeng/ozone/configs"
 	docs "github.com/livspaceeng/ozone/docs"
 	"github.com/livspaceeng/ozone/internal/controller"
-	"github.com/livspaceeng/ozone/internal/services"
-	"github.com/livspaceeng/ozone/internal/utils"
-	"github.com/patrickmn/go-cache"
+	"github.com/livspaceeng/ozone/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-var (
-	httpClient                                = &http.Client{}
-	cacheClient                               = cache.New(5*time.Minute, 10*time.Minute)
-	httpClientInterface utils.HttpClient      = utils.NewHttpClient(httpClient)
-	hydraService        services.HydraService = services.NewHydraService(httpClient, cacheClient)
-	ketoService         services.KetoService  = services.NewKetoService(httpClient)
-
-	authController   controller.AuthController   = controller.NewAuthController(hydraService, ketoService)
-	healthController controller.HealthController = controller.NewHealthController()
-)
+func NewRouter(app *App) *gin.Engine {
+	config := app.Config.Get()
+	authController := controller.NewAuthController(app.HydraService, app.KetoService, app.ExplainService, app.Enforcement, app.Assertions, app.Conditions, app.Clock)
+	healthController := controller.NewHealthController(app.KetoRouter)
+	jwksController := controller.NewJWKSController(app.Assertions)
 
-func NewRouter() *gin.Engine {
-	config := configs.GetConfig()
-	var router *gin.Engine
 	if config.GetBool("server.release_mode") {
 		gin.SetMode(gin.ReleaseMode)
-		router = gin.New()
-	} else {
-		router = gin.Default()
 	}
+	router := gin.New()
+	router.Use(gin.Recovery())
 	router.Use(otelgin.Middleware("ozone"))
+	router.Use(middleware.RequestLogger())
+	router.Use(middleware.ClientCertificate(config.GetBool("server.tls.common_name_subject")))
+	router.Use(middleware.Introspection())
+	if config.GetBool("ratelimit.enabled") {
+		rateLimit, err := middleware.NewRateLimitFromConfig(app.Config, app.HydraService, app.Clock)
+		if err != nil {
+			log.Fatal("Invalid ratelimit config: ", err)
+		}
+		router.Use(rateLimit)
+	}
 	docs.SwaggerInfo.BasePath = "/api/v1"
 
 	router.GET("/health", healthController.Status)
+	router.GET("/health/ready", healthController.Ready)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/.well-known/jwks.json", jwksController.Keys)
+
+	tenancy, err := middleware.NewTenancyFromConfig(app.Config, app.Tenancy, app.HydraService, app.Clock)
+	if err != nil {
+		log.Fatal("Invalid tenancy config: ", err)
+	}
+
+	access := middleware.Access(app.Enforcement, app.HydraService, app.KetoService)
 
 	authResolver := router.Group("/api/v1/auth")
 	{
 		authResolver.GET("/check", authController.Check)
-		authResolver.GET("/expand", authController.Expand)
-		authResolver.GET("/relation_tuples", authController.Query)
+		authResolver.POST("/check", authController.CheckDecision)
+		authResolver.GET("/expand", access, tenancy, authController.Expand)
+		authResolver.GET("/explain", authController.Explain)
+		authResolver.GET("/relation_tuples", access, tenancy, authController.Query)
+		authResolver.GET("/relation_tuples/list", access, tenancy, authController.List)
+		authResolver.PATCH("/relation_tuples", tenancy, authController.Write)
 	}
 
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
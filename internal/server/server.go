@@ -2,12 +2,16 @@ package server
 
 import (
 	"github.com/livspaceeng/ozone/configs"
-	"github.com/livspaceeng/ozone/internal/utils"
-) 
+	log "github.com/sirupsen/logrus"
+)
 
+// Init serves the process wide config loaded by configs.Init.
 func Init() {
-	utils.Init()
-	configs.Init()
-	r := NewRouter()
-	r.Run(configs.GetConfig().GetString("server.address"))
+	app, err := NewApp(configs.GetStore())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = app.Run(); err != nil {
+		log.Fatal(err)
+	}
 }
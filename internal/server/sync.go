@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/livspaceeng/ozone/internal/services"
+)
+
+// Exit codes of Sync.
+const (
+	SyncOK    = 0
+	SyncError = 1
+	SyncDrift = 2
+)
+
+// Sync runs the sync subcommand: it reconciles Keto with the tuple manifests
+// of a directory and returns the exit code of the process. With -check it
+// only prints the plan and exits with SyncDrift when Keto differs.
+func Sync(config *configs.Store, args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	flags.SetOutput(out)
+	dir := flags.String("dir", "", "directory of the tuple manifests")
+	owner := flags.String("owner", "default", "name recording which tuples this sync manages")
+	dryRun := flags.Bool("dry-run", false, "print the plan without writing to Keto")
+	prune := flags.Bool("prune", false, "delete managed tuples no longer in the manifests")
+	check := flags.Bool("check", false, "print the plan and exit with 2 on drift, implies -dry-run")
+	if err := flags.Parse(args); err != nil {
+		return SyncError
+	}
+	if *dir == "" {
+		fmt.Fprintln(out, "sync: -dir is required")
+		return SyncError
+	}
+
+	desired, err := services.LoadManifests(*dir)
+	if err != nil {
+		fmt.Fprintln(out, "sync:", err)
+		return SyncError
+	}
+	app, err := NewApp(config)
+	if err != nil {
+		fmt.Fprintln(out, "sync:", err)
+		return SyncError
+	}
+	tupleSync := services.NewTupleSync(config, app.KetoService, *owner)
+	ctx := context.Background()
+	plan, err := tupleSync.Plan(ctx, desired)
+	if err != nil {
+		fmt.Fprintln(out, "sync:", err)
+		return SyncError
+	}
+	printPlan(out, plan, *prune)
+
+	if *check {
+		if plan.Drift() {
+			return SyncDrift
+		}
+		return SyncOK
+	}
+	if *dryRun {
+		return SyncOK
+	}
+	if err := tupleSync.Apply(ctx, plan, *prune); err != nil {
+		fmt.Fprintln(out, "sync:", err)
+		return SyncError
+	}
+	return SyncOK
+}
+
+func printPlan(out io.Writer, plan services.SyncPlan, prune bool) {
+	printTuples := func(prefix string, tuples []model.RelationTuple) {
+		for _, tuple := range tuples {
+			fmt.Fprintln(out, prefix, services.TupleString(tuple))
+		}
+	}
+	printTuples("+", plan.Create)
+	printTuples("~", plan.Adopt)
+	if prune {
+		printTuples("-", plan.Prune)
+	} else {
+		printTuples("! stale, kept without -prune:", plan.Prune)
+	}
+	fmt.Fprintf(out, "%d to create, %d to adopt, %d to prune\n", len(plan.Create), len(plan.Adopt), len(plan.Prune))
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/livspaceeng/ozone/internal/metrics"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FileKeySource = "file"
+	SQLKeySource  = "sql"
+
+	// APIKeyTokenType is the token_type of introspection responses for API keys.
+	APIKeyTokenType = "api_key"
+
+	defaultKeyDriver = "postgres"
+	defaultKeyTable  = "api_keys"
+)
+
+var sqlTablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// APIKey is a static key of a partner. Only the SHA-256 hash of the key is
+// stored, a zero ExpiresAt never expires.
+type APIKey struct {
+	Hash      string    `yaml:"hash"`
+	Subject   string    `yaml:"subject"`
+	ClientId  string    `yaml:"client_id"`
+	Scopes    []string  `yaml:"scopes"`
+	ExpiresAt time.Time `yaml:"expires_at"`
+}
+
+// Expired tells whether the key can no longer be used at a given time.
+func (k APIKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// HashAPIKey returns the hex encoded SHA-256 of a key, as stored by the key
+// sources.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyStore looks keys up by hash. Lookup returns nil without error for an
+// unknown key.
+type APIKeyStore interface {
+	Lookup(ctx context.Context, hash string) (*APIKey, error)
+	Touch(ctx context.Context, hash string, usedAt time.Time) error
+	Close() error
+}
+
+// keySource identifies the config an API key store was built from, so that
+// a reload only rebuilds stores whose source changed.
+func keySource(config *viper.Viper, name string) string {
+	prefix := "issuer." + name + "."
+	return strings.Join([]string{
+		config.GetString(prefix + "source"),
+		config.GetString(prefix + "file"),
+		config.GetString(prefix + "sql.driver"),
+		config.GetString(prefix + "sql.dsn"),
+		config.GetString(prefix + "sql.table"),
+	}, "|")
+}
+
+func validateKeySource(config *viper.Viper, name string) error {
+	prefix := "issuer." + name + "."
+	switch config.GetString(prefix + "source") {
+	case FileKeySource:
+		if config.GetString(prefix+"file") == "" {
+			return fmt.Errorf("issuer %s has no key file", name)
+		}
+	case SQLKeySource:
+		if config.GetString(prefix+"sql.dsn") == "" {
+			return fmt.Errorf("issuer %s has no sql.dsn", name)
+		}
+		if table := config.GetString(prefix + "sql.table"); table != "" && !sqlTablePattern.MatchString(table) {
+			return fmt.Errorf("issuer %s has an invalid sql.table %q", name, table)
+		}
+	default:
+		return fmt.Errorf("issuer %s has an unknown key source %q", name, config.GetString(prefix+"source"))
+	}
+	return nil
+}
+
+func newAPIKeyStore(config *viper.Viper, name string) (APIKeyStore, error) {
+	if err := validateKeySource(config, name); err != nil {
+		return nil, err
+	}
+	prefix := "issuer." + name + "."
+	if config.GetString(prefix+"source") == FileKeySource {
+		return newFileKeyStore(config.GetString(prefix + "file"))
+	}
+	driver := config.GetString(prefix + "sql.driver")
+	if driver == "" {
+		driver = defaultKeyDriver
+	}
+	table := config.GetString(prefix + "sql.table")
+	if table == "" {
+		table = defaultKeyTable
+	}
+	return newSQLKeyStore(driver, config.GetString(prefix+"sql.dsn"), table)
+}
+
+// fileKeyStore serves keys from a YAML file, read again whenever its
+// modification time changes. Last use is not written back to the file.
+type fileKeyStore struct {
+	path string
+
+	mu      sync.RWMutex
+	modTime time.Time
+	keys    map[string]APIKey
+}
+
+type keyFile struct {
+	Keys []APIKey `yaml:"keys"`
+}
+
+func newFileKeyStore(path string) (*fileKeyStore, error) {
+	store := &fileKeyStore{path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.read(info.ModTime()); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *fileKeyStore) read(modTime time.Time) error {
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var file keyFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return fmt.Errorf("key file %s: %w", s.path, err)
+	}
+	keys := make(map[string]APIKey, len(file.Keys))
+	for _, key := range file.Keys {
+		if key.Hash == "" || key.Subject == "" {
+			return fmt.Errorf("key file %s has a key without hash or subject", s.path)
+		}
+		keys[strings.ToLower(key.Hash)] = key
+	}
+	s.mu.Lock()
+	s.keys = keys
+	s.modTime = modTime
+	s.mu.Unlock()
+	return nil
+}
+
+// refresh reads the file again when it changed. A file that became
+// unreadable or invalid keeps the previous keys serving.
+func (s *fileKeyStore) refresh() {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		log.Error("Unable to stat key file, keeping previous keys: ", err)
+		return
+	}
+	s.mu.RLock()
+	changed := !info.ModTime().Equal(s.modTime)
+	s.mu.RUnlock()
+	if !changed {
+		return
+	}
+	if err := s.read(info.ModTime()); err != nil {
+		log.Error("Unable to read key file, keeping previous keys: ", err)
+	}
+}
+
+func (s *fileKeyStore) Lookup(ctx context.Context, hash string) (*APIKey, error) {
+	s.refresh()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, found := s.keys[hash]
+	if !found {
+		return nil, nil
+	}
+	return &key, nil
+}
+
+func (s *fileKeyStore) Touch(ctx context.Context, hash string, usedAt time.Time) error {
+	return nil
+}
+
+func (s *fileKeyStore) Close() error {
+	return nil
+}
+
+// sqlKeyStore serves keys from a table with the columns key_hash, subject,
+// client_id, scopes (space separated), expires_at and last_used_at.
+type sqlKeyStore struct {
+	db     *sql.DB
+	lookup string
+	touch  string
+}
+
+func newSQLKeyStore(driver string, dsn string, table string) (*sqlKeyStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlKeyStore{
+		db:     db,
+		lookup: "SELECT subject, client_id, scopes, expires_at FROM " + table + " WHERE key_hash = $1",
+		touch:  "UPDATE " + table + " SET last_used_at = $1 WHERE key_hash = $2",
+	}, nil
+}
+
+func (s *sqlKeyStore) Lookup(ctx context.Context, hash string) (*APIKey, error) {
+	var clientId, scopes sql.NullString
+	var expiresAt sql.NullTime
+	key := APIKey{Hash: hash}
+	err := s.db.QueryRowContext(ctx, s.lookup, hash).Scan(&key.Subject, &clientId, &scopes, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key.ClientId = clientId.String
+	key.Scopes = strings.Fields(scopes.String)
+	if expiresAt.Valid {
+		key.ExpiresAt = expiresAt.Time
+	}
+	return &key, nil
+}
+
+func (s *sqlKeyStore) Touch(ctx context.Context, hash string, usedAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.touch, usedAt.UTC(), hash)
+	return err
+}
+
+func (s *sqlKeyStore) Close() error {
+	return s.db.Close()
+}
+
+// keyUsage records when keys were last used. A key is written back to its
+// store at most once per lastUsedInterval, in the background.
+type keyUsage struct {
+	lastUsed sync.Map
+}
+
+const lastUsedInterval = time.Minute
+
+func newKeyUsage() *keyUsage {
+	return &keyUsage{}
+}
+
+func (u *keyUsage) touch(issuer Issuer, hash string, clientId string, now time.Time) {
+	metrics.APIKeyLastUsed.WithLabelValues(issuer.Name, clientId).Set(float64(now.Unix()))
+	if last, found := u.lastUsed.Load(hash); found && now.Sub(last.(time.Time)) < lastUsedInterval {
+		return
+	}
+	u.lastUsed.Store(hash, now)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultIssuerTimeout*time.Second)
+		defer cancel()
+		if err := issuer.APIKeys.Touch(ctx, hash, now); err != nil {
+			log.Error("Unable to record last use of API key of issuer ", issuer.Name, ": ", err)
+		}
+	}()
+}
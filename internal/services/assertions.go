@@ -0,0 +1,344 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/model"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	DefaultAssertionHeader = "X-Ozone-Assertion"
+	AssertionAlgorithm     = "ES256"
+
+	defaultAssertionIssuer   = "ozone"
+	defaultAssertionTTL      = 60
+	defaultAssertionRotation = 86400
+)
+
+// Assertions mints short-lived JWTs asserting an allowed check, signed with
+// ES256 keys that rotate and are published as a JWKS. Keys are read from the
+// PEM files of assertion.keys_dir so that every instance signs with the same
+// keys, or generated in memory for a single instance.
+type Assertions struct {
+	policy atomic.Value
+
+	mu        sync.Mutex
+	keys      []assertionKey
+	rotatedAt time.Time
+	keysDir   string
+	now       func() time.Time
+}
+
+type assertionPolicy struct {
+	enabled  bool
+	header   string
+	issuer   string
+	audience string
+	ttl      time.Duration
+	rotation time.Duration
+	keysDir  string
+}
+
+// assertionKey is a signing key. A key retired by a rotation stays
+// published until retireAt, so that assertions it signed still verify.
+type assertionKey struct {
+	id       string
+	key      *ecdsa.PrivateKey
+	retireAt time.Time
+}
+
+func init() {
+	configs.RegisterValidator(func(config *viper.Viper) error {
+		_, err := loadAssertionPolicy(config)
+		return err
+	})
+}
+
+func loadAssertionPolicy(config *viper.Viper) (*assertionPolicy, error) {
+	if config.GetInt("assertion.ttl") < 0 || config.GetInt("assertion.rotation") < 0 {
+		return nil, errors.New("assertion.ttl and assertion.rotation cannot be negative")
+	}
+	policy := &assertionPolicy{
+		enabled:  config.GetBool("assertion.enabled"),
+		header:   config.GetString("assertion.header"),
+		issuer:   config.GetString("assertion.issuer"),
+		audience: config.GetString("assertion.audience"),
+		ttl:      time.Duration(intOrDefault(config.GetInt("assertion.ttl"), defaultAssertionTTL)) * time.Second,
+		rotation: time.Duration(intOrDefault(config.GetInt("assertion.rotation"), defaultAssertionRotation)) * time.Second,
+		keysDir:  config.GetString("assertion.keys_dir"),
+	}
+	if policy.header == "" {
+		policy.header = DefaultAssertionHeader
+	}
+	if policy.issuer == "" {
+		policy.issuer = defaultAssertionIssuer
+	}
+	if policy.keysDir != "" {
+		if info, err := os.Stat(policy.keysDir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("assertion.keys_dir %s is not a directory", policy.keysDir)
+		}
+	} else if policy.enabled && !config.GetBool("assertion.single_replica") {
+		// Keys generated in memory differ between instances, so the JWKS of
+		// one would not verify the assertions of another
+		return nil, errors.New("assertion.keys_dir is required unless assertion.single_replica is set")
+	}
+	return policy, nil
+}
+
+// NewAssertions returns Assertions reading the time from now, which decides
+// when assertions expire and when keys rotate.
+func NewAssertions(config *configs.Store, now func() time.Time) (*Assertions, error) {
+	policy, err := loadAssertionPolicy(config.Get())
+	if err != nil {
+		return nil, err
+	}
+	assertions := &Assertions{now: now}
+	assertions.policy.Store(policy)
+	config.OnReload(func(newConfig *viper.Viper) {
+		if policy, err := loadAssertionPolicy(newConfig); err == nil {
+			assertions.policy.Store(policy)
+		}
+	})
+	return assertions, nil
+}
+
+// Enabled tells whether allowed checks carry an assertion.
+func (a *Assertions) Enabled() bool {
+	return a.policy.Load().(*assertionPolicy).enabled
+}
+
+// Header is the response header carrying the assertion.
+func (a *Assertions) Header() string {
+	return a.policy.Load().(*assertionPolicy).header
+}
+
+// Mint signs an assertion that the subject of response holds permission.
+// It expires after assertion.ttl, or with the token if sooner.
+func (a *Assertions) Mint(response model.HydraResponse, permission model.SubjectSet) (string, error) {
+	policy := a.policy.Load().(*assertionPolicy)
+	now := a.now()
+	key, err := a.signingKey(policy, now)
+	if err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	expiry := now.Add(policy.ttl).Unix()
+	if response.Expiry > 0 && int64(response.Expiry) < expiry {
+		expiry = int64(response.Expiry)
+	}
+	claims := model.Assertion{
+		Issuer:      policy.issuer,
+		Subject:     response.Subject,
+		Audience:    policy.audience,
+		IssuedAt:    now.Unix(),
+		Expiry:      expiry,
+		Id:          hex.EncodeToString(id),
+		ClientId:    response.ClientId,
+		Scope:       response.Scope,
+		TokenIssuer: response.Issuer,
+		Permission:  permission,
+	}
+	return signJWT(key, claims)
+}
+
+// JWKS returns the public keys assertions are verified with, the current
+// signing key first.
+func (a *Assertions) JWKS() model.JSONWebKeySet {
+	policy := a.policy.Load().(*assertionPolicy)
+	set := model.JSONWebKeySet{Keys: []model.JSONWebKey{}}
+	if !policy.enabled {
+		return set
+	}
+	if _, err := a.signingKey(policy, a.now()); err != nil {
+		log.Error("Unable to load assertion keys: ", err)
+		return set
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, key := range a.keys {
+		set.Keys = append(set.Keys, publicJWK(key))
+	}
+	return set
+}
+
+// signingKey returns the current key, rotating first when it is older than
+// assertion.rotation. A failed rotation keeps the previous keys.
+func (a *Assertions) signingKey(policy *assertionPolicy, now time.Time) (assertionKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.keys) == 0 || a.keysDir != policy.keysDir || now.Sub(a.rotatedAt) >= policy.rotation {
+		if err := a.rotate(policy, now); err != nil {
+			if len(a.keys) == 0 || a.keysDir != policy.keysDir {
+				return assertionKey{}, err
+			}
+			log.Error("Unable to rotate assertion keys, keeping previous keys: ", err)
+		}
+	}
+	return a.keys[0], nil
+}
+
+func (a *Assertions) rotate(policy *assertionPolicy, now time.Time) error {
+	if policy.keysDir != "" {
+		keys, err := readAssertionKeys(policy.keysDir)
+		if err != nil {
+			return err
+		}
+		a.keys, a.keysDir, a.rotatedAt = keys, policy.keysDir, now
+		return nil
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	keys := []assertionKey{{id: keyId(&privateKey.PublicKey), key: privateKey}}
+	if a.keysDir == "" {
+		for i, key := range a.keys {
+			if i == 0 {
+				key.retireAt = now.Add(policy.ttl)
+			}
+			if key.retireAt.After(now) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	a.keys, a.keysDir, a.rotatedAt = keys, "", now
+	return nil
+}
+
+// readAssertionKeys reads the P-256 keys of the PEM files of dir, the most
+// recently modified file first.
+func readAssertionKeys(dir string) ([]assertionKey, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	type keyFile struct {
+		key     assertionKey
+		modTime time.Time
+	}
+	var files []keyFile
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err := parseAssertionKey(content)
+		if err != nil {
+			return nil, fmt.Errorf("assertion key %s: %w", path, err)
+		}
+		files = append(files, keyFile{key: assertionKey{id: keyId(&privateKey.PublicKey), key: privateKey}, modTime: info.ModTime()})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("assertion.keys_dir %s has no .pem key", dir)
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	keys := make([]assertionKey, len(files))
+	for i, file := range files {
+		keys[i] = file.key
+	}
+	return keys, nil
+}
+
+func parseAssertionKey(content []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	var privateKey *ecdsa.PrivateKey
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		privateKey = key
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("not an ECDSA key")
+		}
+		privateKey = ecKey
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %s", block.Type)
+	}
+	if privateKey.Curve != elliptic.P256() {
+		return nil, errors.New("not a P-256 key")
+	}
+	return privateKey, nil
+}
+
+func publicJWK(key assertionKey) model.JSONWebKey {
+	return model.JSONWebKey{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   encodeCoordinate(key.key.X),
+		Y:   encodeCoordinate(key.key.Y),
+		Kid: key.id,
+		Use: "sig",
+		Alg: AssertionAlgorithm,
+	}
+}
+
+// keyId is the RFC 7638 thumbprint of a public key.
+func keyId(key *ecdsa.PublicKey) string {
+	thumbprint := `{"crv":"P-256","kty":"EC","x":"` + encodeCoordinate(key.X) + `","y":"` + encodeCoordinate(key.Y) + `"}`
+	sum := sha256.Sum256([]byte(thumbprint))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func encodeCoordinate(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, 32)))
+}
+
+func signJWT(key assertionKey, claims model.Assertion) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": AssertionAlgorithm, "typ": "JWT", "kid": key.id})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
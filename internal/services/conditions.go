@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/logging"
+	"github.com/livspaceeng/ozone/internal/model"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	ConditionMet    = "met"
+	ConditionNotMet = "not_met"
+	ConditionError  = "error"
+)
+
+// Condition is a CEL expression a check allowed by Keto also has to pass,
+// configured under conditions. A condition without relation applies to
+// every relation of its namespace that has no condition of its own.
+type Condition struct {
+	Name       string `mapstructure:"name"`
+	Namespace  string `mapstructure:"namespace"`
+	Relation   string `mapstructure:"relation"`
+	Expression string `mapstructure:"expression"`
+}
+
+// RequestAttributes describe the request being checked to conditions.
+// Headers are keyed in lower case.
+type RequestAttributes struct {
+	IP      string
+	Method  string
+	Path    string
+	Headers map[string]string
+	Time    time.Time
+}
+
+// ConditionInput is what a condition is evaluated against.
+type ConditionInput struct {
+	Token      model.HydraResponse
+	Permission model.SubjectSet
+	Request    RequestAttributes
+	Context    map[string]interface{}
+}
+
+// Conditions holds the compiled conditions, swapped atomically whenever the
+// config is reloaded.
+type Conditions struct {
+	policy atomic.Value
+}
+
+type compiledCondition struct {
+	Condition
+	program cel.Program
+}
+
+func init() {
+	configs.RegisterValidator(func(config *viper.Viper) error {
+		_, err := loadConditions(config)
+		return err
+	})
+}
+
+func conditionEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("token", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("context", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("permission", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Function("inCidr",
+			cel.Overload("in_cidr_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+				cel.BinaryBinding(inCidr))),
+	)
+}
+
+// inCidr tells whether an IP address lies within a CIDR range.
+func inCidr(ip ref.Val, cidr ref.Val) ref.Val {
+	_, network, err := net.ParseCIDR(string(cidr.(types.String)))
+	if err != nil {
+		return types.NewErr("invalid CIDR %s", cidr)
+	}
+	address := net.ParseIP(string(ip.(types.String)))
+	return types.Bool(address != nil && network.Contains(address))
+}
+
+func conditionKey(namespace string, relation string) string {
+	return namespace + "#" + relation
+}
+
+func loadConditions(config *viper.Viper) (map[string]compiledCondition, error) {
+	var conditions []Condition
+	if err := config.UnmarshalKey("conditions", &conditions); err != nil {
+		return nil, err
+	}
+	env, err := conditionEnv()
+	if err != nil {
+		return nil, err
+	}
+	compiled := make(map[string]compiledCondition, len(conditions))
+	for _, condition := range conditions {
+		if condition.Name == "" || condition.Namespace == "" || condition.Expression == "" {
+			return nil, errors.New("condition needs a name, a namespace and an expression")
+		}
+		key := conditionKey(condition.Namespace, condition.Relation)
+		if _, found := compiled[key]; found {
+			return nil, fmt.Errorf("condition for %s is defined twice", key)
+		}
+		ast, issues := env.Compile(condition.Expression)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("condition %s: %w", condition.Name, issues.Err())
+		}
+		if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+			return nil, fmt.Errorf("condition %s does not evaluate to a bool", condition.Name)
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("condition %s: %w", condition.Name, err)
+		}
+		compiled[key] = compiledCondition{Condition: condition, program: program}
+	}
+	return compiled, nil
+}
+
+func NewConditions(config *configs.Store) (*Conditions, error) {
+	compiled, err := loadConditions(config.Get())
+	if err != nil {
+		return nil, err
+	}
+	conditions := &Conditions{}
+	conditions.policy.Store(compiled)
+	config.OnReload(func(newConfig *viper.Viper) {
+		if compiled, err := loadConditions(newConfig); err == nil {
+			conditions.policy.Store(compiled)
+		}
+	})
+	return conditions, nil
+}
+
+// Evaluate runs the condition of the checked permission, if any, and returns
+// its outcome, nil without condition. A condition failing to evaluate is not
+// met.
+func (c *Conditions) Evaluate(ctx context.Context, input ConditionInput) *model.ConditionResult {
+	compiled := c.policy.Load().(map[string]compiledCondition)
+	condition, found := compiled[conditionKey(input.Permission.Namespace, input.Permission.Relation)]
+	if !found {
+		condition, found = compiled[conditionKey(input.Permission.Namespace, "")]
+	}
+	if !found {
+		return nil
+	}
+
+	result := &model.ConditionResult{Name: condition.Name}
+	out, _, err := condition.program.Eval(conditionActivation(input))
+	if err == nil {
+		if met, isBool := out.Value().(bool); !isBool {
+			err = errors.New("condition did not evaluate to a bool")
+		} else if met {
+			result.Result = ConditionMet
+		} else {
+			result.Result = ConditionNotMet
+		}
+	}
+	if err != nil {
+		result.Result = ConditionError
+		result.Error = err.Error()
+		logging.FromContext(ctx).Error("Condition ", condition.Name, " failed to evaluate: ", err)
+	}
+	logging.AddFields(ctx, log.Fields{"condition": condition.Name, "condition_result": result.Result})
+	return result
+}
+
+func conditionActivation(input ConditionInput) map[string]interface{} {
+	headers := make(map[string]interface{}, len(input.Request.Headers))
+	for name, value := range input.Request.Headers {
+		headers[name] = value
+	}
+	inputContext := input.Context
+	if inputContext == nil {
+		inputContext = map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"token": map[string]interface{}{
+			"sub":       input.Token.Subject,
+			"client_id": input.Token.ClientId,
+			"scope":     input.Token.Scope,
+			"scopes":    strings.Fields(input.Token.Scope),
+			"issuer":    input.Token.Issuer,
+			"exp":       int64(input.Token.Expiry),
+		},
+		"request": map[string]interface{}{
+			"ip":      input.Request.IP,
+			"method":  input.Request.Method,
+			"path":    input.Request.Path,
+			"headers": headers,
+			"time":    input.Request.Time,
+		},
+		"context": inputContext,
+		"permission": map[string]string{
+			"namespace": input.Permission.Namespace,
+			"object":    input.Permission.Object,
+			"relation":  input.Permission.Relation,
+		},
+	}
+}
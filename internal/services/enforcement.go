@@ -0,0 +1,235 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/spf13/viper"
+)
+
+const (
+	EnforceMode = "enforce"
+	ShadowMode  = "shadow"
+
+	defaultLegacyDecisionHeader = "X-Legacy-Decision"
+)
+
+// EnforcementRule sets the mode of a namespace or of a route. Namespace
+// rules take precedence over route rules.
+type EnforcementRule struct {
+	Namespace string `mapstructure:"namespace"`
+	Route     string `mapstructure:"route"`
+	Mode      string `mapstructure:"mode"`
+}
+
+// AccessRule overrides who may call a route authenticated by the access
+// middleware: anyone when Public, else the holders of Permission, which
+// defaults to enforcement.permissions.query.
+type AccessRule struct {
+	Route      string `mapstructure:"route"`
+	Public     bool   `mapstructure:"public"`
+	Permission string `mapstructure:"permission"`
+}
+
+// RouteAccess is the resolved access policy of a route.
+type RouteAccess struct {
+	Public     bool
+	Permission *model.SubjectSet
+}
+
+type enforcementPolicy struct {
+	namespaces      map[string]string
+	routes          map[string]string
+	legacyHeader    string
+	writePermission *model.SubjectSet
+	adminPermission *model.SubjectSet
+	queryPermission *model.SubjectSet
+	access          map[string]RouteAccess
+}
+
+// Enforcement decides whether decisions are enforced or only evaluated and
+// reported (shadow mode). It follows config reloads.
+type Enforcement struct {
+	policy atomic.Value
+}
+
+func init() {
+	configs.RegisterValidator(func(config *viper.Viper) error {
+		_, err := loadEnforcementPolicy(config)
+		return err
+	})
+}
+
+// LoadEnforcementRules reads and validates enforcement.rules from config.
+func LoadEnforcementRules(config *viper.Viper) ([]EnforcementRule, error) {
+	var rules []EnforcementRule
+	if err := config.UnmarshalKey("enforcement.rules", &rules); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if (rule.Namespace == "") == (rule.Route == "") {
+			return nil, errors.New("enforcement rule needs exactly one of namespace or route")
+		}
+		if rule.Mode != EnforceMode && rule.Mode != ShadowMode {
+			return nil, fmt.Errorf("enforcement rule has unknown mode %q", rule.Mode)
+		}
+	}
+	return rules, nil
+}
+
+func loadEnforcementPolicy(config *viper.Viper) (*enforcementPolicy, error) {
+	rules, err := LoadEnforcementRules(config)
+	if err != nil {
+		return nil, err
+	}
+	policy := &enforcementPolicy{
+		namespaces:   make(map[string]string),
+		routes:       make(map[string]string),
+		legacyHeader: config.GetString("enforcement.legacy_header"),
+	}
+	if policy.legacyHeader == "" {
+		policy.legacyHeader = defaultLegacyDecisionHeader
+	}
+	if policy.writePermission, err = loadPermission(config, "enforcement.permissions.write"); err != nil {
+		return nil, err
+	}
+	if policy.adminPermission, err = loadPermission(config, "enforcement.permissions.admin"); err != nil {
+		return nil, err
+	}
+	if policy.queryPermission, err = loadPermission(config, "enforcement.permissions.query"); err != nil {
+		return nil, err
+	}
+	if policy.access, err = loadAccess(config, policy.queryPermission); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if rule.Namespace != "" {
+			policy.namespaces[rule.Namespace] = rule.Mode
+		} else {
+			policy.routes[rule.Route] = rule.Mode
+		}
+	}
+	return policy, nil
+}
+
+func loadAccess(config *viper.Viper, queryPermission *model.SubjectSet) (map[string]RouteAccess, error) {
+	var rules []AccessRule
+	if err := config.UnmarshalKey("enforcement.access", &rules); err != nil {
+		return nil, err
+	}
+	access := make(map[string]RouteAccess, len(rules))
+	for _, rule := range rules {
+		if rule.Route == "" {
+			return nil, errors.New("access rule without route")
+		}
+		if _, found := access[rule.Route]; found {
+			return nil, fmt.Errorf("access rule for %s is defined twice", rule.Route)
+		}
+		if rule.Public && rule.Permission != "" {
+			return nil, fmt.Errorf("access rule for %s cannot be public and have a permission", rule.Route)
+		}
+		routeAccess := RouteAccess{Public: rule.Public, Permission: queryPermission}
+		if rule.Permission != "" {
+			permission, err := ParsePermission(rule.Permission)
+			if err != nil {
+				return nil, err
+			}
+			routeAccess.Permission = &permission
+		}
+		access[rule.Route] = routeAccess
+	}
+	return access, nil
+}
+
+func loadPermission(config *viper.Viper, key string) (*model.SubjectSet, error) {
+	value := config.GetString(key)
+	if value == "" {
+		return nil, nil
+	}
+	permission, err := ParsePermission(value)
+	if err != nil {
+		return nil, err
+	}
+	return &permission, nil
+}
+
+// ParsePermission parses a permission written as namespace:object#relation.
+func ParsePermission(permission string) (model.SubjectSet, error) {
+	namespace, rest, found := strings.Cut(permission, ":")
+	if !found {
+		return model.SubjectSet{}, fmt.Errorf("permission %q is not namespace:object#relation", permission)
+	}
+	object, relation, found := strings.Cut(rest, "#")
+	if !found || namespace == "" || object == "" || relation == "" {
+		return model.SubjectSet{}, fmt.Errorf("permission %q is not namespace:object#relation", permission)
+	}
+	return model.SubjectSet{Namespace: namespace, Object: object, Relation: relation}, nil
+}
+
+func NewEnforcement(config *configs.Store) (*Enforcement, error) {
+	policy, err := loadEnforcementPolicy(config.Get())
+	if err != nil {
+		return nil, err
+	}
+	enforcement := &Enforcement{}
+	enforcement.policy.Store(policy)
+	config.OnReload(func(newConfig *viper.Viper) {
+		if policy, err := loadEnforcementPolicy(newConfig); err == nil {
+			enforcement.policy.Store(policy)
+		}
+	})
+	return enforcement, nil
+}
+
+// Mode returns the mode of namespace on route, enforce by default.
+func (e *Enforcement) Mode(route string, namespace string) string {
+	policy := e.policy.Load().(*enforcementPolicy)
+	if mode, found := policy.namespaces[namespace]; found {
+		return mode
+	}
+	if mode, found := policy.routes[route]; found {
+		return mode
+	}
+	return EnforceMode
+}
+
+// LegacyHeader is the request header carrying the decision of the system
+// ozone replaces, compared against shadow decisions.
+func (e *Enforcement) LegacyHeader() string {
+	return e.policy.Load().(*enforcementPolicy).legacyHeader
+}
+
+// WritePermission returns the relation a subject needs to write relation
+// tuples through ozone. Writes are refused when none is configured.
+func (e *Enforcement) WritePermission() (model.SubjectSet, bool) {
+	permission := e.policy.Load().(*enforcementPolicy).writePermission
+	if permission == nil {
+		return model.SubjectSet{}, false
+	}
+	return *permission, true
+}
+
+// AdminPermission returns the relation a subject needs for admin endpoints
+// such as explain. They are refused when none is configured.
+func (e *Enforcement) AdminPermission() (model.SubjectSet, bool) {
+	permission := e.policy.Load().(*enforcementPolicy).adminPermission
+	if permission == nil {
+		return model.SubjectSet{}, false
+	}
+	return *permission, true
+}
+
+// Access returns who may call route: the access rule of the route, or
+// holders of enforcement.permissions.query, or any valid token when that is
+// empty.
+func (e *Enforcement) Access(route string) RouteAccess {
+	policy := e.policy.Load().(*enforcementPolicy)
+	if access, found := policy.access[route]; found {
+		return access
+	}
+	return RouteAccess{Permission: policy.queryPermission}
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/livspaceeng/ozone/internal/model"
+)
+
+const (
+	UnionNode              = "union"
+	ExclusionNode          = "exclusion"
+	IntersectionNode       = "intersection"
+	LeafNode               = "leaf"
+	NotNode                = "not"
+	TupleToSubjectSetNode  = "tuple_to_subject_set"
+	ComputedSubjectSetNode = "computed_subject_set"
+)
+
+// subjectSetString writes a subject set the way Keto does, namespace:object#relation.
+func subjectSetString(set model.SubjectSet) string {
+	return set.Namespace + ":" + set.Object + "#" + set.Relation
+}
+
+func subjectKey(subject model.ExpandSubject) string {
+	if subject.SubjectSet != nil {
+		return subjectSetString(*subject.SubjectSet)
+	}
+	return subject.SubjectId
+}
+
+// subjectSet is an ordered set of granted subjects.
+type subjectSet struct {
+	order    []string
+	subjects map[string]model.ExpandSubject
+}
+
+func newSubjectSet() *subjectSet {
+	return &subjectSet{subjects: make(map[string]model.ExpandSubject)}
+}
+
+// add keeps the shortest path of a subject granted several times.
+func (s *subjectSet) add(subject model.ExpandSubject) {
+	key := subjectKey(subject)
+	existing, found := s.subjects[key]
+	if !found {
+		s.order = append(s.order, key)
+	}
+	if !found || len(subject.Path) < len(existing.Path) {
+		s.subjects[key] = subject
+	}
+}
+
+func (s *subjectSet) union(other *subjectSet) {
+	for _, key := range other.order {
+		s.add(other.subjects[key])
+	}
+}
+
+func (s *subjectSet) filter(keep func(key string) bool) *subjectSet {
+	filtered := newSubjectSet()
+	for _, key := range s.order {
+		if keep(key) {
+			filtered.add(s.subjects[key])
+		}
+	}
+	return filtered
+}
+
+// FlattenExpandTree returns the subjects an expand tree grants, following
+// union, intersection and exclusion nodes.
+func FlattenExpandTree(tree model.ExpandTree) (model.FlatExpandTree, error) {
+	subjects, negated, err := flatten(tree, nil)
+	if err != nil {
+		return model.FlatExpandTree{}, err
+	}
+	if negated {
+		return model.FlatExpandTree{}, errors.New("Expand tree only excludes subjects")
+	}
+	flat := model.FlatExpandTree{Subjects: make([]model.ExpandSubject, 0, len(subjects.order))}
+	for _, key := range subjects.order {
+		flat.Subjects = append(flat.Subjects, subjects.subjects[key])
+	}
+	return flat, nil
+}
+
+// flatten returns the subjects granted by node, or the subjects it denies
+// when negated is true (a not node).
+func flatten(node model.ExpandTree, path []model.SubjectSet) (*subjectSet, bool, error) {
+	if node.Type == LeafNode {
+		subjects := newSubjectSet()
+		if node.Tuple != nil && (node.Tuple.SubjectId != "" || node.Tuple.SubjectSet != nil) {
+			subjects.add(model.ExpandSubject{SubjectId: node.Tuple.SubjectId, SubjectSet: node.Tuple.SubjectSet, Path: path})
+		}
+		return subjects, false, nil
+	}
+
+	if node.Tuple != nil && node.Tuple.SubjectSet != nil {
+		path = append(append([]model.SubjectSet{}, path...), *node.Tuple.SubjectSet)
+	}
+	children := make([]*subjectSet, 0, len(node.Children))
+	negated := make([]bool, 0, len(node.Children))
+	for _, child := range node.Children {
+		subjects, childNegated, err := flatten(child, path)
+		if err != nil {
+			return nil, false, err
+		}
+		children = append(children, subjects)
+		negated = append(negated, childNegated)
+	}
+
+	switch node.Type {
+	case NotNode:
+		if len(children) != 1 || negated[0] {
+			return nil, false, errors.New("Expand tree has an invalid not node")
+		}
+		return children[0], true, nil
+	case IntersectionNode:
+		var result *subjectSet
+		excluded := newSubjectSet()
+		for i, subjects := range children {
+			if negated[i] {
+				excluded.union(subjects)
+			} else if result == nil {
+				result = subjects
+			} else {
+				other := subjects
+				result = result.filter(func(key string) bool { _, found := other.subjects[key]; return found })
+			}
+		}
+		if result == nil {
+			return nil, false, errors.New("Expand tree has an intersection without granted subjects")
+		}
+		return result.filter(func(key string) bool { _, found := excluded.subjects[key]; return !found }), false, nil
+	case ExclusionNode:
+		if len(children) == 0 || negated[0] {
+			return newSubjectSet(), false, nil
+		}
+		excluded := newSubjectSet()
+		for _, subjects := range children[1:] {
+			excluded.union(subjects)
+		}
+		return children[0].filter(func(key string) bool { _, found := excluded.subjects[key]; return !found }), false, nil
+	default:
+		result := newSubjectSet()
+		for i, subjects := range children {
+			if negated[i] {
+				return nil, false, fmt.Errorf("Expand tree has a not node under a %s node", node.Type)
+			}
+			result.union(subjects)
+		}
+		return result, false, nil
+	}
+}
+
+// ExpandTreeToDot renders an expand tree as a Graphviz digraph. Subject sets
+// are boxes, subject ids ellipses, and excluded branches dashed edges.
+func ExpandTreeToDot(tree model.ExpandTree) string {
+	graph := &dotGraph{nodes: make(map[string]bool), edges: make(map[string]bool)}
+	graph.lines = append(graph.lines, "digraph expand {", "  rankdir=LR;")
+	graph.walk(tree, "", false)
+	graph.lines = append(graph.lines, "}")
+	return strings.Join(graph.lines, "\n") + "\n"
+}
+
+type dotGraph struct {
+	lines     []string
+	nodes     map[string]bool
+	edges     map[string]bool
+	anonymous int
+}
+
+func (g *dotGraph) walk(node model.ExpandTree, parent string, excluded bool) {
+	id, label, shape := g.describe(node)
+	if !g.nodes[id] {
+		g.nodes[id] = true
+		g.lines = append(g.lines, fmt.Sprintf("  %s [label=%s, shape=%s];", dotQuote(id), dotQuote(label), shape))
+	}
+	if parent != "" {
+		edge := fmt.Sprintf("  %s -> %s", dotQuote(parent), dotQuote(id))
+		if excluded {
+			edge += " [style=dashed, label=\"not\"]"
+		}
+		if !g.edges[edge] {
+			g.edges[edge] = true
+			g.lines = append(g.lines, edge+";")
+		}
+	}
+	for i, child := range node.Children {
+		g.walk(child, id, node.Type == NotNode || (node.Type == ExclusionNode && i > 0))
+	}
+}
+
+func (g *dotGraph) describe(node model.ExpandTree) (string, string, string) {
+	if node.Tuple != nil && node.Tuple.SubjectSet != nil {
+		id := subjectSetString(*node.Tuple.SubjectSet)
+		if node.Type == LeafNode {
+			return id, id, "box"
+		}
+		return id, node.Type + "\n" + id, "box"
+	}
+	if node.Tuple != nil && node.Tuple.SubjectId != "" {
+		return node.Tuple.SubjectId, node.Tuple.SubjectId, "ellipse"
+	}
+	g.anonymous++
+	return fmt.Sprintf("%s#%d", node.Type, g.anonymous), node.Type, "diamond"
+}
+
+func dotQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return `"` + value + `"`
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/logging"
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/livspaceeng/ozone/internal/utils"
+	"go.opentelemetry.io/otel"
+)
+
+const defaultExplainMaxExpansions = 10
+
+type ExplainService interface {
+	Explain(ctx context.Context, namespace string, relation string, object string, subjectId string) (int, model.Explanation, error)
+}
+
+type explainService struct {
+	config  *configs.Store
+	ketoSvc KetoService
+}
+
+func NewExplainService(config *configs.Store, ketoSvc KetoService) ExplainService {
+	return &explainService{
+		config:  config,
+		ketoSvc: ketoSvc,
+	}
+}
+
+// Explain checks the permission, then looks for a path of subject sets
+// granting it by expanding them, following subject sets Keto left
+// unexpanded, and lists the stored tuple behind every hop of the path.
+func (svc explainService) Explain(ctx context.Context, namespace string, relation string, object string, subjectId string) (int, model.Explanation, error) {
+	childCtx, span := otel.Tracer("ExplainPolicy").Start(ctx, "ExplainPolicy")
+	defer span.End()
+
+	if namespace == "" || relation == "" || object == "" || subjectId == "" {
+		logging.FromContext(ctx).Error(utils.InvalidError)
+		return http.StatusBadRequest, model.Explanation{}, errors.New(utils.InvalidError)
+	}
+	explanation := model.Explanation{
+		SubjectId:   subjectId,
+		Permission:  model.SubjectSet{Namespace: namespace, Object: object, Relation: relation},
+		Tuples:      []model.RelationTuple{},
+		SubjectSets: []model.SubjectSet{},
+	}
+
+	ketoStatus, _, err := svc.ketoSvc.ValidatePolicy(childCtx, namespace, relation, object, subjectId)
+	if ketoStatus != http.StatusOK && ketoStatus != http.StatusForbidden {
+		return ketoStatus, model.Explanation{}, err
+	}
+	explanation.Allowed = ketoStatus == http.StatusOK
+
+	ketoStatus, path, found, err := svc.findPath(childCtx, explanation.Permission, subjectId)
+	if ketoStatus != http.StatusOK {
+		return ketoStatus, model.Explanation{}, err
+	}
+	if !found {
+		if explanation.Allowed {
+			explanation.Reason = fmt.Sprintf("Subject is allowed but no grant path was found within %d expansions", svc.maxExpansions())
+		} else {
+			explanation.Reason = "Subject has no grant path to the permission"
+		}
+		return http.StatusOK, explanation, nil
+	}
+
+	explanation.SubjectSets = path
+	for i, set := range path {
+		hop := model.RelationTuple{Namespace: set.Namespace, Object: set.Object, Relation: set.Relation}
+		if i+1 < len(path) {
+			hop.SubjectSet = &model.SubjectSet{Namespace: path[i+1].Namespace, Object: path[i+1].Object, Relation: path[i+1].Relation}
+		} else {
+			hop.SubjectId = subjectId
+		}
+		ketoStatus, tuples, err := svc.ketoSvc.ListRelationTuples(childCtx, hop, "", "1")
+		if ketoStatus != http.StatusOK {
+			return ketoStatus, model.Explanation{}, err
+		}
+		// A hop without a stored tuple comes from a namespace rewrite.
+		if len(tuples.RelationTuples) > 0 {
+			explanation.Tuples = append(explanation.Tuples, hop)
+		}
+	}
+	if explanation.Allowed {
+		explanation.Reason = "Subject is granted through the listed tuples"
+	} else {
+		explanation.Reason = "Subject has a grant path but Keto denies the permission, through an exclusion or a path longer than its max depth"
+	}
+	return http.StatusOK, explanation, nil
+}
+
+type explainStep struct {
+	set  model.SubjectSet
+	path []model.SubjectSet
+}
+
+// findPath searches breadth first, so the path found is the shortest one
+// Keto's expand trees give.
+func (svc explainService) findPath(ctx context.Context, permission model.SubjectSet, subjectId string) (int, []model.SubjectSet, bool, error) {
+	queue := []explainStep{{set: permission}}
+	visited := map[string]bool{subjectSetString(permission): true}
+	for expansions := 0; len(queue) > 0 && expansions < svc.maxExpansions(); expansions++ {
+		step := queue[0]
+		queue = queue[1:]
+
+		ketoStatus, tree, err := svc.ketoSvc.ExpandPolicy(ctx, step.set.Namespace, step.set.Relation, step.set.Object, "", false)
+		if ketoStatus == http.StatusNotFound {
+			continue
+		} else if ketoStatus != http.StatusOK {
+			return ketoStatus, nil, false, err
+		}
+		flat, err := FlattenExpandTree(*tree)
+		if err != nil {
+			return http.StatusUnprocessableEntity, nil, false, err
+		}
+		for _, subject := range flat.Subjects {
+			if subject.SubjectId == subjectId {
+				return http.StatusOK, append(step.path, subject.Path...), true, nil
+			}
+		}
+		for _, subject := range flat.Subjects {
+			if subject.SubjectSet == nil || visited[subjectSetString(*subject.SubjectSet)] {
+				continue
+			}
+			visited[subjectSetString(*subject.SubjectSet)] = true
+			path := append(append([]model.SubjectSet{}, step.path...), subject.Path...)
+			queue = append(queue, explainStep{set: *subject.SubjectSet, path: path})
+		}
+	}
+	return http.StatusOK, nil, false, nil
+}
+
+func (svc explainService) maxExpansions() int {
+	if expansions := svc.config.Get().GetInt("explain.max_expansions"); expansions > 0 {
+		return expansions
+	}
+	return defaultExplainMaxExpansions
+}
@@ -7,108 +7,320 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/logging"
+	"github.com/livspaceeng/ozone/internal/metrics"
 	"github.com/livspaceeng/ozone/internal/model"
 	"github.com/livspaceeng/ozone/internal/utils"
 	"github.com/patrickmn/go-cache"
 	log "github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel"
+	"golang.org/x/sync/singleflight"
 )
 
 type HydraService interface {
 	GetSubjectByToken(ctx context.Context, issuer string, hasIssuer bool, bearer string) (int, string, error)
+	Introspect(ctx context.Context, issuer string, hasIssuer bool, bearer string) (int, model.HydraResponse, error)
+	InvalidTokenCount(client string) int
 }
 
 type hydraService struct {
-	httpClient *http.Client
-	cacheClient *cache.Cache
+	config        *configs.Store
+	httpClient    *http.Client
+	cacheClient   *cache.Cache
+	group         *singleflight.Group
+	negativeCache *negativeCache
+	issuers       *issuerRegistry
+	tokenHasher   *utils.TokenHasher
+	keyUsage      *keyUsage
+	discovered    *cache.Cache
+	now           func() time.Time
 }
 
-func NewHydraService(httpClient *http.Client, cacheClient *cache.Cache) HydraService {
+// introspection is the outcome of a single Hydra call, shared by every
+// caller that was coalesced onto it.
+type introspection struct {
+	status   int
+	response model.HydraResponse
+}
+
+// NewHydraService returns a HydraService reading the time from now, which
+// decides when cached responses expire.
+func NewHydraService(config *configs.Store, httpClient *http.Client, cacheClient *cache.Cache, now func() time.Time) HydraService {
 	return &hydraService{
-		httpClient: httpClient,
-		cacheClient: cacheClient,
+		config:        config,
+		httpClient:    httpClient,
+		cacheClient:   cacheClient,
+		group:         &singleflight.Group{},
+		negativeCache: newNegativeCache(config),
+		issuers:       newIssuerRegistry(config),
+		tokenHasher:   utils.NewTokenHasher(config.Get()),
+		keyUsage:      newKeyUsage(),
+		discovered:    cache.New(discoveryTTL, discoveryTTL),
+		now:           now,
 	}
 }
 
 func (hydraSvc hydraService) GetSubjectByToken(ctx context.Context, issuer string, hasIssuer bool, bearer string) (int, string, error) {
+	status, hydraResponse, err := hydraSvc.Introspect(ctx, issuer, hasIssuer, bearer)
+	return status, hydraResponse.Subject, err
+}
+
+type introspectionMemoKey struct{}
+
+type introspectionArgs struct {
+	issuer    string
+	hasIssuer bool
+	bearer    string
+}
+
+type introspectionResult struct {
+	status   int
+	response model.HydraResponse
+	err      error
+}
+
+// introspectionMemo holds the introspections made while serving a request.
+type introspectionMemo struct {
+	mu      sync.Mutex
+	results map[introspectionArgs]introspectionResult
+}
+
+// WithIntrospectionMemo returns a copy of ctx under which Introspect resolves
+// each bearer token once: the middlewares and the controller of a request
+// share the result, and an invalid token is counted once per request.
+func WithIntrospectionMemo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, introspectionMemoKey{}, &introspectionMemo{results: make(map[introspectionArgs]introspectionResult)})
+}
+
+// Introspect resolves a bearer token against the issuer's introspection
+// endpoint and returns the full introspection response. Without a token, a
+// verified client certificate authenticates the request: its identity is the
+// client_id, and prefixed with CertificateSubjectPrefix the subject.
+func (hydraSvc hydraService) Introspect(ctx context.Context, issuer string, hasIssuer bool, bearer string) (int, model.HydraResponse, error) {
+	memo, found := ctx.Value(introspectionMemoKey{}).(*introspectionMemo)
+	if !found {
+		return hydraSvc.introspectBearer(ctx, issuer, hasIssuer, bearer)
+	}
+	args := introspectionArgs{issuer: issuer, hasIssuer: hasIssuer, bearer: bearer}
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+	if result, found := memo.results[args]; found {
+		return result.status, result.response, result.err
+	}
+	status, response, err := hydraSvc.introspectBearer(ctx, issuer, hasIssuer, bearer)
+	memo.results[args] = introspectionResult{status: status, response: response, err: err}
+	return status, response, err
+}
+
+func (hydraSvc hydraService) introspectBearer(ctx context.Context, issuer string, hasIssuer bool, bearer string) (int, model.HydraResponse, error) {
 	name := "CallHydraToFetchSubject"
 	childCtx, span := otel.Tracer(name).Start(ctx, "CallHydraToFetchSubject")
 	defer span.End()
-	
-	config := configs.GetConfig()
-	httpClient := utils.NewHttpClient(hydraSvc.httpClient)
-	var headers = make(map[string]string)
-	var issuerUrl, issuerPath string
+
+	//A request without token is authenticated by its client certificate, if verified
+	if peer := utils.PeerSubject(ctx); len(bearer) <= 0 && peer != "" {
+		subject := CertificateSubjectPrefix + peer
+		logging.AddFields(ctx, log.Fields{"issuer": CertificateIssuer, "subject": subject})
+		return http.StatusOK, model.HydraResponse{Active: true, Subject: subject, ClientId: peer, TokenType: CertificateIssuer, Issuer: CertificateIssuer}, nil
+	}
 
 	if hasIssuer == true && issuer == "" {
-		log.Error("Invalid query params")
-		return http.StatusBadRequest, "", errors.New("Invalid query params")
-	}
-
-	if issuer == "accounts" {
-		issuerUrl = config.GetString("issuer.accounts.url")
-		issuerPath = config.GetString("issuer.accounts.path.introspect")
-	} else if issuer == "xpert" {
-		issuerUrl = config.GetString("issuer.xpert.url")
-		issuerPath = config.GetString("issuer.xpert.path.introspect")
-	} else if issuer == "tars" {
-		issuerUrl = config.GetString("issuer.tars.url")
-		issuerPath = config.GetString("issuer.tars.path.introspect")
-	} else {
-		issuerUrl = config.GetString("issuer.bouncer.url")
-		issuerPath = config.GetString("issuer.bouncer.path.introspect")
-	} 
-	u, _ := url.ParseRequestURI(issuerUrl)
-	u.Path = issuerPath
+		logging.FromContext(ctx).Error("Invalid query params")
+		return http.StatusBadRequest, model.HydraResponse{}, errors.New("Invalid query params")
+	}
+
+	issuerConfig, found := hydraSvc.issuers.get(issuer)
+	if !found {
+		logging.FromContext(ctx).Error("Issuer is not configured: ", issuer)
+		return http.StatusFailedDependency, model.HydraResponse{}, errors.New("Issuer is not configured")
+	}
+	if issuerConfig.Unavailable != nil {
+		logging.FromContext(ctx).Error("Issuer ", issuerConfig.Name, " is unavailable: ", issuerConfig.Unavailable)
+		return http.StatusFailedDependency, model.HydraResponse{}, errors.New("Issuer is unavailable")
+	}
 
 	if len(bearer) <= 0 {
-		log.Error("Bearer token absent")
-		return http.StatusUnauthorized, "", errors.New("Bearer token absent")
+		logging.FromContext(ctx).Error("Bearer token absent")
+		return http.StatusUnauthorized, model.HydraResponse{}, errors.New("Bearer token absent")
 	}
 
 	validBearer := strings.HasPrefix(bearer, "Bearer ") || strings.HasPrefix(bearer, "bearer ")
 	if !validBearer {
-		log.Error("Authorization header format is not valid - ", bearer)
-		return http.StatusUnauthorized, "", errors.New("Authorization header format is not valid")
+		logging.FromContext(ctx).Error("Authorization header format is not valid")
+		return http.StatusUnauthorized, model.HydraResponse{}, errors.New("Authorization header format is not valid")
 	}
 	token := strings.Split(bearer, " ")[1]
-	
+	//A token is only valid for the issuer it was introspected by, even when
+	//several issuers are served by the same kind of server
+	tokenKey := hydraSvc.tokenHasher.Hash(issuerConfig.Name + ":" + token)
+
 	//Cache Read
-	subject, found := hydraSvc.cacheClient.Get(token)
-	if found || subject != nil{
-		log.Info("Subject found in cache")
-		return http.StatusOK, subject.(string), nil
+	cached, found := hydraSvc.cacheClient.Get(tokenKey)
+	if entry, isToken := cached.(*cachedToken); found && isToken && entry.live(hydraSvc.now()) {
+		hydraResponse := entry.response
+		hydraResponse.Issuer = issuerConfig.Name
+		logging.AddFields(ctx, log.Fields{"issuer": issuerConfig.Name, "subject": hydraResponse.Subject})
+		logging.FromContext(ctx).Info("Subject found in cache")
+		metrics.TokenCache.WithLabelValues(issuerConfig.Name, CacheHit).Inc()
+		now := hydraSvc.now()
+		if issuerConfig.Type == APIKeyIssuerType {
+			hydraSvc.keyUsage.touch(issuerConfig, HashAPIKey(token), hydraResponse.ClientId, now)
+		}
+		if entry.startRefresh(now) {
+			go hydraSvc.refresh(issuerConfig, bearer, token, tokenKey)
+		}
+		return http.StatusOK, hydraResponse, nil
+	}
+	if hydraSvc.negativeCache.contains(tokenKey) {
+		logging.FromContext(ctx).Info("Token found in negative cache")
+		metrics.TokenCache.WithLabelValues(issuerConfig.Name, CacheNegativeHit).Inc()
+		metrics.InvalidTokens.WithLabelValues(metrics.NegativeCacheSource).Inc()
+		hydraSvc.negativeCache.recordInvalid(utils.ClientIP(ctx))
+		return http.StatusUnauthorized, model.HydraResponse{}, errors.New("Invalid token")
 	}
 
+	metrics.TokenCache.WithLabelValues(issuerConfig.Name, CacheMiss).Inc()
+
+	//Concurrent misses for the same token share a single Hydra call
+	leader := false
+	//The shared call outlives the request leading it, within the issuer timeout
+	result, err, _ := hydraSvc.group.Do(tokenKey, func() (interface{}, error) {
+		leader = true
+		callCtx, cancel := context.WithTimeout(utils.WithoutCancel(childCtx), issuerConfig.Timeout)
+		defer cancel()
+		return hydraSvc.introspect(callCtx, issuerConfig, bearer, token, tokenKey)
+	})
+	if !leader {
+		metrics.CoalescedRequests.WithLabelValues(utils.HydraUpstream).Inc()
+	}
+	res := result.(introspection)
+	res.response.Issuer = issuerConfig.Name
+	logging.AddFields(ctx, log.Fields{"issuer": issuerConfig.Name, "subject": res.response.Subject})
+	if res.status == http.StatusUnauthorized {
+		hydraSvc.negativeCache.recordInvalid(utils.ClientIP(ctx))
+	}
+	return res.status, res.response, err
+}
+
+// InvalidTokenCount returns how many rejected tokens a client presented in
+// the current rate window.
+func (hydraSvc hydraService) InvalidTokenCount(client string) int {
+	return hydraSvc.negativeCache.invalidCount(client)
+}
+
+// refresh introspects a cached token again ahead of its expiry. A token
+// found invalid is evicted, an upstream failure leaves the cached response
+// until it expires.
+func (hydraSvc hydraService) refresh(issuer Issuer, bearer string, token string, tokenKey string) {
+	metrics.TokenCache.WithLabelValues(issuer.Name, CacheRefresh).Inc()
+	result, err, _ := hydraSvc.group.Do(tokenKey, func() (interface{}, error) {
+		return hydraSvc.introspect(context.Background(), issuer, bearer, token, tokenKey)
+	})
+	if res := result.(introspection); res.status == http.StatusUnauthorized {
+		hydraSvc.cacheClient.Delete(tokenKey)
+	} else if err != nil {
+		log.Warn("Unable to refresh cached token of issuer ", issuer.Name, ": ", err)
+	}
+}
+
+// cache stores a response for ttl within the bounds of the issuer's cache
+// policy.
+func (hydraSvc hydraService) cache(issuer Issuer, tokenKey string, response model.HydraResponse, ttl time.Duration) {
+	ttl, cacheable := issuer.Cache.clamp(ttl)
+	if !cacheable {
+		metrics.TokenCache.WithLabelValues(issuer.Name, CacheUncached).Inc()
+		return
+	}
+	now := hydraSvc.now()
+	hydraSvc.cacheClient.Set(tokenKey, &cachedToken{response: response, refreshAt: issuer.Cache.refreshAt(now, ttl), expiresAt: now.Add(ttl)}, ttl)
+	metrics.TokenCacheTTL.WithLabelValues(issuer.Name).Observe(ttl.Seconds())
+}
+
+func (hydraSvc hydraService) introspect(ctx context.Context, issuer Issuer, bearer string, token string, tokenKey string) (introspection, error) {
+	switch issuer.Type {
+	case APIKeyIssuerType:
+		return hydraSvc.introspectAPIKey(ctx, issuer, token, tokenKey)
+	case IntrospectionIssuerType:
+		return hydraSvc.introspectRFC7662(ctx, issuer, token, tokenKey)
+	}
+	ctx, cancel := context.WithTimeout(ctx, issuer.Timeout)
+	defer cancel()
+	httpClient := utils.NewHttpClient(hydraSvc.httpClient)
+	var headers = make(map[string]string)
+
 	data := url.Values{}
 	data.Set("token", token)
 	headers["Authorization"] = bearer
 	headers["Content-Type"] = "application/x-www-form-urlencoded"
-	log.Info(u.String())
+	logging.FromContext(ctx).Info(issuer.IntrospectUrl)
 
-	resp, err := httpClient.SendRequest(childCtx, http.MethodPost, u.String(), strings.NewReader(data.Encode()), headers)
+	metrics.UpstreamRequests.WithLabelValues(utils.HydraUpstream).Inc()
+	resp, err := httpClient.SendRequest(ctx, http.MethodPost, issuer.IntrospectUrl, strings.NewReader(data.Encode()), headers)
 	if err != nil {
-		log.Error("Errored when sending request to the server", err.Error())
-		return http.StatusFailedDependency, "", err
+		logging.FromContext(ctx).Error("Errored when sending request to the server", err.Error())
+		return introspection{status: http.StatusFailedDependency}, err
 	}
+	defer resp.Body.Close()
 	var hydraResponse model.HydraResponse
 	err = json.NewDecoder(resp.Body).Decode(&hydraResponse)
 	if err != nil {
-		log.Error("Decoding error: ", err.Error())
-		return http.StatusFailedDependency, "", err
+		logging.FromContext(ctx).Error("Decoding error: ", err.Error())
+		return introspection{status: http.StatusFailedDependency}, err
 	}
-	log.Info("Subject: ", hydraResponse.Subject)
+	logging.FromContext(ctx).Info("Subject: ", hydraResponse.Subject)
 	if hydraResponse.Subject == "" {
-		log.Error("Subject is nil!")
-		return http.StatusUnauthorized, hydraResponse.Subject, errors.New("Invalid token")
+		logging.FromContext(ctx).Error("Subject is nil!")
+		metrics.InvalidTokens.WithLabelValues(utils.HydraUpstream).Inc()
+		if resp.StatusCode == http.StatusOK {
+			hydraSvc.negativeCache.add(tokenKey)
+		}
+		return introspection{status: http.StatusUnauthorized, response: hydraResponse}, errors.New("Invalid token")
 	}
 
 	//Cache Store
-	tokenValidity := hydraResponse.Expiry-int(time.Now().Unix())-config.GetInt("failsafe_interval")
-	hydraSvc.cacheClient.Set(token, hydraResponse.Subject, time.Duration(tokenValidity)*time.Second)
+	hydraSvc.cache(issuer, tokenKey, hydraResponse, issuer.Cache.tokenTTL(hydraResponse.Expiry, hydraSvc.now()))
 
-	return http.StatusOK, hydraResponse.Subject, err
+	return introspection{status: http.StatusOK, response: hydraResponse}, err
+}
+
+// introspectAPIKey resolves a static key of an apikey issuer. The response
+// is cached for the issuer's cache_ttl, or until the key expires if sooner.
+func (hydraSvc hydraService) introspectAPIKey(ctx context.Context, issuer Issuer, token string, tokenKey string) (introspection, error) {
+	keyHash := HashAPIKey(token)
+	key, err := issuer.APIKeys.Lookup(ctx, keyHash)
+	if err != nil {
+		logging.FromContext(ctx).Error("Unable to look up API key: ", err.Error())
+		return introspection{status: http.StatusFailedDependency}, err
+	}
+	now := hydraSvc.now()
+	if key == nil || key.Expired(now) {
+		logging.FromContext(ctx).Error("API key is unknown or expired")
+		metrics.InvalidTokens.WithLabelValues(APIKeyIssuerType).Inc()
+		hydraSvc.negativeCache.add(tokenKey)
+		return introspection{status: http.StatusUnauthorized}, errors.New("Invalid token")
+	}
+	hydraSvc.keyUsage.touch(issuer, keyHash, key.ClientId, now)
+
+	response := model.HydraResponse{
+		Active:    true,
+		Scope:     strings.Join(key.Scopes, " "),
+		ClientId:  key.ClientId,
+		Subject:   key.Subject,
+		TokenType: APIKeyTokenType,
+	}
+	ttl := issuer.CacheTTL
+	if !key.ExpiresAt.IsZero() {
+		response.Expiry = int(key.ExpiresAt.Unix())
+		if untilExpiry := key.ExpiresAt.Sub(now); untilExpiry < ttl {
+			ttl = untilExpiry
+		}
+	}
+
+	//Cache Store
+	hydraSvc.cache(issuer, tokenKey, response, ttl)
+	return introspection{status: http.StatusOK, response: response}, nil
 }
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/livspaceeng/ozone/internal/logging"
+	"github.com/livspaceeng/ozone/internal/metrics"
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/livspaceeng/ozone/internal/utils"
+	"github.com/spf13/viper"
+)
+
+const (
+	// IntrospectionIssuerType is a standard RFC 7662 introspection endpoint,
+	// authenticated with ozone's own client credentials.
+	IntrospectionIssuerType = "introspection"
+
+	ClientSecretBasic = "client_secret_basic"
+	ClientSecretPost  = "client_secret_post"
+
+	DiscoveryPath = "/.well-known/openid-configuration"
+	discoveryTTL  = time.Hour
+)
+
+// ClaimMapping names the introspection claims holding the subject, expiry,
+// scopes and client id. A name may be a dotted path into nested claims.
+type ClaimMapping struct {
+	Subject  string
+	Expiry   string
+	Scope    string
+	ClientId string
+}
+
+var defaultClaims = ClaimMapping{Subject: "sub", Expiry: "exp", Scope: "scope", ClientId: "client_id"}
+
+func loadClaimMapping(config *viper.Viper, name string) ClaimMapping {
+	prefix := "issuer." + name + ".claims."
+	claimOrDefault := func(key string, fallback string) string {
+		if claim := config.GetString(prefix + key); claim != "" {
+			return claim
+		}
+		return fallback
+	}
+	return ClaimMapping{
+		Subject:  claimOrDefault("subject", defaultClaims.Subject),
+		Expiry:   claimOrDefault("expiry", defaultClaims.Expiry),
+		Scope:    claimOrDefault("scope", defaultClaims.Scope),
+		ClientId: claimOrDefault("client_id", defaultClaims.ClientId),
+	}
+}
+
+func validateIntrospectionIssuer(config *viper.Viper, name string) error {
+	prefix := "issuer." + name + "."
+	u, err := url.ParseRequestURI(config.GetString(prefix + "url"))
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("issuer %s has an invalid url", name)
+	}
+	if config.GetString(prefix+"client_id") == "" {
+		return fmt.Errorf("issuer %s has no client_id", name)
+	}
+	if config.GetString(prefix+"path.introspect") == "" && !config.GetBool(prefix+"discovery") {
+		return fmt.Errorf("issuer %s needs either path.introspect or discovery", name)
+	}
+	switch config.GetString(prefix + "auth_method") {
+	case "", ClientSecretBasic, ClientSecretPost:
+	default:
+		return fmt.Errorf("issuer %s has an unknown auth_method %q", name, config.GetString(prefix+"auth_method"))
+	}
+	return nil
+}
+
+// loadIntrospectionIssuer builds an issuer whose introspection endpoint is
+// either configured or discovered below its url.
+func loadIntrospectionIssuer(config *viper.Viper, name string) (Issuer, error) {
+	if err := validateIntrospectionIssuer(config, name); err != nil {
+		return Issuer{}, err
+	}
+	prefix := "issuer." + name + "."
+	u, _ := url.ParseRequestURI(config.GetString(prefix + "url"))
+	issuer := Issuer{
+		Name:         name,
+		Type:         IntrospectionIssuerType,
+		Timeout:      time.Duration(intOrDefault(config.GetInt(prefix+"timeout"), defaultIssuerTimeout)) * time.Second,
+		ClientId:     config.GetString(prefix + "client_id"),
+		ClientSecret: config.GetString(prefix + "client_secret"),
+		AuthMethod:   config.GetString(prefix + "auth_method"),
+		Claims:       loadClaimMapping(config, name),
+	}
+	if issuer.AuthMethod == "" {
+		issuer.AuthMethod = ClientSecretBasic
+	}
+	if path := config.GetString(prefix + "path.introspect"); path != "" {
+		introspectUrl := *u
+		introspectUrl.Path = path
+		issuer.IntrospectUrl = introspectUrl.String()
+	} else {
+		discoveryUrl := *u
+		discoveryUrl.Path = strings.TrimSuffix(u.Path, "/") + DiscoveryPath
+		issuer.DiscoveryUrl = discoveryUrl.String()
+	}
+	return issuer, nil
+}
+
+// introspectionEndpoint returns the configured endpoint of an issuer, or the
+// one its discovery document advertises. Discovered endpoints are cached.
+func (hydraSvc hydraService) introspectionEndpoint(ctx context.Context, issuer Issuer) (string, error) {
+	if issuer.IntrospectUrl != "" {
+		return issuer.IntrospectUrl, nil
+	}
+	if endpoint, found := hydraSvc.discovered.Get(issuer.DiscoveryUrl); found {
+		return endpoint.(string), nil
+	}
+	endpoint, err, _ := hydraSvc.group.Do("discovery:"+issuer.DiscoveryUrl, func() (interface{}, error) {
+		resp, err := utils.NewHttpClient(hydraSvc.httpClient).SendRequest(ctx, http.MethodGet, issuer.DiscoveryUrl, nil, map[string]string{"Accept": "application/json"})
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("discovery of issuer %s answered %d", issuer.Name, resp.StatusCode)
+		}
+		var document struct {
+			IntrospectionEndpoint string `json:"introspection_endpoint"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+			return "", err
+		}
+		if _, err := url.ParseRequestURI(document.IntrospectionEndpoint); err != nil {
+			return "", fmt.Errorf("discovery of issuer %s has no valid introspection_endpoint", issuer.Name)
+		}
+		hydraSvc.discovered.Set(issuer.DiscoveryUrl, document.IntrospectionEndpoint, discoveryTTL)
+		return document.IntrospectionEndpoint, nil
+	})
+	return endpoint.(string), err
+}
+
+// introspectRFC7662 resolves a token against a standard introspection
+// endpoint, authenticating as the issuer's client rather than forwarding the
+// caller's bearer, and maps the configured claims to a response.
+func (hydraSvc hydraService) introspectRFC7662(ctx context.Context, issuer Issuer, token string, tokenKey string) (introspection, error) {
+	ctx, cancel := context.WithTimeout(ctx, issuer.Timeout)
+	defer cancel()
+
+	endpoint, err := hydraSvc.introspectionEndpoint(ctx, issuer)
+	if err != nil {
+		logging.FromContext(ctx).Error("Unable to discover introspection endpoint: ", err.Error())
+		return introspection{status: http.StatusFailedDependency}, err
+	}
+
+	data := url.Values{}
+	data.Set("token", token)
+	data.Set("token_type_hint", "access_token")
+	headers := map[string]string{"Content-Type": "application/x-www-form-urlencoded", "Accept": "application/json"}
+	if issuer.AuthMethod == ClientSecretPost {
+		data.Set("client_id", issuer.ClientId)
+		data.Set("client_secret", issuer.ClientSecret)
+	} else {
+		credentials := url.QueryEscape(issuer.ClientId) + ":" + url.QueryEscape(issuer.ClientSecret)
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	metrics.UpstreamRequests.WithLabelValues(utils.IntrospectionUpstream).Inc()
+	resp, err := utils.NewHttpClient(hydraSvc.httpClient).SendRequest(ctx, http.MethodPost, endpoint, strings.NewReader(data.Encode()), headers)
+	if err != nil {
+		logging.FromContext(ctx).Error("Errored when sending request to the server", err.Error())
+		return introspection{status: http.StatusFailedDependency}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logging.FromContext(ctx).Error("Introspection endpoint answered ", resp.StatusCode)
+		return introspection{status: http.StatusFailedDependency}, fmt.Errorf("Introspection endpoint answered %d", resp.StatusCode)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	var claims map[string]interface{}
+	if err := decoder.Decode(&claims); err != nil {
+		logging.FromContext(ctx).Error("Decoding error: ", err.Error())
+		return introspection{status: http.StatusFailedDependency}, err
+	}
+
+	response := mapClaims(claims, issuer.Claims)
+	if !response.Active || response.Subject == "" {
+		logging.FromContext(ctx).Error("Token is inactive or has no subject")
+		metrics.InvalidTokens.WithLabelValues(utils.IntrospectionUpstream).Inc()
+		hydraSvc.negativeCache.add(tokenKey)
+		return introspection{status: http.StatusUnauthorized, response: response}, errors.New("Invalid token")
+	}
+
+	//Cache Store, a token without expiry is not cached
+	hydraSvc.cache(issuer, tokenKey, response, issuer.Cache.tokenTTL(response.Expiry, hydraSvc.now()))
+	return introspection{status: http.StatusOK, response: response}, nil
+}
+
+// mapClaims builds a response from introspection claims. Scopes may be a
+// space separated string or a list.
+func mapClaims(claims map[string]interface{}, mapping ClaimMapping) model.HydraResponse {
+	active, _ := claims["active"].(bool)
+	response := model.HydraResponse{
+		Active:    active,
+		Subject:   stringClaim(claims, mapping.Subject),
+		ClientId:  stringClaim(claims, mapping.ClientId),
+		TokenType: stringClaim(claims, "token_type"),
+		Expiry:    intClaim(claims, mapping.Expiry),
+		IssuedAt:  intClaim(claims, "iat"),
+	}
+	switch scope := claim(claims, mapping.Scope).(type) {
+	case string:
+		response.Scope = scope
+	case []interface{}:
+		scopes := make([]string, 0, len(scope))
+		for _, s := range scope {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+		response.Scope = strings.Join(scopes, " ")
+	}
+	return response
+}
+
+func claim(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+func stringClaim(claims map[string]interface{}, path string) string {
+	switch value := claim(claims, path).(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	}
+	return ""
+}
+
+func intClaim(claims map[string]interface{}, path string) int {
+	if value, ok := claim(claims, path).(json.Number); ok {
+		if n, err := value.Int64(); err == nil {
+			return int(n)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/livspaceeng/ozone/configs"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	DefaultIssuer        = "bouncer"
+	defaultIssuerTimeout = 3
+	defaultKeyCacheTTL   = 60
+
+	// CertificateIssuer is the issuer of subjects authenticated by a client
+	// certificate rather than a token.
+	CertificateIssuer = "certificate"
+	// CertificateSubjectPrefix is put before the identity of a client
+	// certificate to make its Keto subject, which then cannot be mistaken
+	// for a subject of a token
+	CertificateSubjectPrefix = CertificateIssuer + ";"
+
+	HydraIssuerType  = "hydra"
+	APIKeyIssuerType = "apikey"
+)
+
+// Issuer is a token introspection endpoint configured under issuer.<name>,
+// or for the apikey type a store of static keys. Hydra issuers are called
+// with the caller's bearer, introspection issuers with their own client.
+type Issuer struct {
+	Name          string
+	Type          string
+	IntrospectUrl string
+	Timeout       time.Duration
+	APIKeys       APIKeyStore
+	CacheTTL      time.Duration
+	ClientId      string
+	ClientSecret  string
+	AuthMethod    string
+	DiscoveryUrl  string
+	Claims        ClaimMapping
+	Cache         CachePolicy
+	// Unavailable is why the issuer could not be loaded. Its tokens are
+	// refused rather than sent to another issuer.
+	Unavailable error
+}
+
+// issuerRegistry holds the configured issuers and is swapped atomically
+// whenever the config is reloaded. API key stores are kept across reloads
+// unless their source changed.
+type issuerRegistry struct {
+	issuers atomic.Value
+
+	storesMu sync.Mutex
+	stores   map[string]keyStoreEntry
+}
+
+type keyStoreEntry struct {
+	source string
+	store  APIKeyStore
+}
+
+func init() {
+	configs.RegisterValidator(func(config *viper.Viper) error {
+		for name := range config.GetStringMap("issuer") {
+			if err := validateCachePolicy(config, name); err != nil {
+				return err
+			}
+			switch issuerType(config, name) {
+			case HydraIssuerType:
+			case IntrospectionIssuerType:
+				if err := validateIntrospectionIssuer(config, name); err != nil {
+					return err
+				}
+			case APIKeyIssuerType:
+				if err := validateKeySource(config, name); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("issuer %s has an unknown type %q", name, issuerType(config, name))
+			}
+		}
+		return nil
+	})
+}
+
+func newIssuerRegistry(config *configs.Store) *issuerRegistry {
+	registry := &issuerRegistry{stores: make(map[string]keyStoreEntry)}
+	registry.reload(config.Get())
+	config.OnReload(registry.reload)
+	return registry
+}
+
+func issuerType(config *viper.Viper, name string) string {
+	if t := config.GetString("issuer." + name + ".type"); t != "" {
+		return t
+	}
+	return HydraIssuerType
+}
+
+func loadIssuers(config *viper.Viper, keyStore func(name string) (APIKeyStore, error)) map[string]Issuer {
+	issuers := make(map[string]Issuer)
+	for name := range config.GetStringMap("issuer") {
+		if issuerType(config, name) == IntrospectionIssuerType {
+			issuer, err := loadIntrospectionIssuer(config, name)
+			if err != nil {
+				log.Error("Unable to load issuer ", name, ": ", err)
+				issuer = Issuer{Name: name, Type: IntrospectionIssuerType, Unavailable: err}
+			}
+			issuers[name] = issuer
+			continue
+		}
+		if issuerType(config, name) == APIKeyIssuerType {
+			store, err := keyStore(name)
+			if err != nil {
+				log.Error("Unable to load keys of issuer ", name, ": ", err)
+			}
+			cacheTTL := intOrDefault(config.GetInt("issuer."+name+".cache_ttl"), defaultKeyCacheTTL)
+			issuers[name] = Issuer{
+				Name:        name,
+				Type:        APIKeyIssuerType,
+				Timeout:     time.Duration(intOrDefault(config.GetInt("issuer."+name+".timeout"), defaultIssuerTimeout)) * time.Second,
+				APIKeys:     store,
+				CacheTTL:    time.Duration(cacheTTL) * time.Second,
+				Unavailable: err,
+			}
+			continue
+		}
+		u, err := url.ParseRequestURI(config.GetString("issuer." + name + ".url"))
+		if err != nil {
+			log.Error("Unable to load issuer ", name, ": ", err)
+			issuers[name] = Issuer{Name: name, Type: HydraIssuerType, Unavailable: err}
+			continue
+		}
+		u.Path = config.GetString("issuer." + name + ".path.introspect")
+		timeout := config.GetInt("issuer." + name + ".timeout")
+		if timeout <= 0 {
+			timeout = defaultIssuerTimeout
+		}
+		issuers[name] = Issuer{
+			Name:          name,
+			Type:          HydraIssuerType,
+			IntrospectUrl: u.String(),
+			Timeout:       time.Duration(timeout) * time.Second,
+		}
+	}
+	for name, issuer := range issuers {
+		issuer.Cache = loadCachePolicy(config, name)
+		issuers[name] = issuer
+	}
+	return issuers
+}
+
+func (r *issuerRegistry) reload(config *viper.Viper) {
+	r.storesMu.Lock()
+	defer r.storesMu.Unlock()
+
+	stores := make(map[string]keyStoreEntry)
+	issuers := loadIssuers(config, func(name string) (APIKeyStore, error) {
+		source := keySource(config, name)
+		if entry, found := r.stores[name]; found && entry.source == source {
+			stores[name] = entry
+			return entry.store, nil
+		}
+		store, err := newAPIKeyStore(config, name)
+		if err != nil {
+			return nil, err
+		}
+		stores[name] = keyStoreEntry{source: source, store: store}
+		return store, nil
+	})
+	r.issuers.Store(issuers)
+
+	for name, entry := range r.stores {
+		if kept, found := stores[name]; !found || kept.store != entry.store {
+			if err := entry.store.Close(); err != nil {
+				log.Error("Unable to close keys of issuer ", name, ": ", err)
+			}
+		}
+	}
+	r.stores = stores
+}
+
+// get returns the named issuer. Unknown names resolve to the default
+// Bouncer issuer, as the check API always did, but a configured issuer that
+// failed to load is returned as unavailable.
+func (r *issuerRegistry) get(name string) (Issuer, bool) {
+	issuers := r.issuers.Load().(map[string]Issuer)
+	if issuer, found := issuers[name]; found {
+		return issuer, true
+	}
+	issuer, found := issuers[DefaultIssuer]
+	return issuer, found
+}
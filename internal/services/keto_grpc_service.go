@@ -0,0 +1,315 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/logging"
+	"github.com/livspaceeng/ozone/internal/metrics"
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/livspaceeng/ozone/internal/utils"
+	rts "github.com/ory/keto/proto/ory/keto/relation_tuples/v1alpha2"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type ketoGrpcService struct {
+	ketoRouter *utils.KetoRouter
+	schema     *Schema
+	checks     *checkRunner
+}
+
+// NewKetoGrpcService returns a KetoService talking to the Keto gRPC API over
+// the persistent connections of ketoRouter.
+func NewKetoGrpcService(config *configs.Store, ketoRouter *utils.KetoRouter, schema *Schema) KetoService {
+	return &ketoGrpcService{
+		ketoRouter: ketoRouter,
+		schema:     schema,
+		checks:     newCheckRunner(config),
+	}
+}
+
+func (ketoSvc ketoGrpcService) check(ctx context.Context, backend *utils.KetoBackend, tuple model.RelationTuple, request *rts.CheckRequest) (bool, error) {
+	return ketoSvc.checks.run(ctx, backend, tuple, func(ctx context.Context) (bool, error) {
+		ketoResponse, err := rts.NewCheckServiceClient(backend.Conn).Check(ctx, request)
+		if err != nil {
+			logging.FromContext(ctx).Error("Error when calling `CheckService.Check`: ", err)
+			return false, err
+		}
+		return ketoResponse.Allowed, nil
+	})
+}
+
+func (ketoSvc ketoGrpcService) ValidatePolicy(ctx context.Context, namespace string, relation string, object string, hydraResponse string) (int, string, error) {
+	name := "CallKetoToValidatePolicy"
+	childCtx, span := otel.Tracer(name).Start(ctx, "CallKetoToValidatePolicy")
+	defer span.End()
+	backend := ketoSvc.ketoRouter.ForNamespace(namespace)
+	childCtx, cancel := context.WithTimeout(childCtx, backend.Timeout)
+	defer cancel()
+
+	if namespace == "" || relation == "" || object == "" || hydraResponse == "" {
+		logging.FromContext(ctx).Error(utils.InvalidError)
+		return http.StatusBadRequest, "", errors.New(utils.InvalidError)
+	}
+	if err := ketoSvc.schema.ValidateTuple(ctx, model.RelationTuple{Namespace: namespace, Object: object, Relation: relation}); err != nil {
+		logging.FromContext(ctx).Error(err.Error())
+		return http.StatusBadRequest, "", err
+	}
+
+	tuple := model.RelationTuple{Namespace: namespace, Object: object, Relation: relation, SubjectId: hydraResponse}
+	allowed, err := ketoSvc.check(childCtx, backend, tuple, &rts.CheckRequest{
+		Tuple: &rts.RelationTuple{
+			Namespace: namespace,
+			Object:    object,
+			Relation:  relation,
+			Subject:   rts.NewSubjectID(hydraResponse),
+		},
+		Snaptoken: utils.Snaptoken(ctx),
+	})
+	if err != nil {
+		return http.StatusFailedDependency, "", err
+	}
+
+	if !allowed {
+		logging.FromContext(ctx).Info("Policy is not created for subject: ", hydraResponse, " Namespace: ", namespace, utils.RelationLog, relation, utils.ObjectLog, object)
+		return http.StatusForbidden, hydraResponse, err
+	}
+	return http.StatusOK, hydraResponse, err
+}
+
+func (ketoSvc ketoGrpcService) ValidatePolicyWithSet(ctx context.Context, namespace string, relation string, object string, subjectSetNamespace string, subjectSetRelation string, subjectSetObject string) (int, string, error) {
+	name := "CallKetoToValidatePolicyWithSet"
+	childCtx, span := otel.Tracer(name).Start(ctx, "CallKetoToValidatePolicyWithSet")
+	defer span.End()
+	backend := ketoSvc.ketoRouter.ForNamespace(namespace)
+	childCtx, cancel := context.WithTimeout(childCtx, backend.Timeout)
+	defer cancel()
+
+	if namespace == "" || relation == "" || object == "" || subjectSetNamespace == "" || subjectSetRelation == "" || subjectSetObject == "" {
+		logging.FromContext(ctx).Error(utils.InvalidError)
+		return http.StatusBadRequest, "", errors.New(utils.InvalidError)
+	}
+	if err := ketoSvc.schema.ValidateTuple(ctx, model.RelationTuple{Namespace: namespace, Object: object, Relation: relation,
+		SubjectSet: &model.SubjectSet{Namespace: subjectSetNamespace, Object: subjectSetObject, Relation: subjectSetRelation}}); err != nil {
+		logging.FromContext(ctx).Error(err.Error())
+		return http.StatusBadRequest, "", err
+	}
+
+	tuple := model.RelationTuple{Namespace: namespace, Object: object, Relation: relation, SubjectSet: &model.SubjectSet{Namespace: subjectSetNamespace, Object: subjectSetObject, Relation: subjectSetRelation}}
+	allowed, err := ketoSvc.check(childCtx, backend, tuple, &rts.CheckRequest{
+		Tuple: &rts.RelationTuple{
+			Namespace: namespace,
+			Object:    object,
+			Relation:  relation,
+			Subject:   rts.NewSubjectSet(subjectSetNamespace, subjectSetObject, subjectSetRelation),
+		},
+		Snaptoken: utils.Snaptoken(ctx),
+	})
+	if err != nil {
+		return http.StatusFailedDependency, "", err
+	}
+
+	if !allowed {
+		logging.FromContext(ctx).Info("Policy is not created for subjectSetNamespace: ", subjectSetNamespace, " subjectSetRelation: ", subjectSetRelation, " subjectSetObject: ", subjectSetObject, " Namespace: ", namespace, utils.RelationLog, relation, utils.ObjectLog, object)
+		return http.StatusForbidden, "Policy does not exist", err
+	}
+	return http.StatusOK, "Policy exists", err
+}
+
+func (ketoSvc ketoGrpcService) ExpandPolicy(ctx context.Context, namespace string, relation string, object string, maxDepth string, hasDepth bool) (int, *model.ExpandTree, error) {
+	name := "CallKetoToExpandPolicy"
+	childCtx, span := otel.Tracer(name).Start(ctx, "CallKetoToExpandPolicy")
+	defer span.End()
+	backend := ketoSvc.ketoRouter.ForNamespace(namespace)
+	childCtx, cancel := context.WithTimeout(childCtx, backend.Timeout)
+	defer cancel()
+
+	if namespace == "" || relation == "" || object == "" || (hasDepth == true && maxDepth == "") {
+		logging.FromContext(ctx).Error(utils.InvalidError)
+		return http.StatusBadRequest, nil, errors.New(utils.InvalidError)
+	}
+	if err := ketoSvc.schema.ValidateTuple(ctx, model.RelationTuple{Namespace: namespace, Object: object, Relation: relation}); err != nil {
+		logging.FromContext(ctx).Error(err.Error())
+		return http.StatusBadRequest, nil, err
+	}
+	depth, err := parseLimit(maxDepth)
+	if err != nil {
+		logging.FromContext(ctx).Error("MaxDepth cannot be converted to int: ", maxDepth)
+		return http.StatusBadRequest, nil, err
+	}
+
+	metrics.UpstreamRequests.WithLabelValues(utils.KetoUpstream).Inc()
+	metrics.KetoRequests.WithLabelValues(backend.Name).Inc()
+	resp, err := rts.NewExpandServiceClient(backend.Conn).Expand(childCtx, &rts.ExpandRequest{
+		Subject:   rts.NewSubjectSet(namespace, object, relation),
+		MaxDepth:  int32(depth),
+		Snaptoken: utils.Snaptoken(ctx),
+	})
+	if status.Code(err) == codes.NotFound || (err == nil && resp.Tree == nil) {
+		logging.FromContext(ctx).Info("Subject set not found with Namespace: ", namespace, utils.RelationLog, relation, utils.ObjectLog, object)
+		return http.StatusNotFound, nil, ErrSubjectSetNotFound
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("Error when calling `ExpandService.Expand`: ", err)
+		return http.StatusFailedDependency, nil, err
+	}
+	tree := expandTreeFromProto(resp.Tree)
+	return http.StatusOK, &tree, nil
+}
+
+func (ketoSvc ketoGrpcService) ListRelationTuples(ctx context.Context, query model.RelationTuple, pageToken string, pageSize string) (int, model.RelationTuples, error) {
+	name := "CallKetoToListRelationTuples"
+	childCtx, span := otel.Tracer(name).Start(ctx, "CallKetoToListRelationTuples")
+	defer span.End()
+	backend := ketoSvc.ketoRouter.ForNamespace(query.Namespace)
+	childCtx, cancel := context.WithTimeout(childCtx, backend.Timeout)
+	defer cancel()
+
+	size, err := parseLimit(pageSize)
+	if err != nil {
+		logging.FromContext(ctx).Error("PageSize cannot be converted to int: ", pageSize)
+		return http.StatusBadRequest, model.RelationTuples{}, err
+	}
+	if err := ketoSvc.schema.ValidateTuple(ctx, query); err != nil {
+		logging.FromContext(ctx).Error(err.Error())
+		return http.StatusBadRequest, model.RelationTuples{}, err
+	}
+
+	relationQuery := &rts.RelationQuery{}
+	if query.Namespace != "" {
+		relationQuery.Namespace = &query.Namespace
+	}
+	if query.Object != "" {
+		relationQuery.Object = &query.Object
+	}
+	if query.Relation != "" {
+		relationQuery.Relation = &query.Relation
+	}
+	if query.SubjectId != "" {
+		relationQuery.Subject = rts.NewSubjectID(query.SubjectId)
+	} else if query.SubjectSet != nil {
+		relationQuery.Subject = rts.NewSubjectSet(query.SubjectSet.Namespace, query.SubjectSet.Object, query.SubjectSet.Relation)
+	}
+
+	metrics.UpstreamRequests.WithLabelValues(utils.KetoUpstream).Inc()
+	metrics.KetoRequests.WithLabelValues(backend.Name).Inc()
+	resp, err := rts.NewReadServiceClient(backend.Conn).ListRelationTuples(childCtx, &rts.ListRelationTuplesRequest{
+		RelationQuery: relationQuery,
+		PageToken:     pageToken,
+		PageSize:      int32(size),
+		Snaptoken:     utils.Snaptoken(ctx),
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("Error when calling `ReadService.ListRelationTuples`: ", err)
+		return http.StatusFailedDependency, model.RelationTuples{}, err
+	}
+
+	tuples := model.RelationTuples{
+		RelationTuples: make([]model.RelationTuple, 0, len(resp.RelationTuples)),
+		NextPageToken:  resp.NextPageToken,
+	}
+	for _, relationTuple := range resp.RelationTuples {
+		tuples.RelationTuples = append(tuples.RelationTuples, relationTupleFromProto(relationTuple))
+	}
+	return http.StatusOK, tuples, nil
+}
+
+func (ketoSvc ketoGrpcService) PatchRelationTuples(ctx context.Context, deltas []model.RelationTupleDelta) (int, model.WriteResponse, error) {
+	name := "CallKetoToPatchRelationTuples"
+	childCtx, span := otel.Tracer(name).Start(ctx, "CallKetoToPatchRelationTuples")
+	defer span.End()
+
+	backend, err := writeBackend(ctx, ketoSvc.ketoRouter, ketoSvc.schema, deltas)
+	if err != nil {
+		logging.FromContext(ctx).Error(err.Error())
+		return http.StatusBadRequest, model.WriteResponse{}, err
+	}
+	if backend.WriteConn == nil {
+		logging.FromContext(ctx).Error("Keto write API is not configured for backend: ", backend.Name)
+		return http.StatusFailedDependency, model.WriteResponse{}, errors.New("Keto write API is not configured")
+	}
+	childCtx, cancel := context.WithTimeout(childCtx, backend.Timeout)
+	defer cancel()
+
+	request := &rts.TransactRelationTuplesRequest{}
+	for _, delta := range deltas {
+		action := rts.RelationTupleDelta_ACTION_INSERT
+		if delta.Action == DeleteAction {
+			action = rts.RelationTupleDelta_ACTION_DELETE
+		}
+		tuple := delta.RelationTuple
+		relationTuple := &rts.RelationTuple{Namespace: tuple.Namespace, Object: tuple.Object, Relation: tuple.Relation}
+		if tuple.SubjectId != "" {
+			relationTuple.Subject = rts.NewSubjectID(tuple.SubjectId)
+		} else {
+			relationTuple.Subject = rts.NewSubjectSet(tuple.SubjectSet.Namespace, tuple.SubjectSet.Object, tuple.SubjectSet.Relation)
+		}
+		request.RelationTupleDeltas = append(request.RelationTupleDeltas, &rts.RelationTupleDelta{Action: action, RelationTuple: relationTuple})
+	}
+
+	metrics.UpstreamRequests.WithLabelValues(utils.KetoUpstream).Inc()
+	metrics.KetoRequests.WithLabelValues(backend.Name).Inc()
+	resp, err := rts.NewWriteServiceClient(backend.WriteConn).TransactRelationTuples(childCtx, request)
+	if err != nil {
+		logging.FromContext(ctx).Error("Error when calling `WriteService.TransactRelationTuples`: ", err)
+		if status.Code(err) == codes.InvalidArgument {
+			return http.StatusBadRequest, model.WriteResponse{}, err
+		}
+		return http.StatusFailedDependency, model.WriteResponse{}, err
+	}
+	ketoSvc.checks.flush()
+
+	var writeResponse model.WriteResponse
+	if snaptokens := resp.GetSnaptokens(); len(snaptokens) > 0 {
+		writeResponse.Snaptoken = snaptokens[len(snaptokens)-1]
+	}
+	return http.StatusOK, writeResponse, nil
+}
+
+func expandTreeFromProto(node *rts.SubjectTree) model.ExpandTree {
+	tree := model.ExpandTree{Type: nodeTypeName(node.NodeType)}
+	tuple := node.Tuple
+	if tuple == nil && node.Subject != nil {
+		tuple = &rts.RelationTuple{Subject: node.Subject}
+	}
+	if tuple != nil {
+		relationTuple := relationTupleFromProto(tuple)
+		tree.Tuple = &relationTuple
+	}
+	for _, child := range node.Children {
+		tree.Children = append(tree.Children, expandTreeFromProto(child))
+	}
+	return tree
+}
+
+func relationTupleFromProto(relationTuple *rts.RelationTuple) model.RelationTuple {
+	tuple := model.RelationTuple{
+		Namespace: relationTuple.Namespace,
+		Object:    relationTuple.Object,
+		Relation:  relationTuple.Relation,
+		SubjectId: relationTuple.GetSubject().GetId(),
+	}
+	if set := relationTuple.GetSubject().GetSet(); set != nil {
+		tuple.SubjectSet = &model.SubjectSet{Namespace: set.Namespace, Object: set.Object, Relation: set.Relation}
+	}
+	return tuple
+}
+
+func nodeTypeName(nodeType rts.NodeType) string {
+	switch nodeType {
+	case rts.NodeType_NODE_TYPE_UNION:
+		return UnionNode
+	case rts.NodeType_NODE_TYPE_EXCLUSION:
+		return ExclusionNode
+	case rts.NodeType_NODE_TYPE_INTERSECTION:
+		return IntersectionNode
+	case rts.NodeType_NODE_TYPE_LEAF:
+		return LeafNode
+	default:
+		return "unspecified"
+	}
+}
@@ -2,136 +2,349 @@ package services
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"io"
+	"fmt"
 	"net/http"
 	"strconv"
 
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/logging"
+	"github.com/livspaceeng/ozone/internal/metrics"
+	"github.com/livspaceeng/ozone/internal/model"
 	"github.com/livspaceeng/ozone/internal/utils"
-	log "github.com/sirupsen/logrus"
+	client "github.com/ory/keto-client-go"
 	"go.opentelemetry.io/otel"
 )
 
 type KetoService interface {
 	ValidatePolicy(ctx context.Context, hydraResponse string, namespace string, relation string, object string) (int, string, error)
-	ValidatePolicyWithSet (ctx context.Context, namespace string, relation string, object string, subjectSetNamespace string, subjectSetRelation string, subjectSetObject string) (int, string, error)
-	ExpandPolicy (ctx context.Context, namespace string, relation string, object string, maxDepth string, hasDepth bool) (int, map[string]interface{}, error)
+	ValidatePolicyWithSet(ctx context.Context, namespace string, relation string, object string, subjectSetNamespace string, subjectSetRelation string, subjectSetObject string) (int, string, error)
+	ExpandPolicy(ctx context.Context, namespace string, relation string, object string, maxDepth string, hasDepth bool) (int, *model.ExpandTree, error)
+	ListRelationTuples(ctx context.Context, query model.RelationTuple, pageToken string, pageSize string) (int, model.RelationTuples, error)
+	PatchRelationTuples(ctx context.Context, deltas []model.RelationTupleDelta) (int, model.WriteResponse, error)
 }
 
+const (
+	InsertAction = "insert"
+	DeleteAction = "delete"
+)
+
+var ErrSubjectSetNotFound = errors.New("Subject set not found")
+
 type ketoService struct {
-	httpClient *http.Client
+	ketoRouter *utils.KetoRouter
+	schema     *Schema
+	checks     *checkRunner
 }
 
-func NewKetoService(httpClient *http.Client) KetoService {
+func NewKetoService(config *configs.Store, ketoRouter *utils.KetoRouter, schema *Schema) KetoService {
 	return &ketoService{
-		httpClient: httpClient,
+		ketoRouter: ketoRouter,
+		schema:     schema,
+		checks:     newCheckRunner(config),
+	}
+}
+
+// parseLimit parses an optional positive integer query param such as
+// max-depth or page_size.
+func parseLimit(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || limit < 0 {
+		return 0, errors.New("Invalid query params")
+	}
+	return limit, nil
+}
+
+// writeBackend validates deltas and returns the backend they are written
+// to. A patch is applied atomically by one Keto cluster, so every delta must
+// route to the same backend.
+func writeBackend(ctx context.Context, ketoRouter *utils.KetoRouter, schema *Schema, deltas []model.RelationTupleDelta) (*utils.KetoBackend, error) {
+	if len(deltas) == 0 {
+		return nil, errors.New("No relation tuple deltas")
 	}
+	var backend *utils.KetoBackend
+	for _, delta := range deltas {
+		tuple := delta.RelationTuple
+		if delta.Action != InsertAction && delta.Action != DeleteAction {
+			return nil, fmt.Errorf("Unknown action %q", delta.Action)
+		}
+		if tuple.Namespace == "" || tuple.Object == "" || tuple.Relation == "" || (tuple.SubjectId == "") == (tuple.SubjectSet == nil) {
+			return nil, errors.New(utils.InvalidError)
+		}
+		if err := schema.ValidateTuple(ctx, tuple); err != nil {
+			return nil, err
+		}
+		deltaBackend := ketoRouter.ForNamespace(tuple.Namespace)
+		if backend != nil && deltaBackend != backend {
+			return nil, errors.New("Relation tuple deltas span several Keto clusters")
+		}
+		backend = deltaBackend
+	}
+	return backend, nil
+}
+
+func (ketoSvc ketoService) check(ctx context.Context, backend *utils.KetoBackend, tuple model.RelationTuple, request func(ctx context.Context) client.PermissionApiCheckPermissionRequest) (bool, error) {
+	return ketoSvc.checks.run(ctx, backend, tuple, func(ctx context.Context) (bool, error) {
+		ketoResponse, r, err := request(ctx).Execute()
+		if err != nil {
+			logging.FromContext(ctx).Error("Error when calling `PermissionApi.CheckPermission``:\n", err, utils.HttpResponse, r)
+			return false, err
+		}
+		return ketoResponse.Allowed, nil
+	})
 }
 
-func (ketoSvc ketoService) ValidatePolicy (ctx context.Context, namespace string, relation string, object string, hydraResponse string) (int, string, error) {
+func (ketoSvc ketoService) ValidatePolicy(ctx context.Context, namespace string, relation string, object string, hydraResponse string) (int, string, error) {
 	name := "CallKetoToValidatePolicy"
 	childCtx, span := otel.Tracer(name).Start(ctx, "CallKetoToValidatePolicy")
 	defer span.End()
+	backend := ketoSvc.ketoRouter.ForNamespace(namespace)
+	childCtx, cancel := context.WithTimeout(childCtx, backend.Timeout)
+	defer cancel()
 
-	if namespace=="" || relation=="" || object=="" || hydraResponse=="" {
-		log.Error(utils.InvalidError)
+	if namespace == "" || relation == "" || object == "" || hydraResponse == "" {
+		logging.FromContext(ctx).Error(utils.InvalidError)
 		return http.StatusBadRequest, "", errors.New(utils.InvalidError)
 	}
+	if err := ketoSvc.schema.ValidateTuple(ctx, model.RelationTuple{Namespace: namespace, Object: object, Relation: relation}); err != nil {
+		logging.FromContext(ctx).Error(err.Error())
+		return http.StatusBadRequest, "", err
+	}
 
-	ketoResponse, r, err := utils.GetKetoReadClient().PermissionApi.CheckPermission(childCtx).
-		Namespace(namespace).
-		Relation(relation).
-		Object(object).
-		SubjectId(hydraResponse).
-		Execute()
+	tuple := model.RelationTuple{Namespace: namespace, Object: object, Relation: relation, SubjectId: hydraResponse}
+	allowed, err := ketoSvc.check(childCtx, backend, tuple, func(ctx context.Context) client.PermissionApiCheckPermissionRequest {
+		return backend.Client.PermissionApi.CheckPermission(ctx).
+			Namespace(namespace).
+			Relation(relation).
+			Object(object).
+			SubjectId(hydraResponse)
+	})
 	if err != nil {
-		log.Error("Error when calling `PermissionApi.CheckPermission``:\n", err, utils.HttpResponse, r)
 		return http.StatusFailedDependency, "", err
 	}
 
-	if !ketoResponse.Allowed {
-		log.Info("Policy is not created for subject: ", hydraResponse, " Namespace: ", namespace, utils.RelationLog, relation, utils.ObjectLog, object)
+	if !allowed {
+		logging.FromContext(ctx).Info("Policy is not created for subject: ", hydraResponse, " Namespace: ", namespace, utils.RelationLog, relation, utils.ObjectLog, object)
 		return http.StatusForbidden, hydraResponse, err
 	}
 	return http.StatusOK, hydraResponse, err
 }
 
-func (ketoSvc ketoService) ValidatePolicyWithSet (ctx context.Context, namespace string, relation string, object string, subjectSetNamespace string, subjectSetRelation string, subjectSetObject string) (int, string, error) {
+func (ketoSvc ketoService) ValidatePolicyWithSet(ctx context.Context, namespace string, relation string, object string, subjectSetNamespace string, subjectSetRelation string, subjectSetObject string) (int, string, error) {
 	name := "CallKetoToValidatePolicyWithSet"
 	childCtx, span := otel.Tracer(name).Start(ctx, "CallKetoToValidatePolicyWithSet")
 	defer span.End()
+	backend := ketoSvc.ketoRouter.ForNamespace(namespace)
+	childCtx, cancel := context.WithTimeout(childCtx, backend.Timeout)
+	defer cancel()
 
-	if namespace=="" || relation=="" || object=="" || subjectSetNamespace=="" || subjectSetRelation=="" || subjectSetObject=="" {
-		log.Error(utils.InvalidError)
+	if namespace == "" || relation == "" || object == "" || subjectSetNamespace == "" || subjectSetRelation == "" || subjectSetObject == "" {
+		logging.FromContext(ctx).Error(utils.InvalidError)
 		return http.StatusBadRequest, "", errors.New(utils.InvalidError)
 	}
+	if err := ketoSvc.schema.ValidateTuple(ctx, model.RelationTuple{Namespace: namespace, Object: object, Relation: relation,
+		SubjectSet: &model.SubjectSet{Namespace: subjectSetNamespace, Object: subjectSetObject, Relation: subjectSetRelation}}); err != nil {
+		logging.FromContext(ctx).Error(err.Error())
+		return http.StatusBadRequest, "", err
+	}
 
-	ketoResponse, r, err := utils.GetKetoReadClient().PermissionApi.CheckPermission(childCtx).
-		Namespace(namespace).
-		Relation(relation).
-		Object(object).
-		SubjectSetNamespace(subjectSetNamespace).
-		SubjectSetRelation(subjectSetRelation).
-		SubjectSetObject(subjectSetObject).
-		Execute()
+	tuple := model.RelationTuple{Namespace: namespace, Object: object, Relation: relation, SubjectSet: &model.SubjectSet{Namespace: subjectSetNamespace, Object: subjectSetObject, Relation: subjectSetRelation}}
+	allowed, err := ketoSvc.check(childCtx, backend, tuple, func(ctx context.Context) client.PermissionApiCheckPermissionRequest {
+		return backend.Client.PermissionApi.CheckPermission(ctx).
+			Namespace(namespace).
+			Relation(relation).
+			Object(object).
+			SubjectSetNamespace(subjectSetNamespace).
+			SubjectSetRelation(subjectSetRelation).
+			SubjectSetObject(subjectSetObject)
+	})
 	if err != nil {
-		log.Error("Error when calling `PermissionApi.CheckPermission``:\n", err, utils.HttpResponse, r)
 		return http.StatusFailedDependency, "", err
 	}
 
-	if !ketoResponse.Allowed {
-		log.Info("Policy is not created for subjectSetNamespace: ", subjectSetNamespace, " subjectSetRelation: ", subjectSetRelation, " subjectSetObject: ", subjectSetObject, " Namespace: ", namespace, utils.RelationLog, relation, utils.ObjectLog, object)
+	if !allowed {
+		logging.FromContext(ctx).Info("Policy is not created for subjectSetNamespace: ", subjectSetNamespace, " subjectSetRelation: ", subjectSetRelation, " subjectSetObject: ", subjectSetObject, " Namespace: ", namespace, utils.RelationLog, relation, utils.ObjectLog, object)
 		return http.StatusForbidden, "Policy does not exist", err
 	}
 	return http.StatusOK, "Policy exists", err
 }
 
-func (ketoSvc ketoService) ExpandPolicy (ctx context.Context, namespace string, relation string, object string, maxDepth string, hasDepth bool) (int, map[string]interface{}, error) {
+func (ketoSvc ketoService) ExpandPolicy(ctx context.Context, namespace string, relation string, object string, maxDepth string, hasDepth bool) (int, *model.ExpandTree, error) {
 	name := "CallKetoToExpandPolicy"
 	childCtx, span := otel.Tracer(name).Start(ctx, "CallKetoToExpandPolicy")
 	defer span.End()
+	backend := ketoSvc.ketoRouter.ForNamespace(namespace)
+	childCtx, cancel := context.WithTimeout(childCtx, backend.Timeout)
+	defer cancel()
 
-	var (
-		ketoResponse map[string]interface{}
-		depth int64
-		err error
-	)
-	if namespace=="" || relation=="" || object=="" || (hasDepth==true && maxDepth==""){
-		log.Error(utils.InvalidError)
-		return http.StatusBadRequest, ketoResponse, errors.New(utils.InvalidError)
-	}
-	if maxDepth != "" {
-		depth, err = strconv.ParseInt(maxDepth, 10, 64)
-		if err != nil {
-			log.Error("MaxDepth cannot be converted to int: ", depth)
-			return http.StatusBadRequest, ketoResponse, errors.New("Invalid query params")
-		}
+	if namespace == "" || relation == "" || object == "" || (hasDepth == true && maxDepth == "") {
+		logging.FromContext(ctx).Error(utils.InvalidError)
+		return http.StatusBadRequest, nil, errors.New(utils.InvalidError)
+	}
+	if err := ketoSvc.schema.ValidateTuple(ctx, model.RelationTuple{Namespace: namespace, Object: object, Relation: relation}); err != nil {
+		logging.FromContext(ctx).Error(err.Error())
+		return http.StatusBadRequest, nil, err
+	}
+	depth, err := parseLimit(maxDepth)
+	if err != nil {
+		logging.FromContext(ctx).Error("MaxDepth cannot be converted to int: ", maxDepth)
+		return http.StatusBadRequest, nil, err
 	}
 
-	resp, r, err := utils.GetKetoReadClient().PermissionApi.ExpandPermissions(childCtx).
+	metrics.UpstreamRequests.WithLabelValues(utils.KetoUpstream).Inc()
+	metrics.KetoRequests.WithLabelValues(backend.Name).Inc()
+	resp, r, err := backend.Client.PermissionApi.ExpandPermissions(childCtx).
 		Namespace(namespace).
 		Relation(relation).
 		Object(object).
 		MaxDepth(depth).
 		Execute()
+	if (err != nil && r != nil && r.StatusCode == http.StatusNotFound) || (err == nil && resp.Type == "") {
+		logging.FromContext(ctx).Info("Subject set not found with Namespace: ", namespace, utils.RelationLog, relation, utils.ObjectLog, object)
+		return http.StatusNotFound, nil, ErrSubjectSetNotFound
+	}
 	if err != nil {
-		log.Error("Error when calling `PermissionApi.ExpandPermissions``:\n", err, utils.HttpResponse, r, "Response Body: ", resp)
-		return http.StatusFailedDependency, ketoResponse, err
+		logging.FromContext(ctx).Error("Error when calling `PermissionApi.ExpandPermissions``:\n", err, utils.HttpResponse, r)
+		return http.StatusFailedDependency, nil, err
 	}
+	tree := expandTreeFromClient(*resp)
+	return http.StatusOK, &tree, nil
+}
 
-	defer r.Body.Close()
-	encodedBody, err := io.ReadAll(r.Body)
+func expandTreeFromClient(node client.ExpandedPermissionTree) model.ExpandTree {
+	tree := model.ExpandTree{Type: node.Type}
+	if node.Tuple != nil {
+		tuple := relationTupleFromClient(*node.Tuple)
+		tree.Tuple = &tuple
+	}
+	for _, child := range node.Children {
+		tree.Children = append(tree.Children, expandTreeFromClient(child))
+	}
+	return tree
+}
+
+func relationTupleFromClient(relationship client.Relationship) model.RelationTuple {
+	tuple := model.RelationTuple{
+		Namespace: relationship.Namespace,
+		Object:    relationship.Object,
+		Relation:  relationship.Relation,
+		SubjectId: relationship.GetSubjectId(),
+	}
+	if relationship.SubjectSet != nil {
+		tuple.SubjectSet = &model.SubjectSet{
+			Namespace: relationship.SubjectSet.Namespace,
+			Object:    relationship.SubjectSet.Object,
+			Relation:  relationship.SubjectSet.Relation,
+		}
+	}
+	return tuple
+}
+
+func (ketoSvc ketoService) ListRelationTuples(ctx context.Context, query model.RelationTuple, pageToken string, pageSize string) (int, model.RelationTuples, error) {
+	name := "CallKetoToListRelationTuples"
+	childCtx, span := otel.Tracer(name).Start(ctx, "CallKetoToListRelationTuples")
+	defer span.End()
+	backend := ketoSvc.ketoRouter.ForNamespace(query.Namespace)
+	childCtx, cancel := context.WithTimeout(childCtx, backend.Timeout)
+	defer cancel()
+
+	size, err := parseLimit(pageSize)
 	if err != nil {
-		log.Error("Decoding error: ", err.Error())
-		return http.StatusFailedDependency, ketoResponse, err
+		logging.FromContext(ctx).Error("PageSize cannot be converted to int: ", pageSize)
+		return http.StatusBadRequest, model.RelationTuples{}, err
+	}
+	if err := ketoSvc.schema.ValidateTuple(ctx, query); err != nil {
+		logging.FromContext(ctx).Error(err.Error())
+		return http.StatusBadRequest, model.RelationTuples{}, err
 	}
 
-	json.Unmarshal([]byte(string(encodedBody)), &ketoResponse)
-	status, _ := ketoResponse["code"].(float64)
-	if status == http.StatusNotFound {
-		log.Info("Subject set not found with Namespace: ", namespace, utils.RelationLog, relation, utils.ObjectLog, object)
-		return http.StatusNotFound, ketoResponse, err
+	request := backend.Client.RelationshipApi.GetRelationships(childCtx)
+	if query.Namespace != "" {
+		request = request.Namespace(query.Namespace)
+	}
+	if query.Object != "" {
+		request = request.Object(query.Object)
+	}
+	if query.Relation != "" {
+		request = request.Relation(query.Relation)
+	}
+	if query.SubjectId != "" {
+		request = request.SubjectId(query.SubjectId)
+	}
+	if query.SubjectSet != nil {
+		request = request.SubjectSetNamespace(query.SubjectSet.Namespace).
+			SubjectSetObject(query.SubjectSet.Object).
+			SubjectSetRelation(query.SubjectSet.Relation)
+	}
+	if pageToken != "" {
+		request = request.PageToken(pageToken)
+	}
+	if size > 0 {
+		request = request.PageSize(size)
+	}
+
+	metrics.UpstreamRequests.WithLabelValues(utils.KetoUpstream).Inc()
+	metrics.KetoRequests.WithLabelValues(backend.Name).Inc()
+	resp, r, err := request.Execute()
+	if err != nil {
+		logging.FromContext(ctx).Error("Error when calling `RelationshipApi.GetRelationships``:\n", err, utils.HttpResponse, r)
+		return http.StatusFailedDependency, model.RelationTuples{}, err
 	}
-	return http.StatusOK, ketoResponse, err
-}
\ No newline at end of file
+
+	tuples := model.RelationTuples{
+		RelationTuples: make([]model.RelationTuple, 0, len(resp.RelationTuples)),
+		NextPageToken:  resp.GetNextPageToken(),
+	}
+	for _, relationship := range resp.RelationTuples {
+		tuples.RelationTuples = append(tuples.RelationTuples, relationTupleFromClient(relationship))
+	}
+	return http.StatusOK, tuples, nil
+}
+
+func (ketoSvc ketoService) PatchRelationTuples(ctx context.Context, deltas []model.RelationTupleDelta) (int, model.WriteResponse, error) {
+	name := "CallKetoToPatchRelationTuples"
+	childCtx, span := otel.Tracer(name).Start(ctx, "CallKetoToPatchRelationTuples")
+	defer span.End()
+
+	backend, err := writeBackend(ctx, ketoSvc.ketoRouter, ketoSvc.schema, deltas)
+	if err != nil {
+		logging.FromContext(ctx).Error(err.Error())
+		return http.StatusBadRequest, model.WriteResponse{}, err
+	}
+	if backend.WriteClient == nil {
+		logging.FromContext(ctx).Error("Keto write API is not configured for backend: ", backend.Name)
+		return http.StatusFailedDependency, model.WriteResponse{}, errors.New("Keto write API is not configured")
+	}
+	childCtx, cancel := context.WithTimeout(childCtx, backend.Timeout)
+	defer cancel()
+
+	patches := make([]client.RelationshipPatch, 0, len(deltas))
+	for _, delta := range deltas {
+		action := delta.Action
+		tuple := delta.RelationTuple
+		relationship := client.NewRelationship(tuple.Namespace, tuple.Object, tuple.Relation)
+		if tuple.SubjectId != "" {
+			relationship.SetSubjectId(tuple.SubjectId)
+		} else {
+			relationship.SetSubjectSet(*client.NewSubjectSet(tuple.SubjectSet.Namespace, tuple.SubjectSet.Object, tuple.SubjectSet.Relation))
+		}
+		patches = append(patches, client.RelationshipPatch{Action: &action, RelationTuple: relationship})
+	}
+
+	metrics.UpstreamRequests.WithLabelValues(utils.KetoUpstream).Inc()
+	metrics.KetoRequests.WithLabelValues(backend.Name).Inc()
+	r, err := backend.WriteClient.RelationshipApi.PatchRelationships(childCtx).RelationshipPatch(patches).Execute()
+	if err != nil {
+		logging.FromContext(ctx).Error("Error when calling `RelationshipApi.PatchRelationships``:\n", err, utils.HttpResponse, r)
+		if r != nil && r.StatusCode == http.StatusBadRequest {
+			return http.StatusBadRequest, model.WriteResponse{}, err
+		}
+		return http.StatusFailedDependency, model.WriteResponse{}, err
+	}
+	ketoSvc.checks.flush()
+	// Keto's REST write API does not return a snaptoken
+	return http.StatusOK, model.WriteResponse{}, nil
+}
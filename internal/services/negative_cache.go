@@ -0,0 +1,89 @@
+package services
+
+import (
+	"time"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/patrickmn/go-cache"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultNegativeTTL        = 30
+	defaultNegativeMaxEntries = 10000
+	defaultInvalidRateWindow  = 60
+)
+
+// negativeCache remembers tokens that Hydra rejected for a short while, so
+// that a client retrying with a dead token does not reach Hydra every time.
+// Entries are keyed like the token cache, by issuer, so a token rejected by
+// one issuer is still introspected by another. It also counts rejected
+// tokens per client over a fixed window.
+type negativeCache struct {
+	config  *configs.Store
+	tokens  *cache.Cache
+	clients *cache.Cache
+}
+
+func newNegativeCache(config *configs.Store) *negativeCache {
+	return &negativeCache{
+		config:  config,
+		tokens:  cache.New(defaultNegativeTTL*time.Second, time.Minute),
+		clients: cache.New(defaultInvalidRateWindow*time.Second, time.Minute),
+	}
+}
+
+func (n *negativeCache) contains(tokenKey string) bool {
+	_, found := n.tokens.Get(tokenKey)
+	return found
+}
+
+// add stores a rejected token key. Once the cache is full new entries are
+// dropped rather than letting a flood of random tokens grow it unbounded.
+func (n *negativeCache) add(tokenKey string) {
+	config := n.config.Get()
+	ttl := intOrDefault(config.GetInt("cache.negative.ttl"), defaultNegativeTTL)
+	maxEntries := intOrDefault(config.GetInt("cache.negative.max_entries"), defaultNegativeMaxEntries)
+
+	if n.tokens.ItemCount() >= maxEntries {
+		n.tokens.DeleteExpired()
+		if n.tokens.ItemCount() >= maxEntries {
+			log.Warn("Negative token cache is full, not caching rejected token")
+			return
+		}
+	}
+	n.tokens.Set(tokenKey, struct{}{}, time.Duration(ttl)*time.Second)
+}
+
+// recordInvalid increments the rejected token counter of a client and
+// returns the count within the current window.
+func (n *negativeCache) recordInvalid(client string) int {
+	if client == "" {
+		return 0
+	}
+	window := time.Duration(intOrDefault(n.config.Get().GetInt("cache.negative.rate_window"), defaultInvalidRateWindow)) * time.Second
+	if n.clients.Add(client, 1, window) == nil {
+		return 1
+	}
+	count, err := n.clients.IncrementInt(client, 1)
+	if err != nil {
+		n.clients.Set(client, 1, window)
+		return 1
+	}
+	return count
+}
+
+func (n *negativeCache) invalidCount(client string) int {
+	count, found := n.clients.Get(client)
+	if !found {
+		return 0
+	}
+	return count.(int)
+}
+
+func intOrDefault(value int, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/logging"
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/livspaceeng/ozone/internal/utils"
+	rts "github.com/ory/keto/proto/ory/keto/relation_tuples/v1alpha2"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	SchemaSourceNone   = "none"
+	SchemaSourceConfig = "config"
+	SchemaSourceKeto   = "keto"
+
+	defaultSchemaRefresh = 60
+)
+
+// NamespaceSchema lists the relations of a namespace and the pattern its
+// objects follow. Either may be left empty to allow any.
+type NamespaceSchema struct {
+	Name          string   `mapstructure:"name"`
+	Relations     []string `mapstructure:"relations"`
+	ObjectPattern string   `mapstructure:"object_pattern"`
+}
+
+type namespaceRules struct {
+	relations     map[string]bool
+	objectPattern *regexp.Regexp
+}
+
+type schemaPolicy struct {
+	source     string
+	refresh    time.Duration
+	namespaces map[string]*namespaceRules
+}
+
+// Schema rejects namespaces, relations and objects ozone does not know
+// before they reach Keto. Namespaces come from schema.namespaces, or from
+// Keto's namespaces API with schema.source keto.
+type Schema struct {
+	policy     atomic.Value
+	ketoRouter *utils.KetoRouter
+	protocol   string
+	now        func() time.Time
+
+	ketoList       atomic.Value
+	ketoGroup      singleflight.Group
+	ketoRefreshing int32
+}
+
+// ketoNamespaceList is the last successful listing of Keto's namespaces.
+type ketoNamespaceList struct {
+	names    map[string]bool
+	loadedAt time.Time
+}
+
+func init() {
+	configs.RegisterValidator(func(config *viper.Viper) error {
+		_, err := loadSchemaPolicy(config)
+		return err
+	})
+}
+
+func loadSchemaPolicy(config *viper.Viper) (*schemaPolicy, error) {
+	var namespaces []NamespaceSchema
+	if err := config.UnmarshalKey("schema.namespaces", &namespaces); err != nil {
+		return nil, err
+	}
+	policy := &schemaPolicy{
+		source:     config.GetString("schema.source"),
+		refresh:    time.Duration(config.GetInt("schema.refresh")) * time.Second,
+		namespaces: make(map[string]*namespaceRules, len(namespaces)),
+	}
+	if policy.source == "" {
+		policy.source = SchemaSourceNone
+	}
+	if policy.source != SchemaSourceNone && policy.source != SchemaSourceConfig && policy.source != SchemaSourceKeto {
+		return nil, fmt.Errorf("unknown schema source %q", policy.source)
+	}
+	if policy.refresh <= 0 {
+		policy.refresh = defaultSchemaRefresh * time.Second
+	}
+	for _, namespace := range namespaces {
+		if namespace.Name == "" {
+			return nil, errors.New("schema namespace needs a name")
+		}
+		if _, found := policy.namespaces[namespace.Name]; found {
+			return nil, fmt.Errorf("schema namespace %s is listed twice", namespace.Name)
+		}
+		rules := &namespaceRules{}
+		if len(namespace.Relations) > 0 {
+			rules.relations = make(map[string]bool, len(namespace.Relations))
+			for _, relation := range namespace.Relations {
+				rules.relations[relation] = true
+			}
+		}
+		if namespace.ObjectPattern != "" {
+			pattern, err := regexp.Compile(namespace.ObjectPattern)
+			if err != nil {
+				return nil, fmt.Errorf("schema namespace %s has an invalid object_pattern: %w", namespace.Name, err)
+			}
+			rules.objectPattern = pattern
+		}
+		policy.namespaces[namespace.Name] = rules
+	}
+	return policy, nil
+}
+
+// NewSchema returns a Schema reading the time from now, which decides when
+// the namespaces of Keto are listed again.
+func NewSchema(config *configs.Store, ketoRouter *utils.KetoRouter, now func() time.Time) (*Schema, error) {
+	policy, err := loadSchemaPolicy(config.Get())
+	if err != nil {
+		return nil, err
+	}
+	schema := &Schema{ketoRouter: ketoRouter, protocol: utils.KetoProtocol(config.Get()), now: now}
+	schema.policy.Store(policy)
+	config.OnReload(func(newConfig *viper.Viper) {
+		if policy, err := loadSchemaPolicy(newConfig); err == nil {
+			schema.policy.Store(policy)
+		}
+	})
+	return schema, nil
+}
+
+// ValidateTuple checks the non-empty fields of tuple, and of its subject
+// set, against the schema. A nil schema allows everything.
+func (s *Schema) ValidateTuple(ctx context.Context, tuple model.RelationTuple) error {
+	if s == nil {
+		return nil
+	}
+	if err := s.validate(ctx, tuple.Namespace, tuple.Relation, tuple.Object); err != nil {
+		return err
+	}
+	if tuple.SubjectSet != nil {
+		return s.validate(ctx, tuple.SubjectSet.Namespace, tuple.SubjectSet.Relation, tuple.SubjectSet.Object)
+	}
+	return nil
+}
+
+func (s *Schema) validate(ctx context.Context, namespace string, relation string, object string) error {
+	policy := s.policy.Load().(*schemaPolicy)
+	if policy.source == SchemaSourceNone || namespace == "" {
+		return nil
+	}
+
+	known := make(map[string]bool, len(policy.namespaces))
+	for name := range policy.namespaces {
+		known[name] = true
+	}
+	if policy.source == SchemaSourceKeto {
+		known = s.ketoNamespaces(ctx, policy.refresh)
+		if known == nil {
+			// Keto never answered, allow rather than refuse every request.
+			return nil
+		}
+	}
+	if !known[namespace] {
+		return unknownError("namespace", namespace, "", known)
+	}
+
+	rules := policy.namespaces[namespace]
+	if rules == nil {
+		return nil
+	}
+	if relation != "" && rules.relations != nil && !rules.relations[relation] {
+		return unknownError("relation", relation, " in namespace "+namespace, rules.relations)
+	}
+	if object != "" && rules.objectPattern != nil && !rules.objectPattern.MatchString(object) {
+		return fmt.Errorf("Object %q does not match %s, the object pattern of namespace %s", object, rules.objectPattern, namespace)
+	}
+	return nil
+}
+
+// ketoNamespaces returns the namespaces of every Keto cluster. Only the
+// first call waits for a listing; once one succeeded, a list older than
+// refresh is listed again in the background while the old one is served.
+// It returns nil as long as no listing succeeded.
+func (s *Schema) ketoNamespaces(ctx context.Context, refresh time.Duration) map[string]bool {
+	list, _ := s.ketoList.Load().(*ketoNamespaceList)
+	if list == nil {
+		names, _, _ := s.ketoGroup.Do(SchemaSourceKeto, func() (interface{}, error) {
+			return s.listKetoNamespaces(ctx), nil
+		})
+		return names.(map[string]bool)
+	}
+	if s.now().Sub(list.loadedAt) >= refresh && atomic.CompareAndSwapInt32(&s.ketoRefreshing, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&s.ketoRefreshing, 0)
+			s.ketoGroup.Do(SchemaSourceKeto, func() (interface{}, error) {
+				return s.listKetoNamespaces(ctx), nil
+			})
+		}()
+	}
+	return list.names
+}
+
+// listKetoNamespaces lists the namespaces of every Keto cluster and keeps
+// them as the new list. A failed listing keeps the previous list, which is
+// listed again on the next call.
+func (s *Schema) listKetoNamespaces(ctx context.Context) map[string]bool {
+	names := make(map[string]bool)
+	for _, backend := range s.ketoRouter.Backends() {
+		listCtx, cancel := context.WithTimeout(context.Background(), backend.Timeout)
+		backendNames, err := s.listNamespaces(listCtx, backend)
+		cancel()
+		if err != nil {
+			logging.FromContext(ctx).Warn("Failed to list Keto namespaces of backend ", backend.Name, ": ", err)
+			if list, _ := s.ketoList.Load().(*ketoNamespaceList); list != nil {
+				return list.names
+			}
+			return nil
+		}
+		for _, name := range backendNames {
+			names[name] = true
+		}
+	}
+	s.ketoList.Store(&ketoNamespaceList{names: names, loadedAt: s.now()})
+	return names
+}
+
+func (s *Schema) listNamespaces(ctx context.Context, backend *utils.KetoBackend) ([]string, error) {
+	var names []string
+	if s.protocol == utils.GrpcProtocol {
+		resp, err := rts.NewNamespacesServiceClient(backend.Conn).ListNamespaces(ctx, &rts.ListNamespacesRequest{})
+		if err != nil {
+			return nil, err
+		}
+		for _, namespace := range resp.Namespaces {
+			names = append(names, namespace.Name)
+		}
+		return names, nil
+	}
+	resp, _, err := backend.Client.RelationshipApi.ListRelationshipNamespaces(ctx).Execute()
+	if err != nil {
+		return nil, err
+	}
+	for _, namespace := range resp.Namespaces {
+		names = append(names, namespace.GetName())
+	}
+	return names, nil
+}
+
+// unknownError names the closest known value when value looks like a typo
+// of it, and lists the known values otherwise.
+func unknownError(kind string, value string, scope string, known map[string]bool) error {
+	names := make([]string, 0, len(known))
+	for name := range known {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	closest, distance := "", len(value)/2+1
+	for _, name := range names {
+		if d := editDistance(value, name); d < distance {
+			closest, distance = name, d
+		}
+	}
+	if closest != "" {
+		return fmt.Errorf("Unknown %s %q%s, did you mean %q?", kind, value, scope, closest)
+	}
+	return fmt.Errorf("Unknown %s %q%s, known are: %s", kind, value, scope, strings.Join(names, ", "))
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j] + 1
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/spf13/viper"
+)
+
+const (
+	QueryOperation  = "query"
+	ExpandOperation = "expand"
+	WriteOperation  = "write"
+)
+
+type tenantKey struct{}
+
+// TenantGrant lets a tenant run operations on a namespace.
+type TenantGrant struct {
+	Namespace  string   `mapstructure:"namespace"`
+	Operations []string `mapstructure:"operations"`
+}
+
+// TenantQuota is a token bucket shared by every request of a tenant. A zero
+// rate is unlimited.
+type TenantQuota struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// Tenant is the set of OAuth clients, and of issuers, sharing grants on
+// namespaces and a quota. Clients match before issuers.
+type Tenant struct {
+	Name      string        `mapstructure:"name"`
+	ClientIds []string      `mapstructure:"client_ids"`
+	Issuers   []string      `mapstructure:"issuers"`
+	Grants    []TenantGrant `mapstructure:"grants"`
+	Quota     TenantQuota   `mapstructure:"quota"`
+
+	grants map[string]map[string]bool
+}
+
+type tenancyPolicy struct {
+	enabled   bool
+	clientIds map[string]*Tenant
+	issuers   map[string]*Tenant
+}
+
+// Tenancy maps the client or the issuer of a token to the tenant whose
+// namespaces it may query, expand or write. It follows config reloads.
+type Tenancy struct {
+	policy atomic.Value
+}
+
+func init() {
+	configs.RegisterValidator(func(config *viper.Viper) error {
+		_, err := loadTenancyPolicy(config)
+		return err
+	})
+}
+
+// LoadTenants reads and validates tenancy.tenants from config.
+func LoadTenants(config *viper.Viper) ([]*Tenant, error) {
+	var tenants []*Tenant
+	if err := config.UnmarshalKey("tenancy.tenants", &tenants); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, tenant := range tenants {
+		if tenant.Name == "" {
+			return nil, errors.New("tenant needs a name")
+		}
+		if names[tenant.Name] {
+			return nil, fmt.Errorf("tenant %s is defined twice", tenant.Name)
+		}
+		names[tenant.Name] = true
+		if len(tenant.ClientIds) == 0 && len(tenant.Issuers) == 0 {
+			return nil, fmt.Errorf("tenant %s needs client_ids or issuers", tenant.Name)
+		}
+		if tenant.Quota.Rate < 0 || (tenant.Quota.Rate > 0 && tenant.Quota.Burst < 1) {
+			return nil, fmt.Errorf("tenant %s needs a positive quota rate and burst", tenant.Name)
+		}
+		tenant.grants = make(map[string]map[string]bool)
+		for _, grant := range tenant.Grants {
+			if grant.Namespace == "" {
+				return nil, fmt.Errorf("tenant %s has a grant without namespace", tenant.Name)
+			}
+			operations := tenant.grants[grant.Namespace]
+			if operations == nil {
+				operations = make(map[string]bool)
+				tenant.grants[grant.Namespace] = operations
+			}
+			for _, operation := range grant.Operations {
+				if operation != QueryOperation && operation != ExpandOperation && operation != WriteOperation {
+					return nil, fmt.Errorf("tenant %s has unknown operation %q", tenant.Name, operation)
+				}
+				operations[operation] = true
+			}
+		}
+	}
+	return tenants, nil
+}
+
+func loadTenancyPolicy(config *viper.Viper) (*tenancyPolicy, error) {
+	tenants, err := LoadTenants(config)
+	if err != nil {
+		return nil, err
+	}
+	policy := &tenancyPolicy{
+		enabled:   config.GetBool("tenancy.enabled"),
+		clientIds: make(map[string]*Tenant),
+		issuers:   make(map[string]*Tenant),
+	}
+	for _, tenant := range tenants {
+		for _, clientId := range tenant.ClientIds {
+			if other, found := policy.clientIds[clientId]; found {
+				return nil, fmt.Errorf("client %s belongs to both tenants %s and %s", clientId, other.Name, tenant.Name)
+			}
+			policy.clientIds[clientId] = tenant
+		}
+		for _, issuer := range tenant.Issuers {
+			if other, found := policy.issuers[issuer]; found {
+				return nil, fmt.Errorf("issuer %s belongs to both tenants %s and %s", issuer, other.Name, tenant.Name)
+			}
+			policy.issuers[issuer] = tenant
+		}
+	}
+	return policy, nil
+}
+
+func NewTenancy(config *configs.Store) (*Tenancy, error) {
+	policy, err := loadTenancyPolicy(config.Get())
+	if err != nil {
+		return nil, err
+	}
+	tenancy := &Tenancy{}
+	tenancy.policy.Store(policy)
+	config.OnReload(func(newConfig *viper.Viper) {
+		if policy, err := loadTenancyPolicy(newConfig); err == nil {
+			tenancy.policy.Store(policy)
+		}
+	})
+	return tenancy, nil
+}
+
+// Enabled reports whether tenancy.enabled is set.
+func (t *Tenancy) Enabled() bool {
+	return t.policy.Load().(*tenancyPolicy).enabled
+}
+
+// Resolve returns the tenant of clientId, or else of issuer.
+func (t *Tenancy) Resolve(issuer string, clientId string) (*Tenant, bool) {
+	policy := t.policy.Load().(*tenancyPolicy)
+	if tenant, found := policy.clientIds[clientId]; found && clientId != "" {
+		return tenant, true
+	}
+	tenant, found := policy.issuers[issuer]
+	return tenant, found
+}
+
+// Allows reports whether the tenant may run operation on namespace.
+func (t *Tenant) Allows(operation string, namespace string) bool {
+	return t.grants[namespace][operation]
+}
+
+// WithTenant returns a copy of ctx carrying the tenant of the request.
+func WithTenant(ctx context.Context, tenant *Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant stored by WithTenant, if any.
+func TenantFromContext(ctx context.Context) (*Tenant, bool) {
+	tenant, found := ctx.Value(tenantKey{}).(*Tenant)
+	return tenant, found
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultMinCacheTTL = 1
+
+	CacheHit         = "hit"
+	CacheMiss        = "miss"
+	CacheNegativeHit = "negative_hit"
+	CacheUncached    = "uncached"
+	CacheRefresh     = "refresh"
+)
+
+// CachePolicy bounds how long the introspection responses of an issuer are
+// cached, configured under issuer.<name>.cache.
+type CachePolicy struct {
+	// Failsafe is taken off the token expiry, failsafe_interval by default
+	Failsafe time.Duration
+	// MinTTL is the shortest TTL worth caching, responses expiring sooner
+	// are not cached
+	MinTTL time.Duration
+	// MaxTTL caps the TTL, zero leaves it uncapped
+	MaxTTL time.Duration
+	// RefreshAhead is the fraction of the TTL before expiry from which a hit
+	// refreshes the response in the background, zero disables it
+	RefreshAhead float64
+	// Jitter spreads the refresh point by up to this fraction of the window
+	Jitter float64
+}
+
+func loadCachePolicy(config *viper.Viper, name string) CachePolicy {
+	prefix := "issuer." + name + ".cache."
+	failsafe := config.GetInt("failsafe_interval")
+	if config.IsSet(prefix + "failsafe_interval") {
+		failsafe = config.GetInt(prefix + "failsafe_interval")
+	}
+	return CachePolicy{
+		Failsafe:     time.Duration(failsafe) * time.Second,
+		MinTTL:       time.Duration(intOrDefault(config.GetInt(prefix+"min_ttl"), defaultMinCacheTTL)) * time.Second,
+		MaxTTL:       time.Duration(config.GetInt(prefix+"max_ttl")) * time.Second,
+		RefreshAhead: config.GetFloat64(prefix + "refresh_ahead"),
+		Jitter:       config.GetFloat64(prefix + "jitter"),
+	}
+}
+
+func validateCachePolicy(config *viper.Viper, name string) error {
+	prefix := "issuer." + name + ".cache."
+	if config.GetInt(prefix+"failsafe_interval") < 0 || config.GetInt(prefix+"min_ttl") < 0 || config.GetInt(prefix+"max_ttl") < 0 {
+		return fmt.Errorf("issuer %s has a negative cache duration", name)
+	}
+	if max := config.GetInt(prefix + "max_ttl"); max > 0 && max < config.GetInt(prefix+"min_ttl") {
+		return fmt.Errorf("issuer %s has a cache max_ttl below its min_ttl", name)
+	}
+	if ahead := config.GetFloat64(prefix + "refresh_ahead"); ahead < 0 || ahead >= 1 {
+		return fmt.Errorf("issuer %s has a cache refresh_ahead outside [0, 1)", name)
+	}
+	if jitter := config.GetFloat64(prefix + "jitter"); jitter < 0 || jitter > 1 {
+		return fmt.Errorf("issuer %s has a cache jitter outside [0, 1]", name)
+	}
+	return nil
+}
+
+// tokenTTL is how long a token expiring at expiry can be cached before the
+// failsafe interval, zero for a token without expiry.
+func (p CachePolicy) tokenTTL(expiry int, now time.Time) time.Duration {
+	if expiry <= 0 {
+		return 0
+	}
+	return time.Unix(int64(expiry), 0).Sub(now) - p.Failsafe
+}
+
+// clamp caps a TTL and tells whether it is long enough to cache at all. A
+// zero or negative TTL is never cached, go-cache would keep it forever.
+func (p CachePolicy) clamp(ttl time.Duration) (time.Duration, bool) {
+	if p.MaxTTL > 0 && ttl > p.MaxTTL {
+		ttl = p.MaxTTL
+	}
+	if ttl <= 0 || ttl < p.MinTTL {
+		return 0, false
+	}
+	return ttl, true
+}
+
+// refreshAt returns when a response cached at now for ttl should be
+// refreshed, zero without refresh ahead.
+func (p CachePolicy) refreshAt(now time.Time, ttl time.Duration) time.Time {
+	if p.RefreshAhead <= 0 {
+		return time.Time{}
+	}
+	window := float64(ttl) * p.RefreshAhead * (1 + p.Jitter*(2*rand.Float64()-1))
+	if window > float64(ttl) {
+		window = float64(ttl)
+	}
+	return now.Add(ttl - time.Duration(window))
+}
+
+// cachedToken is a cached introspection response. Only the first hit past
+// refreshAt starts a refresh, and no hit is served past expiresAt even if
+// the cache still holds the entry.
+type cachedToken struct {
+	response   model.HydraResponse
+	refreshAt  time.Time
+	expiresAt  time.Time
+	refreshing int32
+}
+
+func (t *cachedToken) live(now time.Time) bool {
+	return now.Before(t.expiresAt)
+}
+
+func (t *cachedToken) startRefresh(now time.Time) bool {
+	if t.refreshAt.IsZero() || now.Before(t.refreshAt) {
+		return false
+	}
+	return atomic.CompareAndSwapInt32(&t.refreshing, 0, 1)
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/model"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultMarkerNamespace = "ozone"
+	// MarkerRelation relates the marker object of an owner to the tuples it
+	// manages, written as their string form.
+	MarkerRelation = "manages"
+
+	defaultSyncPageSize = 100
+	syncBatchSize       = 100
+)
+
+// ManifestTuple is a relation tuple of a manifest, with either a subject id
+// or a subject set.
+type ManifestTuple struct {
+	Namespace  string            `yaml:"namespace"`
+	Object     string            `yaml:"object"`
+	Relation   string            `yaml:"relation"`
+	SubjectId  string            `yaml:"subject_id"`
+	SubjectSet *model.SubjectSet `yaml:"subject_set"`
+}
+
+type manifest struct {
+	Tuples []ManifestTuple `yaml:"tuples"`
+}
+
+// SyncPlan is what a sync changes in Keto to match the manifests.
+type SyncPlan struct {
+	// Create are tuples of the manifests missing in Keto
+	Create []model.RelationTuple
+	// Adopt are tuples of the manifests already in Keto but not yet managed
+	Adopt []model.RelationTuple
+	// Prune are managed tuples no longer in the manifests
+	Prune []model.RelationTuple
+}
+
+// Drift tells whether Keto differs from the manifests. Adopting a tuple does
+// not change any decision, so it is not drift.
+func (p SyncPlan) Drift() bool {
+	return len(p.Create) > 0 || len(p.Prune) > 0
+}
+
+// TupleSync reconciles Keto with tuple manifests. The tuples it writes are
+// recorded by marker tuples markerNamespace:sync;<owner>#manages@<tuple>, so
+// that only those are ever pruned.
+type TupleSync struct {
+	ketoService     KetoService
+	markerNamespace string
+	owner           string
+	pageSize        string
+}
+
+func NewTupleSync(config *configs.Store, ketoSvc KetoService, owner string) *TupleSync {
+	markerNamespace := config.Get().GetString("sync.marker_namespace")
+	if markerNamespace == "" {
+		markerNamespace = DefaultMarkerNamespace
+	}
+	return &TupleSync{
+		ketoService:     ketoSvc,
+		markerNamespace: markerNamespace,
+		owner:           owner,
+		pageSize:        strconv.Itoa(intOrDefault(config.Get().GetInt("sync.page_size"), defaultSyncPageSize)),
+	}
+}
+
+// TupleString writes a tuple as namespace:object#relation@subject.
+func TupleString(tuple model.RelationTuple) string {
+	subject := tuple.SubjectId
+	if tuple.SubjectSet != nil {
+		subject = tuple.SubjectSet.Namespace + ":" + tuple.SubjectSet.Object + "#" + tuple.SubjectSet.Relation
+	}
+	return tuple.Namespace + ":" + tuple.Object + "#" + tuple.Relation + "@" + subject
+}
+
+// ParseTuple parses the string form of TupleString. A subject with both a
+// colon and a hash is a subject set.
+func ParseTuple(tuple string) (model.RelationTuple, error) {
+	relation, subject, found := strings.Cut(tuple, "@")
+	if !found || subject == "" {
+		return model.RelationTuple{}, fmt.Errorf("tuple %q is not namespace:object#relation@subject", tuple)
+	}
+	set, err := ParsePermission(relation)
+	if err != nil {
+		return model.RelationTuple{}, fmt.Errorf("tuple %q is not namespace:object#relation@subject", tuple)
+	}
+	parsed := model.RelationTuple{Namespace: set.Namespace, Object: set.Object, Relation: set.Relation}
+	if subjectSet, err := ParsePermission(subject); err == nil {
+		parsed.SubjectSet = &subjectSet
+	} else {
+		parsed.SubjectId = subject
+	}
+	return parsed, nil
+}
+
+// LoadManifests reads the tuples of every .yaml and .yml file of dir.
+func LoadManifests(dir string) ([]model.RelationTuple, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var tuples []model.RelationTuple
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if entry.IsDir() || (filepath.Ext(entry.Name()) != ".yaml" && filepath.Ext(entry.Name()) != ".yml") {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var file manifest
+		if err := yaml.Unmarshal(content, &file); err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", entry.Name(), err)
+		}
+		for i, tuple := range file.Tuples {
+			if tuple.Namespace == "" || tuple.Object == "" || tuple.Relation == "" || (tuple.SubjectId == "") == (tuple.SubjectSet == nil) {
+				return nil, fmt.Errorf("manifest %s: tuple %d needs a namespace, an object, a relation and either a subject_id or a subject_set", entry.Name(), i+1)
+			}
+			relationTuple := model.RelationTuple(tuple)
+			if key := TupleString(relationTuple); !seen[key] {
+				seen[key] = true
+				tuples = append(tuples, relationTuple)
+			}
+		}
+	}
+	return tuples, nil
+}
+
+func (s *TupleSync) marker(tuple model.RelationTuple) model.RelationTuple {
+	return model.RelationTuple{Namespace: s.markerNamespace, Object: "sync;" + s.owner, Relation: MarkerRelation, SubjectId: TupleString(tuple)}
+}
+
+// list returns every tuple matching query, across pages, keyed by its
+// string form.
+func (s *TupleSync) list(ctx context.Context, query model.RelationTuple) (map[string]model.RelationTuple, error) {
+	tuples := map[string]model.RelationTuple{}
+	pageToken := ""
+	for {
+		_, page, err := s.ketoService.ListRelationTuples(ctx, query, pageToken, s.pageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, tuple := range page.RelationTuples {
+			tuples[TupleString(tuple)] = tuple
+		}
+		if page.NextPageToken == "" {
+			return tuples, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// Plan diffs the desired tuples against Keto. Only the namespace, object and
+// relation of desired and managed tuples are listed, other tuples of Keto are
+// never looked at.
+func (s *TupleSync) Plan(ctx context.Context, desired []model.RelationTuple) (SyncPlan, error) {
+	markers, err := s.list(ctx, model.RelationTuple{Namespace: s.markerNamespace, Object: "sync;" + s.owner, Relation: MarkerRelation})
+	if err != nil {
+		return SyncPlan{}, err
+	}
+	managed := map[string]model.RelationTuple{}
+	for _, marker := range markers {
+		tuple, err := ParseTuple(marker.SubjectId)
+		if err != nil {
+			return SyncPlan{}, err
+		}
+		managed[TupleString(tuple)] = tuple
+	}
+
+	wanted := map[string]bool{}
+	queries := map[string]model.RelationTuple{}
+	for _, tuple := range desired {
+		wanted[TupleString(tuple)] = true
+		queries[tuple.Namespace+":"+tuple.Object+"#"+tuple.Relation] = model.RelationTuple{Namespace: tuple.Namespace, Object: tuple.Object, Relation: tuple.Relation}
+	}
+	for _, tuple := range managed {
+		queries[tuple.Namespace+":"+tuple.Object+"#"+tuple.Relation] = model.RelationTuple{Namespace: tuple.Namespace, Object: tuple.Object, Relation: tuple.Relation}
+	}
+	existing := map[string]model.RelationTuple{}
+	for _, query := range queries {
+		tuples, err := s.list(ctx, query)
+		if err != nil {
+			return SyncPlan{}, err
+		}
+		for key, tuple := range tuples {
+			existing[key] = tuple
+		}
+	}
+
+	var plan SyncPlan
+	for _, tuple := range desired {
+		key := TupleString(tuple)
+		if _, found := existing[key]; !found {
+			plan.Create = append(plan.Create, tuple)
+		} else if _, found := managed[key]; !found {
+			plan.Adopt = append(plan.Adopt, tuple)
+		}
+	}
+	for key, tuple := range managed {
+		if !wanted[key] {
+			plan.Prune = append(plan.Prune, tuple)
+		}
+	}
+	sort.Slice(plan.Prune, func(i, j int) bool { return TupleString(plan.Prune[i]) < TupleString(plan.Prune[j]) })
+	return plan, nil
+}
+
+// Apply writes a plan to Keto, pruning only when prune is set. Tuples are
+// written before their markers and deleted before them, so that a failed
+// sync is completed by the next one.
+func (s *TupleSync) Apply(ctx context.Context, plan SyncPlan, prune bool) error {
+	var tuples, markers []model.RelationTupleDelta
+	for _, tuple := range plan.Create {
+		tuples = append(tuples, model.RelationTupleDelta{Action: InsertAction, RelationTuple: tuple})
+	}
+	for _, tuple := range append(append([]model.RelationTuple{}, plan.Create...), plan.Adopt...) {
+		markers = append(markers, model.RelationTupleDelta{Action: InsertAction, RelationTuple: s.marker(tuple)})
+	}
+	if prune {
+		for _, tuple := range plan.Prune {
+			tuples = append(tuples, model.RelationTupleDelta{Action: DeleteAction, RelationTuple: tuple})
+			markers = append(markers, model.RelationTupleDelta{Action: DeleteAction, RelationTuple: s.marker(tuple)})
+		}
+	}
+	if err := s.write(ctx, tuples); err != nil {
+		return err
+	}
+	return s.write(ctx, markers)
+}
+
+// write patches deltas in batches of one namespace, as a patch cannot span
+// Keto clusters.
+func (s *TupleSync) write(ctx context.Context, deltas []model.RelationTupleDelta) error {
+	byNamespace := map[string][]model.RelationTupleDelta{}
+	var namespaces []string
+	for _, delta := range deltas {
+		namespace := delta.RelationTuple.Namespace
+		if _, found := byNamespace[namespace]; !found {
+			namespaces = append(namespaces, namespace)
+		}
+		byNamespace[namespace] = append(byNamespace[namespace], delta)
+	}
+	for _, namespace := range namespaces {
+		batch := byNamespace[namespace]
+		for start := 0; start < len(batch); start += syncBatchSize {
+			end := start + syncBatchSize
+			if end > len(batch) {
+				end = len(batch)
+			}
+			if _, _, err := s.ketoService.PatchRelationTuples(ctx, batch[start:end]); err != nil {
+				return fmt.Errorf("writing namespace %s: %w", namespace, err)
+			}
+		}
+	}
+	return nil
+}
@@ -4,8 +4,17 @@ var (
 	NamespaceString = "namespace="
 	RelationString  = "relation="
 	ObjectString    = "object="
+	SnaptokenString = "snaptoken="
 	InvalidError    = "Invalid query params"
 	HttpResponse    = " Http Response: "
 	RelationLog     = " Relation: "
 	ObjectLog       = " Object: "
 )
+
+const (
+	HydraUpstream = "hydra"
+	KetoUpstream  = "keto"
+
+	// IntrospectionUpstream labels RFC 7662 introspection endpoints of other issuers than Hydra.
+	IntrospectionUpstream = "introspection"
+)
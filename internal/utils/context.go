@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+type contextKey string
+
+const (
+	clientIPKey  contextKey = "client-ip"
+	snaptokenKey contextKey = "snaptoken"
+	peerKey      contextKey = "peer-subject"
+)
+
+// WithClientIP returns a copy of ctx carrying the caller's address.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// ClientIP returns the caller's address stored by WithClientIP, if any.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey).(string)
+	return ip
+}
+
+// WithSnaptoken returns a copy of ctx asking Keto to answer at a snapshot no
+// older than snaptoken. An empty snaptoken leaves ctx unchanged.
+func WithSnaptoken(ctx context.Context, snaptoken string) context.Context {
+	if snaptoken == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, snaptokenKey, snaptoken)
+}
+
+// Snaptoken returns the snaptoken stored by WithSnaptoken, if any.
+func Snaptoken(ctx context.Context) string {
+	snaptoken, _ := ctx.Value(snaptokenKey).(string)
+	return snaptoken
+}
+
+// WithPeerSubject returns a copy of ctx carrying the subject of the verified
+// client certificate of the request.
+func WithPeerSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, peerKey, subject)
+}
+
+// PeerSubject returns the subject stored by WithPeerSubject, if any.
+func PeerSubject(ctx context.Context) string {
+	subject, _ := ctx.Value(peerKey).(string)
+	return subject
+}
+
+// detachedContext keeps the values of its parent but neither its deadline
+// nor its cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// WithoutCancel returns a copy of ctx that is not canceled when ctx is, like
+// context.WithoutCancel of Go 1.21. Upstream calls shared by concurrent
+// requests run under it, so that the request leading the call giving up
+// does not fail the others.
+func WithoutCancel(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
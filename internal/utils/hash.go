@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const TokenHashKeyEnv = "OZONE_TOKEN_HASH_KEY"
+
+// TokenHasher derives cache keys from bearer tokens with a keyed
+// HMAC-SHA256, so that raw credentials never have to be used as keys.
+type TokenHasher struct {
+	key []byte
+}
+
+// NewTokenHasher loads the HMAC key from the environment or, failing that,
+// from cache.token_hash_key. Without either a random per-process key is
+// used, which is enough for an in-memory cache but not for a shared one.
+func NewTokenHasher(config *viper.Viper) *TokenHasher {
+	key := os.Getenv(TokenHashKeyEnv)
+	if key == "" {
+		key = config.GetString("cache.token_hash_key")
+	}
+	if key != "" {
+		return &TokenHasher{key: []byte(key)}
+	}
+	log.Warn("No token hash key configured, using a random per-process key")
+	randomKey := make([]byte, 32)
+	if _, err := rand.Read(randomKey); err != nil {
+		log.Fatal("Unable to generate token hash key: ", err)
+	}
+	return &TokenHasher{key: randomKey}
+}
+
+// Hash returns the hex encoded HMAC of a token.
+func (h *TokenHasher) Hash(token string) string {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -4,11 +4,10 @@ import (
 	"context"
 	"io"
 	"net/http"
-	"time"
 
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
-	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 type HttpClient interface {
@@ -16,23 +15,29 @@ type HttpClient interface {
 }
 
 type httpClient struct {
+	client *http.Client
 }
 
 func NewHttpClient(cli *http.Client) HttpClient {
-	return &httpClient{}
+	return &httpClient{
+		client: cli,
+	}
+}
+
+// NewTracingClient returns an http.Client propagating the trace of the
+// request context to the server.
+func NewTracingClient() *http.Client {
+	return &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
 }
 
 func (httpClnt httpClient) SendRequest(ctx context.Context, method string, url string, body io.Reader, headers map[string]string) (*http.Response, error) {
-	httpClient := http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
 	httpRequest, _ := http.NewRequestWithContext(ctx, method, url, body)
 	for k, v := range headers {
 		httpRequest.Header.Add(k, v)
 	}
 	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(httpRequest.Header))
-	
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-    defer cancel()
-	httpResponse, err := httpClient.Do(httpRequest)
+
+	httpResponse, err := httpClnt.client.Do(httpRequest)
 
 	if httpResponse == nil {
 		return httpResponse, err
@@ -43,4 +48,4 @@ func (httpClnt httpClient) SendRequest(ctx context.Context, method string, url s
 	}
 	return httpResponse, nil
 
-}
\ No newline at end of file
+}
@@ -1,31 +1,323 @@
 package utils
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/livspaceeng/ozone/configs"
 	client "github.com/ory/keto-client-go"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
-var (
-	KetoClient		*client.APIClient
+const (
+	DefaultKetoBackend = "default"
+	defaultKetoTimeout = 3
+
+	RestProtocol = "rest"
+	GrpcProtocol = "grpc"
 )
 
-func Init() {
-	KetoClient = createKetoReadClient()
+// KetoRoute sends the listed namespaces to another Keto cluster than the
+// default keto.read.url and keto.write.url.
+type KetoRoute struct {
+	Name             string   `mapstructure:"name"`
+	Url              string   `mapstructure:"url"`
+	GrpcAddress      string   `mapstructure:"grpc_address"`
+	WriteUrl         string   `mapstructure:"write_url"`
+	WriteGrpcAddress string   `mapstructure:"write_grpc_address"`
+	Timeout          int      `mapstructure:"timeout"`
+	Namespaces       []string `mapstructure:"namespaces"`
 }
 
-func createKetoReadClient() *client.APIClient {
-	configs.Init()
-	readUri := configs.GetConfig().GetString("keto.read.url")
+// KetoBackend is one Keto cluster with its own clients and timeout. Conn and
+// WriteConn are only set when the cluster has gRPC addresses, WriteClient
+// only when it has a write url.
+type KetoBackend struct {
+	Name        string
+	Client      *client.APIClient
+	Conn        *grpc.ClientConn
+	WriteClient *client.APIClient
+	WriteConn   *grpc.ClientConn
+	Timeout     time.Duration
+}
+
+type ketoTable struct {
+	fallback   *KetoBackend
+	namespaces map[string]*KetoBackend
+	backends   []*KetoBackend
+}
+
+// KetoRouter picks the Keto cluster serving a namespace. The routing table is
+// rebuilt and swapped atomically on config reload. gRPC connections are kept
+// across reloads for addresses that are still routed to. The protocol is the
+// one of the startup config, as the Keto service is chosen by it.
+type KetoRouter struct {
+	httpClient *http.Client
+	protocol   string
+	table      atomic.Value
+	mu         sync.Mutex
+	conns      map[string]*grpc.ClientConn
+}
+
+func init() {
+	configs.RegisterValidator(func(config *viper.Viper) error {
+		_, err := LoadKetoRoutes(config)
+		return err
+	})
+}
+
+// snaptokenTransport adds the snaptoken of the request context to Keto
+// requests, which keto-client-go has no parameter for.
+type snaptokenTransport struct {
+	base http.RoundTripper
+}
+
+func (t snaptokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	snaptoken := Snaptoken(req.Context())
+	if snaptoken == "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	query := req.URL.Query()
+	query.Set("snaptoken", snaptoken)
+	req.URL.RawQuery = query.Encode()
+	return t.base.RoundTrip(req)
+}
+
+// NewKetoClient returns a Keto client for url sending its requests through
+// httpClient, forwarding the snaptoken of the request context.
+func NewKetoClient(url string, httpClient *http.Client) *client.APIClient {
+	transport := httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
 	configuration := client.NewConfiguration()
+	configuration.HTTPClient = &http.Client{
+		Transport:     snaptokenTransport{base: transport},
+		CheckRedirect: httpClient.CheckRedirect,
+		Jar:           httpClient.Jar,
+		Timeout:       httpClient.Timeout,
+	}
 	configuration.Servers = []client.ServerConfiguration{
 		{
-			URL: readUri,
+			URL: url,
 		},
 	}
-	KetoClient = client.NewAPIClient(configuration)
-	return KetoClient
+	return client.NewAPIClient(configuration)
+}
+
+// NewKetoGrpcConn dials the Keto gRPC API at address. The connection is
+// established lazily and kept open.
+func NewKetoGrpcConn(address string) (*grpc.ClientConn, error) {
+	return grpc.Dial(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+	)
+}
+
+// KetoProtocol returns keto.read.protocol, rest by default.
+func KetoProtocol(config *viper.Viper) string {
+	if protocol := config.GetString("keto.read.protocol"); protocol != "" {
+		return protocol
+	}
+	return RestProtocol
+}
+
+// LoadKetoRoutes reads and validates keto.routes from config.
+func LoadKetoRoutes(config *viper.Viper) ([]KetoRoute, error) {
+	return loadKetoRoutes(config, KetoProtocol(config))
+}
+
+// loadKetoRoutes reads keto.routes, requiring the gRPC addresses of protocol.
+func loadKetoRoutes(config *viper.Viper, protocol string) ([]KetoRoute, error) {
+	var routes []KetoRoute
+	if err := config.UnmarshalKey("keto.routes", &routes); err != nil {
+		return nil, err
+	}
+	if protocol != RestProtocol && protocol != GrpcProtocol {
+		return nil, fmt.Errorf("unknown keto protocol %q", protocol)
+	}
+	if protocol == GrpcProtocol && config.GetString("keto.read.grpc.address") == "" {
+		return nil, errors.New("keto.read.grpc.address is required for the grpc protocol")
+	}
+	seen := make(map[string]string)
+	for _, route := range routes {
+		if route.Name == "" || route.Name == DefaultKetoBackend {
+			return nil, errors.New("keto route needs a name other than default")
+		}
+		if route.Url == "" {
+			return nil, fmt.Errorf("keto route %s has no url", route.Name)
+		}
+		if protocol == GrpcProtocol && route.GrpcAddress == "" {
+			return nil, fmt.Errorf("keto route %s has no grpc_address", route.Name)
+		}
+		for _, namespace := range route.Namespaces {
+			if other, found := seen[namespace]; found {
+				return nil, fmt.Errorf("namespace %s is routed to both %s and %s", namespace, other, route.Name)
+			}
+			seen[namespace] = route.Name
+		}
+	}
+	return routes, nil
+}
+
+// NewKetoRouter builds the routing table from keto.read (the default
+// cluster) and keto.routes.
+func NewKetoRouter(config *viper.Viper, httpClient *http.Client) (*KetoRouter, error) {
+	router := &KetoRouter{httpClient: httpClient, protocol: KetoProtocol(config), conns: make(map[string]*grpc.ClientConn)}
+	if err := router.Reload(config); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+// Validate tells whether config can be reloaded: the protocol cannot change
+// without a restart, and the gRPC addresses stay required under grpc.
+func (r *KetoRouter) Validate(config *viper.Viper) error {
+	_, err := r.loadRoutes(config)
+	return err
+}
+
+func (r *KetoRouter) loadRoutes(config *viper.Viper) ([]KetoRoute, error) {
+	if protocol := KetoProtocol(config); protocol != r.protocol {
+		return nil, fmt.Errorf("keto.read.protocol cannot change from %s to %s without a restart", r.protocol, protocol)
+	}
+	return loadKetoRoutes(config, r.protocol)
+}
+
+// Reload rebuilds the routing table from config.
+func (r *KetoRouter) Reload(config *viper.Viper) error {
+	routes, err := r.loadRoutes(config)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	conns := make(map[string]*grpc.ClientConn)
+	fallback, err := r.newBackend(conns, KetoRoute{
+		Name:             DefaultKetoBackend,
+		Url:              config.GetString("keto.read.url"),
+		GrpcAddress:      config.GetString("keto.read.grpc.address"),
+		WriteUrl:         config.GetString("keto.write.url"),
+		WriteGrpcAddress: config.GetString("keto.write.grpc.address"),
+		Timeout:          config.GetInt("keto.read.timeout"),
+	})
+	if err != nil {
+		r.closeUnused(conns)
+		return err
+	}
+	table := &ketoTable{
+		fallback:   fallback,
+		namespaces: make(map[string]*KetoBackend),
+		backends:   []*KetoBackend{fallback},
+	}
+	for _, route := range routes {
+		backend, err := r.newBackend(conns, route)
+		if err != nil {
+			r.closeUnused(conns)
+			return err
+		}
+		table.backends = append(table.backends, backend)
+		for _, namespace := range route.Namespaces {
+			table.namespaces[namespace] = backend
+		}
+	}
+	r.table.Store(table)
+
+	for address, conn := range r.conns {
+		if _, found := conns[address]; !found {
+			conn.Close()
+		}
+	}
+	r.conns = conns
+	return nil
+}
+
+func (r *KetoRouter) newBackend(conns map[string]*grpc.ClientConn, route KetoRoute) (*KetoBackend, error) {
+	timeout := route.Timeout
+	if timeout <= 0 {
+		timeout = defaultKetoTimeout
+	}
+	backend := &KetoBackend{
+		Name:    route.Name,
+		Client:  NewKetoClient(route.Url, r.httpClient),
+		Timeout: time.Duration(timeout) * time.Second,
+	}
+	if route.WriteUrl != "" {
+		backend.WriteClient = NewKetoClient(route.WriteUrl, r.httpClient)
+	}
+	var err error
+	if backend.Conn, err = r.dial(conns, route.GrpcAddress); err != nil {
+		return nil, err
+	}
+	if backend.WriteConn, err = r.dial(conns, route.WriteGrpcAddress); err != nil {
+		return nil, err
+	}
+	return backend, nil
+}
+
+// closeUnused closes the connections of a routing table that failed to
+// load, except those the current table still uses.
+func (r *KetoRouter) closeUnused(conns map[string]*grpc.ClientConn) {
+	for address, conn := range conns {
+		if _, found := r.conns[address]; !found {
+			conn.Close()
+		}
+	}
 }
 
-func GetKetoReadClient() *client.APIClient {
-	return KetoClient
-}
\ No newline at end of file
+// dial returns the connection to address, reusing the one of the current
+// or the previous routing table. An empty address has no connection.
+func (r *KetoRouter) dial(conns map[string]*grpc.ClientConn, address string) (*grpc.ClientConn, error) {
+	if address == "" {
+		return nil, nil
+	}
+	conn, found := conns[address]
+	if !found {
+		conn, found = r.conns[address]
+	}
+	if !found {
+		var err error
+		if conn, err = NewKetoGrpcConn(address); err != nil {
+			return nil, err
+		}
+	}
+	conns[address] = conn
+	return conn, nil
+}
+
+// ForNamespace returns the backend serving namespace, or the default one.
+func (r *KetoRouter) ForNamespace(namespace string) *KetoBackend {
+	table := r.table.Load().(*ketoTable)
+	if backend, found := table.namespaces[namespace]; found {
+		return backend
+	}
+	return table.fallback
+}
+
+// Backends returns every backend, the default one first.
+func (r *KetoRouter) Backends() []*KetoBackend {
+	return r.table.Load().(*ketoTable).backends
+}
+
+// Health asks every backend whether it is ready and returns the error of
+// each unready one by backend name.
+func (r *KetoRouter) Health(ctx context.Context) map[string]error {
+	table := r.table.Load().(*ketoTable)
+	health := make(map[string]error, len(table.backends))
+	for _, backend := range table.backends {
+		checkCtx, cancel := context.WithTimeout(ctx, backend.Timeout)
+		_, _, err := backend.Client.MetadataApi.IsReady(checkCtx).Execute()
+		cancel()
+		health[backend.Name] = err
+	}
+	return health
+}
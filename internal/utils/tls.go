@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	NoClientAuth      = "none"
+	RequestClientAuth = "request"
+	RequireClientAuth = "require"
+
+	spiffeScheme = "spiffe"
+)
+
+// NewServerTLSConfig builds the TLS config of the server from server.tls.
+// With client_ca_file set, client certificates are verified against it and
+// required when client_auth is require.
+func NewServerTLSConfig(config *viper.Viper) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(config.GetString("server.tls.cert_file"), config.GetString("server.tls.key_file"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	caFile := config.GetString("server.tls.client_ca_file")
+	clientAuth := config.GetString("server.tls.client_auth")
+	if caFile == "" {
+		if clientAuth != "" && clientAuth != NoClientAuth {
+			return nil, errors.New("server.tls.client_auth needs server.tls.client_ca_file")
+		}
+		return tlsConfig, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	switch clientAuth {
+	case "", RequestClientAuth:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case RequireClientAuth:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	case NoClientAuth:
+		tlsConfig.ClientAuth = tls.NoClientCert
+	default:
+		return nil, fmt.Errorf("unknown server.tls.client_auth %q", clientAuth)
+	}
+	return tlsConfig, nil
+}
+
+// CertificateSubject returns the SPIFFE ID of a client certificate. The
+// common name is only used for a certificate without SPIFFE ID when
+// commonName is set, as any certificate of the CA may carry any name.
+func CertificateSubject(certificate *x509.Certificate, commonName bool) (string, error) {
+	for _, uri := range certificate.URIs {
+		if uri.Scheme == spiffeScheme {
+			return uri.String(), nil
+		}
+	}
+	if !commonName {
+		return "", errors.New("client certificate has no SPIFFE ID")
+	}
+	if certificate.Subject.CommonName != "" {
+		return certificate.Subject.CommonName, nil
+	}
+	return "", errors.New("client certificate has neither a SPIFFE ID nor a common name")
+}
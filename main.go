@@ -1,12 +1,16 @@
 package main
 
 import (
+	"os"
 
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/logging"
 	"github.com/livspaceeng/ozone/internal/server"
 	"github.com/livspaceeng/ozone/middleware"
 	log "github.com/sirupsen/logrus"
-	"go.opentelemetry.io/otel"
+	"github.com/spf13/viper"
 	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
 )
 
 // @title           Ozone API
@@ -25,15 +29,17 @@ import (
 // @BasePath  /api/v1
 // @schemes   http
 func main() {
-	// config.Init()
-	log.SetFormatter(&log.JSONFormatter{})
-	// log.SetOutput(os.Stdout)
-
-	logLevel, err := log.ParseLevel(log.DebugLevel.String())
-	if err != nil {
-		logLevel = log.InfoLevel
+	configs.Init()
+	if err := logging.Configure(configs.GetConfig()); err != nil {
+		log.Fatal("Invalid log config: ", err)
+	}
+	configs.OnReload(func(config *viper.Viper) {
+		logging.Configure(config)
+	})
+	if len(os.Args) > 1 && os.Args[1] == "sync" {
+		os.Exit(server.Sync(configs.GetStore(), os.Args[2:], os.Stdout))
 	}
-	log.SetLevel(logLevel)
+
 	traceProvider, err := middleware.JaegerTraceProvider()
 	if err != nil {
 		log.Error(err)
@@ -41,6 +47,6 @@ func main() {
 	otel.SetTracerProvider(traceProvider)
 	// b3propagator to track external server calls
 	p := b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader | b3.B3SingleHeader))
-    otel.SetTextMapPropagator(p)
+	otel.SetTextMapPropagator(p)
 	server.Init()
 }
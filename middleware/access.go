@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/livspaceeng/ozone/internal/logging"
+	"github.com/livspaceeng/ozone/internal/services"
+	"github.com/livspaceeng/ozone/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Access authenticates the bearer token of routes that do not do it
+// themselves, and checks the caller holds the permission of the route. Routes
+// made public in enforcement.access are let through without a token.
+func Access(enforcement *services.Enforcement, hydraSvc services.HydraService, ketoSvc services.KetoService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		access := enforcement.Access(c.FullPath())
+		if access.Public {
+			c.Next()
+			return
+		}
+
+		issuer, hasIssuer := c.GetQuery("issuer")
+		ctx := utils.WithClientIP(c.Request.Context(), c.ClientIP())
+		status, subject, err := hydraSvc.GetSubjectByToken(ctx, issuer, hasIssuer, c.GetHeader("Authorization"))
+		if status != http.StatusOK {
+			c.AbortWithStatusJSON(status, err.Error())
+			return
+		}
+		logging.AddFields(ctx, log.Fields{SubjectKey: subject})
+
+		if access.Permission != nil {
+			permission := access.Permission
+			status, _, err = ketoSvc.ValidatePolicy(ctx, permission.Namespace, permission.Relation, permission.Object, subject)
+			if status == http.StatusForbidden {
+				c.AbortWithStatusJSON(status, "Subject is not allowed to call "+c.FullPath())
+				return
+			} else if status != http.StatusOK {
+				c.AbortWithStatusJSON(status, err.Error())
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/livspaceeng/ozone/internal/logging"
+	"github.com/livspaceeng/ozone/internal/utils"
+)
+
+// ClientCertificate adds the subject of the verified client certificate of
+// a TLS request to its context, for requests without a bearer token to be
+// authenticated by it. The common name is used as subject only with
+// commonName set.
+func ClientCertificate(commonName bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := c.Request.TLS
+		if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+			c.Next()
+			return
+		}
+		subject, err := utils.CertificateSubject(state.VerifiedChains[0][0], commonName)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Warn("Ignoring client certificate: ", err)
+			c.Next()
+			return
+		}
+		c.Request = c.Request.WithContext(utils.WithPeerSubject(c.Request.Context(), subject))
+		c.Next()
+	}
+}
@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/livspaceeng/ozone/internal/services"
+)
+
+// Introspection lets the middlewares and the controller of a request share
+// the introspection of its bearer token, instead of each resolving and
+// counting it again.
+func Introspection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(services.WithIntrospectionMemo(c.Request.Context()))
+		c.Next()
+	}
+}
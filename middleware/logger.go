@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/livspaceeng/ozone/internal/logging"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const RequestIdHeader = "X-Request-Id"
+
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// RequestLogger attaches a logger carrying the request and trace IDs to the
+// request context and writes the access log through it once the request is
+// done. It replaces gin's default logger.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestId := c.GetHeader(RequestIdHeader)
+		if requestId == "" {
+			requestId = newRequestId()
+		}
+		c.Header(RequestIdHeader, requestId)
+
+		fields := log.Fields{"request_id": requestId}
+		if spanContext := trace.SpanContextFromContext(c.Request.Context()); spanContext.HasTraceID() {
+			fields["trace_id"] = spanContext.TraceID().String()
+		}
+		ctx := logging.WithLogger(c.Request.Context(), log.WithFields(fields))
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		logging.FromContext(ctx).WithFields(log.Fields{
+			"status":     c.Writer.Status(),
+			"method":     c.Request.Method,
+			"path":       c.Request.URL.Path,
+			"query":      c.Request.URL.RawQuery,
+			"latency_ms": time.Since(start).Milliseconds(),
+			"client_ip":  c.ClientIP(),
+			"user_agent": c.Request.UserAgent(),
+			"headers":    redact(c.Request.Header),
+		}).Info("access")
+	}
+}
+
+func redact(headers http.Header) http.Header {
+	redacted := headers.Clone()
+	for _, name := range redactedHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, "REDACTED")
+		}
+	}
+	return redacted
+}
+
+func newRequestId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,258 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/metrics"
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/livspaceeng/ozone/internal/services"
+	"github.com/livspaceeng/ozone/internal/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	IPKey       = "ip"
+	SubjectKey  = "subject"
+	ClientIdKey = "client_id"
+
+	MemoryBackend = "memory"
+	RedisBackend  = "redis"
+)
+
+// RateLimitRule is a token bucket applied to one route, refilled at Rate
+// tokens per second up to Burst tokens, with one bucket per Key value.
+type RateLimitRule struct {
+	Route string  `mapstructure:"route"`
+	Key   string  `mapstructure:"key"`
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// InvalidTokenLimit blocks a client IP that presented Max rejected tokens
+// within Window. A zero Max disables the check.
+type InvalidTokenLimit struct {
+	Max    int
+	Window time.Duration
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+type RateLimiter interface {
+	Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitResult, error)
+}
+
+// LoadRateLimitRules reads and validates ratelimit.rules from config.
+func LoadRateLimitRules(config *viper.Viper) ([]RateLimitRule, error) {
+	var rules []RateLimitRule
+	if err := config.UnmarshalKey("ratelimit.rules", &rules); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if rule.Route == "" {
+			return nil, errors.New("ratelimit rule without route")
+		}
+		if rule.Key != IPKey && rule.Key != SubjectKey && rule.Key != ClientIdKey {
+			return nil, fmt.Errorf("ratelimit rule for %s has unknown key %q", rule.Route, rule.Key)
+		}
+		if rule.Rate <= 0 || rule.Burst < 1 {
+			return nil, fmt.Errorf("ratelimit rule for %s needs a positive rate and burst", rule.Route)
+		}
+	}
+	return rules, nil
+}
+
+// NewRateLimiter builds the limiter backend selected by ratelimit.backend,
+// refilling buckets by the time now returns.
+func NewRateLimiter(config *viper.Viper, now func() time.Time) (RateLimiter, error) {
+	switch backend := config.GetString("ratelimit.backend"); backend {
+	case "", MemoryBackend:
+		return NewMemoryRateLimiter(now), nil
+	case RedisBackend:
+		return NewRedisRateLimiter(config.GetString("ratelimit.redis.address"), config.GetString("ratelimit.redis.password"), config.GetInt("ratelimit.redis.db"), now), nil
+	default:
+		return nil, fmt.Errorf("unknown ratelimit backend %q", backend)
+	}
+}
+
+// rateLimitPolicy is the reloadable part of the rate limit config.
+type rateLimitPolicy struct {
+	routes        map[string][]RateLimitRule
+	invalidTokens InvalidTokenLimit
+}
+
+type rateLimit struct {
+	limiter  RateLimiter
+	policy   atomic.Value
+	hydraSvc services.HydraService
+}
+
+func init() {
+	configs.RegisterValidator(func(config *viper.Viper) error {
+		_, err := loadRateLimitPolicy(config)
+		return err
+	})
+}
+
+func newRateLimitPolicy(rules []RateLimitRule, invalidTokens InvalidTokenLimit) *rateLimitPolicy {
+	routes := make(map[string][]RateLimitRule)
+	for _, rule := range rules {
+		routes[rule.Route] = append(routes[rule.Route], rule)
+	}
+	return &rateLimitPolicy{routes: routes, invalidTokens: invalidTokens}
+}
+
+func loadRateLimitPolicy(config *viper.Viper) (*rateLimitPolicy, error) {
+	rules, err := LoadRateLimitRules(config)
+	if err != nil {
+		return nil, err
+	}
+	invalidTokens := InvalidTokenLimit{
+		Max:    config.GetInt("ratelimit.max_invalid_tokens"),
+		Window: time.Duration(config.GetInt("cache.negative.rate_window")) * time.Second,
+	}
+	if invalidTokens.Window <= 0 {
+		invalidTokens.Window = time.Minute
+	}
+	return newRateLimitPolicy(rules, invalidTokens), nil
+}
+
+// NewRateLimitFromConfig builds the rate limiting middleware from the
+// ratelimit section of config. Rules and the invalid token limit follow
+// config reloads, the backend is fixed at startup.
+func NewRateLimitFromConfig(config *configs.Store, hydraSvc services.HydraService, now func() time.Time) (gin.HandlerFunc, error) {
+	limiter, err := NewRateLimiter(config.Get(), now)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := loadRateLimitPolicy(config.Get())
+	if err != nil {
+		return nil, err
+	}
+	r := &rateLimit{limiter: limiter, hydraSvc: hydraSvc}
+	r.policy.Store(policy)
+	config.OnReload(func(newConfig *viper.Viper) {
+		if policy, err := loadRateLimitPolicy(newConfig); err == nil {
+			r.policy.Store(policy)
+		}
+	})
+	return r.handle, nil
+}
+
+// RateLimit rejects requests with 429 once the bucket of any rule matching
+// the route is empty, or once the client IP went over its invalid token
+// limit. Subject and client_id keys resolve the bearer token through the
+// (cached) HydraService, with the issuer the controller will use, and fall
+// back to the client IP when the token cannot be resolved. Limiter failures
+// let the request through.
+func RateLimit(limiter RateLimiter, rules []RateLimitRule, invalidTokens InvalidTokenLimit, hydraSvc services.HydraService) gin.HandlerFunc {
+	r := &rateLimit{limiter: limiter, hydraSvc: hydraSvc}
+	r.policy.Store(newRateLimitPolicy(rules, invalidTokens))
+	return r.handle
+}
+
+func (r *rateLimit) handle(c *gin.Context) {
+	policy := r.policy.Load().(*rateLimitPolicy)
+	invalidTokens := policy.invalidTokens
+	if invalidTokens.Max > 0 && r.hydraSvc.InvalidTokenCount(c.ClientIP()) >= invalidTokens.Max {
+		log.Warn("Client exceeded invalid token limit: ", c.ClientIP())
+		metrics.RateLimitedRequests.WithLabelValues(c.FullPath(), "invalid_token").Inc()
+		c.Header("Retry-After", strconv.Itoa(ceilSeconds(invalidTokens.Window)))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, "Too many requests")
+		return
+	}
+
+	for _, rule := range policy.routes[c.FullPath()] {
+		key := rule.Key + ":" + rateLimitKey(c, rule, r.hydraSvc)
+		result, err := r.limiter.Take(c.Request.Context(), rule.Route+"|"+key, rule)
+		if err != nil {
+			log.Error("Rate limiter unavailable, allowing request: ", err)
+			continue
+		}
+		c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if !result.Allowed {
+			metrics.RateLimitedRequests.WithLabelValues(rule.Route, rule.Key).Inc()
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, "Too many requests")
+			return
+		}
+	}
+	c.Next()
+}
+
+func rateLimitKey(c *gin.Context, rule RateLimitRule, hydraSvc services.HydraService) string {
+	if rule.Key == IPKey {
+		return c.ClientIP()
+	}
+	bearer := c.GetHeader("Authorization")
+	if bearer == "" {
+		return c.ClientIP()
+	}
+	issuer, hasIssuer := requestIssuer(c)
+	ctx := utils.WithClientIP(c.Request.Context(), c.ClientIP())
+	status, hydraResponse, _ := hydraSvc.Introspect(ctx, issuer, hasIssuer, bearer)
+	if status != http.StatusOK {
+		return c.ClientIP()
+	}
+	if rule.Key == ClientIdKey && hydraResponse.ClientId != "" {
+		return hydraResponse.ClientId
+	}
+	return hydraResponse.Subject
+}
+
+// requestIssuer returns the issuer the controller will resolve the token
+// with: the issuer of the JSON body of a POST check, the issuer query
+// parameter otherwise. The body is left for the controller to read.
+func requestIssuer(c *gin.Context) (string, bool) {
+	if c.Request.Method != http.MethodPost || c.Request.Body == nil {
+		return c.GetQuery("issuer")
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", false
+	}
+	var request model.CheckRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return "", false
+	}
+	return request.Issuer, request.Issuer != ""
+}
+
+// bucketResult converts the tokens left in a bucket into a RateLimitResult.
+func bucketResult(rule RateLimitRule, tokens float64, allowed bool) RateLimitResult {
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Limit:     rule.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(rule.Burst) - tokens) / rule.Rate * float64(time.Second)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rule.Rate * float64(time.Second))
+	}
+	return result
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+type bucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+type memoryRateLimiter struct {
+	buckets *cache.Cache
+	now     func() time.Time
+}
+
+// NewMemoryRateLimiter keeps buckets in process memory. Idle buckets are
+// dropped once they would have refilled completely.
+func NewMemoryRateLimiter(now func() time.Time) RateLimiter {
+	return &memoryRateLimiter{
+		buckets: cache.New(time.Minute, time.Minute),
+		now:     now,
+	}
+}
+
+func (m *memoryRateLimiter) Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitResult, error) {
+	now := m.now()
+	idle := time.Duration(float64(rule.Burst)/rule.Rate*float64(time.Second)) + time.Second
+
+	b := &bucket{tokens: float64(rule.Burst), last: now}
+	if err := m.buckets.Add(key, b, idle); err != nil {
+		if existing, found := m.buckets.Get(key); found {
+			b = existing.(*bucket)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(float64(rule.Burst), b.tokens+now.Sub(b.last).Seconds()*rule.Rate)
+	b.last = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	m.buckets.Set(key, b, idle)
+	return bucketResult(rule, b.tokens, allowed), nil
+}
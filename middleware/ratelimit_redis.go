@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScript refills and takes from a bucket atomically. Tokens are
+// returned as a string because Redis truncates Lua numbers to integers.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+type redisRateLimiter struct {
+	client *redis.Client
+	now    func() time.Time
+}
+
+// NewRedisRateLimiter shares buckets between ozone replicas through Redis.
+func NewRedisRateLimiter(address string, password string, db int, now func() time.Time) RateLimiter {
+	return &redisRateLimiter{
+		client: redis.NewClient(&redis.Options{
+			Addr:     address,
+			Password: password,
+			DB:       db,
+		}),
+		now: now,
+	}
+}
+
+func (r *redisRateLimiter) Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitResult, error) {
+	now := r.now().UnixMilli()
+	values, err := tokenBucketScript.Run(ctx, r.client, []string{"ozone:ratelimit:" + key}, rule.Rate, rule.Burst, now).Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	allowed, _ := values[0].(int64)
+	tokens, err := strconv.ParseFloat(values[1].(string), 64)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return bucketResult(rule, tokens, allowed == 1), nil
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/logging"
+	"github.com/livspaceeng/ozone/internal/metrics"
+	"github.com/livspaceeng/ozone/internal/services"
+	"github.com/livspaceeng/ozone/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	TenantKey = "tenant"
+
+	unknownTenant = "unknown"
+)
+
+type tenancy struct {
+	tenancy  *services.Tenancy
+	hydraSvc services.HydraService
+	limiter  RateLimiter
+}
+
+// NewTenancyFromConfig builds the tenancy middleware. Quotas are kept in the
+// ratelimit.backend limiter.
+func NewTenancyFromConfig(config *configs.Store, tenancySvc *services.Tenancy, hydraSvc services.HydraService, now func() time.Time) (gin.HandlerFunc, error) {
+	limiter, err := NewRateLimiter(config.Get(), now)
+	if err != nil {
+		return nil, err
+	}
+	return Tenancy(tenancySvc, hydraSvc, limiter), nil
+}
+
+// Tenancy resolves the tenant of the bearer token and takes a token from
+// its quota. The tenant is added to the request context, for controllers to
+// check the namespaces of the request against its grants. It does nothing
+// while tenancy is disabled. The token is resolved through the introspection
+// shared by the request, see Introspection, and so is counted once when
+// invalid.
+func Tenancy(tenancySvc *services.Tenancy, hydraSvc services.HydraService, limiter RateLimiter) gin.HandlerFunc {
+	t := &tenancy{tenancy: tenancySvc, hydraSvc: hydraSvc, limiter: limiter}
+	return t.handle
+}
+
+func (t *tenancy) handle(c *gin.Context) {
+	if !t.tenancy.Enabled() {
+		c.Next()
+		return
+	}
+
+	issuer, hasIssuer := c.GetQuery("issuer")
+	ctx := utils.WithClientIP(c.Request.Context(), c.ClientIP())
+	status, hydraResponse, err := t.hydraSvc.Introspect(ctx, issuer, hasIssuer, c.GetHeader("Authorization"))
+	if status != http.StatusOK {
+		c.AbortWithStatusJSON(status, err.Error())
+		return
+	}
+	if hydraResponse.TokenType == services.CertificateIssuer {
+		issuer = services.CertificateIssuer
+	} else if !hasIssuer {
+		issuer = services.DefaultIssuer
+	}
+	tenant, found := t.tenancy.Resolve(issuer, hydraResponse.ClientId)
+	if !found {
+		logging.FromContext(ctx).Warn("No tenant for client: ", hydraResponse.ClientId, " issuer: ", issuer)
+		metrics.TenantRejections.WithLabelValues(unknownTenant, "unknown").Inc()
+		c.AbortWithStatusJSON(http.StatusForbidden, "Client does not belong to a tenant")
+		return
+	}
+	logging.AddFields(ctx, log.Fields{TenantKey: tenant.Name})
+	metrics.TenantRequests.WithLabelValues(tenant.Name, c.FullPath()).Inc()
+
+	if tenant.Quota.Rate > 0 {
+		rule := RateLimitRule{Route: TenantKey, Key: TenantKey, Rate: tenant.Quota.Rate, Burst: tenant.Quota.Burst}
+		result, err := t.limiter.Take(ctx, TenantKey+"|"+tenant.Name, rule)
+		if err != nil {
+			logging.FromContext(ctx).Error("Rate limiter unavailable, allowing request: ", err)
+		} else if !result.Allowed {
+			metrics.TenantRejections.WithLabelValues(tenant.Name, "quota").Inc()
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, "Tenant quota exceeded")
+			return
+		}
+	}
+
+	c.Request = c.Request.WithContext(services.WithTenant(c.Request.Context(), tenant))
+	c.Next()
+}
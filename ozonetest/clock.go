@@ -0,0 +1,22 @@
+package ozonetest
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock runs with the real time until a test moves it forward, so that
+// expiries can be reached without sleeping.
+type Clock struct {
+	offset int64
+}
+
+// Now returns the real time moved forward by every Advance so far.
+func (c *Clock) Now() time.Time {
+	return time.Now().Add(time.Duration(atomic.LoadInt64(&c.offset)))
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	atomic.AddInt64(&c.offset, int64(d))
+}
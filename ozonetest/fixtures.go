@@ -0,0 +1,54 @@
+package ozonetest
+
+import (
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fixtures seed the fake Hydra and Keto servers.
+type Fixtures struct {
+	Tokens []Token `yaml:"tokens"`
+	Tuples []Tuple `yaml:"tuples"`
+}
+
+// Token is an access token known to the fake Hydra. ExpiresIn is relative
+// to the time the token is introspected.
+type Token struct {
+	Token     string `yaml:"token"`
+	Subject   string `yaml:"subject"`
+	ClientId  string `yaml:"client_id"`
+	Scope     string `yaml:"scope"`
+	ExpiresIn int    `yaml:"expires_in"`
+	Inactive  bool   `yaml:"inactive"`
+}
+
+// Tuple is a relation tuple known to the fake Keto, with either a
+// SubjectId or a SubjectSet.
+type Tuple struct {
+	Namespace  string      `yaml:"namespace" json:"namespace"`
+	Object     string      `yaml:"object" json:"object"`
+	Relation   string      `yaml:"relation" json:"relation"`
+	SubjectId  string      `yaml:"subject_id,omitempty" json:"subject_id,omitempty"`
+	SubjectSet *SubjectSet `yaml:"subject_set,omitempty" json:"subject_set,omitempty"`
+}
+
+type SubjectSet struct {
+	Namespace string `yaml:"namespace" json:"namespace"`
+	Object    string `yaml:"object" json:"object"`
+	Relation  string `yaml:"relation" json:"relation"`
+}
+
+func ParseFixtures(data []byte) (Fixtures, error) {
+	var fixtures Fixtures
+	err := yaml.Unmarshal(data, &fixtures)
+	return fixtures, err
+}
+
+func LoadFixtures(path string) (Fixtures, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Fixtures{}, err
+	}
+	return ParseFixtures(data)
+}
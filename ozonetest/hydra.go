@@ -0,0 +1,102 @@
+package ozonetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/livspaceeng/ozone/internal/model"
+)
+
+const IntrospectPath = "/oauth2/introspect"
+
+// FakeHydra is an in-process Hydra answering token introspection from
+// fixtures.
+type FakeHydra struct {
+	*httptest.Server
+	calls   int64
+	failing int32
+	delay   int64
+	now     func() time.Time
+
+	mu     sync.RWMutex
+	tokens map[string]Token
+}
+
+func NewFakeHydra(tokens []Token) *FakeHydra {
+	hydra := &FakeHydra{tokens: make(map[string]Token), now: time.Now}
+	for _, token := range tokens {
+		hydra.tokens[token.Token] = token
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(IntrospectPath, hydra.introspect)
+	hydra.Server = httptest.NewServer(mux)
+	return hydra
+}
+
+// AddToken makes a token known to Hydra.
+func (h *FakeHydra) AddToken(token Token) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokens[token.Token] = token
+}
+
+// Calls returns how many introspection requests reached Hydra.
+func (h *FakeHydra) Calls() int {
+	return int(atomic.LoadInt64(&h.calls))
+}
+
+// SetFailing makes Hydra answer every request with a 500.
+func (h *FakeHydra) SetFailing(failing bool) {
+	var value int32
+	if failing {
+		value = 1
+	}
+	atomic.StoreInt32(&h.failing, value)
+}
+
+// SetClock makes Hydra issue tokens relative to now instead of the real
+// time. It has to be set before the first request.
+func (h *FakeHydra) SetClock(now func() time.Time) {
+	h.now = now
+}
+
+// SetDelay makes Hydra wait before answering each request.
+func (h *FakeHydra) SetDelay(delay time.Duration) {
+	atomic.StoreInt64(&h.delay, int64(delay))
+}
+
+func (h *FakeHydra) introspect(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&h.calls, 1)
+	time.Sleep(time.Duration(atomic.LoadInt64(&h.delay)))
+	if atomic.LoadInt32(&h.failing) == 1 {
+		http.Error(w, "upstream failure", http.StatusInternalServerError)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mu.RLock()
+	token, found := h.tokens[r.PostFormValue("token")]
+	h.mu.RUnlock()
+	response := model.HydraResponse{Active: false}
+	if found && !token.Inactive {
+		now := h.now().Unix()
+		response = model.HydraResponse{
+			Active:    true,
+			Expiry:    int(now) + token.ExpiresIn,
+			IssuedAt:  int(now),
+			Scope:     token.Scope,
+			ClientId:  token.ClientId,
+			Subject:   token.Subject,
+			TokenType: "access_token",
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,105 @@
+package ozonetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	RealmPath           = "/realms/ozone"
+	RealmIntrospectPath = RealmPath + "/protocol/openid-connect/token/introspect"
+	realmDiscoveryPath  = RealmPath + "/.well-known/openid-configuration"
+)
+
+// FakeIntrospection is an in-process RFC 7662 server, in the manner of
+// Keycloak, that requires client authentication and answers with whatever
+// claims a token was given. It serves an OpenID discovery document below
+// RealmPath.
+type FakeIntrospection struct {
+	*httptest.Server
+	clientId     string
+	clientSecret string
+	calls        int64
+	discoveries  int64
+	authMethod   atomic.Value
+
+	mu     sync.RWMutex
+	tokens map[string]map[string]interface{}
+}
+
+func NewFakeIntrospection(clientId string, clientSecret string) *FakeIntrospection {
+	server := &FakeIntrospection{clientId: clientId, clientSecret: clientSecret, tokens: make(map[string]map[string]interface{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc(RealmIntrospectPath, server.introspect)
+	mux.HandleFunc(realmDiscoveryPath, server.discovery)
+	server.Server = httptest.NewServer(mux)
+	return server
+}
+
+// AddToken makes a token active with the given claims.
+func (s *FakeIntrospection) AddToken(token string, claims map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = claims
+}
+
+// Calls returns how many introspection requests were authenticated.
+func (s *FakeIntrospection) Calls() int {
+	return int(atomic.LoadInt64(&s.calls))
+}
+
+// AuthMethod returns how the last client authenticated, client_secret_basic
+// or client_secret_post.
+func (s *FakeIntrospection) AuthMethod() string {
+	method, _ := s.authMethod.Load().(string)
+	return method
+}
+
+// Discoveries returns how many times the discovery document was fetched.
+func (s *FakeIntrospection) Discoveries() int {
+	return int(atomic.LoadInt64(&s.discoveries))
+}
+
+func (s *FakeIntrospection) discovery(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&s.discoveries, 1)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 s.URL + RealmPath,
+		"introspection_endpoint": s.URL + RealmIntrospectPath,
+	})
+}
+
+func (s *FakeIntrospection) introspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientId, clientSecret, basic := r.BasicAuth()
+	method := "client_secret_basic"
+	if !basic {
+		clientId, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+		method = "client_secret_post"
+	}
+	if clientId != s.clientId || clientSecret != s.clientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	atomic.AddInt64(&s.calls, 1)
+	s.authMethod.Store(method)
+
+	s.mu.RLock()
+	claims, found := s.tokens[r.PostFormValue("token")]
+	s.mu.RUnlock()
+	response := map[string]interface{}{"active": false}
+	if found {
+		response = map[string]interface{}{"active": true}
+		for name, value := range claims {
+			response[name] = value
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,200 @@
+package ozonetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// FakeKeto is an in-process Keto read and write API backed by fixture
+// tuples. It serves REST on URL and gRPC on GrpcAddress.
+type FakeKeto struct {
+	*httptest.Server
+	GrpcAddress string
+	grpcServer  *grpc.Server
+	store       *tupleStore
+	calls       int64
+	failing     int32
+	delay       int64
+	mu          sync.Mutex
+	snaptokens  []string
+}
+
+func NewFakeKeto(tuples []Tuple) *FakeKeto {
+	keto := &FakeKeto{store: newTupleStore(tuples)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/relation-tuples/check", keto.check)
+	mux.HandleFunc("/relation-tuples/check/openapi", keto.check)
+	mux.HandleFunc("/relation-tuples/expand", keto.expand)
+	mux.HandleFunc("/relation-tuples", keto.list)
+	mux.HandleFunc("/admin/relation-tuples", keto.patch)
+	mux.HandleFunc("/namespaces", keto.namespaces)
+	mux.HandleFunc("/health/alive", keto.health)
+	mux.HandleFunc("/health/ready", keto.health)
+	keto.Server = httptest.NewServer(keto.guard(mux))
+	keto.startGrpc()
+	return keto
+}
+
+// Close stops the REST and gRPC servers.
+func (k *FakeKeto) Close() {
+	k.grpcServer.Stop()
+	k.Server.Close()
+}
+
+// AddTuple makes a relation tuple known to Keto.
+func (k *FakeKeto) AddTuple(tuple Tuple) {
+	k.store.add(tuple)
+}
+
+// Calls returns how many requests reached Keto.
+func (k *FakeKeto) Calls() int {
+	return int(atomic.LoadInt64(&k.calls))
+}
+
+// Snaptokens returns the snaptokens sent with reads, in order.
+func (k *FakeKeto) Snaptokens() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]string{}, k.snaptokens...)
+}
+
+func (k *FakeKeto) recordSnaptoken(snaptoken string) {
+	if snaptoken == "" {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.snaptokens = append(k.snaptokens, snaptoken)
+}
+
+// SetFailing makes Keto answer every request with a 500.
+func (k *FakeKeto) SetFailing(failing bool) {
+	var value int32
+	if failing {
+		value = 1
+	}
+	atomic.StoreInt32(&k.failing, value)
+}
+
+// SetDelay makes Keto wait before answering each REST request.
+func (k *FakeKeto) SetDelay(delay time.Duration) {
+	atomic.StoreInt64(&k.delay, int64(delay))
+}
+
+func (k *FakeKeto) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&k.calls, 1)
+		time.Sleep(time.Duration(atomic.LoadInt64(&k.delay)))
+		if atomic.LoadInt32(&k.failing) == 1 {
+			writeError(w, http.StatusInternalServerError, "upstream failure")
+			return
+		}
+		k.recordSnaptoken(r.URL.Query().Get("snaptoken"))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (k *FakeKeto) check(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tuple := tupleFromQuery(query)
+	if tuple.Namespace == "" || tuple.Object == "" || tuple.Relation == "" || (tuple.SubjectId == "" && tuple.SubjectSet == nil) {
+		writeError(w, http.StatusBadRequest, "incomplete tuple")
+		return
+	}
+	maxDepth, _ := strconv.Atoi(query.Get("max-depth"))
+	allowed := k.store.check(tuple.Namespace, tuple.Object, tuple.Relation, tuple.SubjectId, tuple.SubjectSet, maxDepth)
+	status := http.StatusOK
+	if !allowed && r.URL.Path == "/relation-tuples/check" {
+		status = http.StatusForbidden
+	}
+	writeJSON(w, status, map[string]bool{"allowed": allowed})
+}
+
+func (k *FakeKeto) expand(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	maxDepth, _ := strconv.Atoi(query.Get("max-depth"))
+	tree := k.store.expand(query.Get("namespace"), query.Get("object"), query.Get("relation"), maxDepth)
+	if tree == nil {
+		writeError(w, http.StatusNotFound, "no relation tuple found")
+		return
+	}
+	writeJSON(w, http.StatusOK, tree)
+}
+
+func (k *FakeKeto) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tuples := k.store.list(tupleFromQuery(query))
+
+	pageSize, _ := strconv.Atoi(query.Get("page_size"))
+	page, nextPageToken := paginate(tuples, query.Get("page_token"), pageSize)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"relation_tuples": page,
+		"next_page_token": nextPageToken,
+	})
+}
+
+func (k *FakeKeto) patch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var deltas []Delta
+	if err := json.NewDecoder(r.Body).Decode(&deltas); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	k.store.apply(deltas)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (k *FakeKeto) namespaces(w http.ResponseWriter, r *http.Request) {
+	namespaces := []map[string]string{}
+	for _, name := range k.store.namespaces() {
+		namespaces = append(namespaces, map[string]string{"name": name})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"namespaces": namespaces})
+}
+
+func (k *FakeKeto) health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func tupleFromQuery(query url.Values) Tuple {
+	tuple := Tuple{
+		Namespace: query.Get("namespace"),
+		Object:    query.Get("object"),
+		Relation:  query.Get("relation"),
+		SubjectId: query.Get("subject_id"),
+	}
+	if query.Has("subject_set.namespace") || query.Has("subject_set.object") || query.Has("subject_set.relation") {
+		tuple.SubjectSet = &SubjectSet{
+			Namespace: query.Get("subject_set.namespace"),
+			Object:    query.Get("subject_set.object"),
+			Relation:  query.Get("subject_set.relation"),
+		}
+	}
+	return tuple
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    status,
+			"message": message,
+		},
+	})
+}
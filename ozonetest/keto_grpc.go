@@ -0,0 +1,155 @@
+package ozonetest
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+
+	rts "github.com/ory/keto/proto/ory/keto/relation_tuples/v1alpha2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcKeto serves the Keto gRPC read API from the same tuples as the REST
+// API of a FakeKeto.
+type grpcKeto struct {
+	rts.UnimplementedCheckServiceServer
+	rts.UnimplementedExpandServiceServer
+	rts.UnimplementedReadServiceServer
+	rts.UnimplementedWriteServiceServer
+	rts.UnimplementedNamespacesServiceServer
+	keto *FakeKeto
+}
+
+func (k *FakeKeto) startGrpc() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("ozonetest: failed to listen: " + err.Error())
+	}
+	k.GrpcAddress = listener.Addr().String()
+	k.grpcServer = grpc.NewServer(grpc.UnaryInterceptor(k.guardGrpc))
+	server := &grpcKeto{keto: k}
+	rts.RegisterCheckServiceServer(k.grpcServer, server)
+	rts.RegisterExpandServiceServer(k.grpcServer, server)
+	rts.RegisterReadServiceServer(k.grpcServer, server)
+	rts.RegisterWriteServiceServer(k.grpcServer, server)
+	rts.RegisterNamespacesServiceServer(k.grpcServer, server)
+	go k.grpcServer.Serve(listener)
+}
+
+func (k *FakeKeto) guardGrpc(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	atomic.AddInt64(&k.calls, 1)
+	if atomic.LoadInt32(&k.failing) == 1 {
+		return nil, status.Error(codes.Internal, "upstream failure")
+	}
+	return handler(ctx, req)
+}
+
+func (s *grpcKeto) Check(ctx context.Context, req *rts.CheckRequest) (*rts.CheckResponse, error) {
+	protoTuple := req.GetTuple()
+	if protoTuple == nil {
+		protoTuple = &rts.RelationTuple{Namespace: req.GetNamespace(), Object: req.GetObject(), Relation: req.GetRelation(), Subject: req.GetSubject()}
+	}
+	tuple := tupleFromProto(protoTuple)
+	s.keto.recordSnaptoken(req.GetSnaptoken())
+	if tuple.Namespace == "" || tuple.Object == "" || tuple.Relation == "" || (tuple.SubjectId == "" && tuple.SubjectSet == nil) {
+		return nil, status.Error(codes.InvalidArgument, "incomplete tuple")
+	}
+	allowed := s.keto.store.check(tuple.Namespace, tuple.Object, tuple.Relation, tuple.SubjectId, tuple.SubjectSet, int(req.GetMaxDepth()))
+	return &rts.CheckResponse{Allowed: allowed}, nil
+}
+
+func (s *grpcKeto) Expand(ctx context.Context, req *rts.ExpandRequest) (*rts.ExpandResponse, error) {
+	s.keto.recordSnaptoken(req.GetSnaptoken())
+	set := req.GetSubject().GetSet()
+	if set == nil {
+		return nil, status.Error(codes.InvalidArgument, "expand needs a subject set")
+	}
+	tree := s.keto.store.expand(set.Namespace, set.Object, set.Relation, int(req.GetMaxDepth()))
+	if tree == nil {
+		return nil, status.Error(codes.NotFound, "no relation tuple found")
+	}
+	return &rts.ExpandResponse{Tree: treeToProto(*tree)}, nil
+}
+
+func (s *grpcKeto) ListRelationTuples(ctx context.Context, req *rts.ListRelationTuplesRequest) (*rts.ListRelationTuplesResponse, error) {
+	s.keto.recordSnaptoken(req.GetSnaptoken())
+	var query Tuple
+	if relationQuery := req.GetRelationQuery(); relationQuery != nil {
+		query = tupleFromProto(&rts.RelationTuple{
+			Namespace: relationQuery.GetNamespace(),
+			Object:    relationQuery.GetObject(),
+			Relation:  relationQuery.GetRelation(),
+			Subject:   relationQuery.GetSubject(),
+		})
+	}
+	page, nextPageToken := paginate(s.keto.store.list(query), req.GetPageToken(), int(req.GetPageSize()))
+	resp := &rts.ListRelationTuplesResponse{NextPageToken: nextPageToken}
+	for _, tuple := range page {
+		resp.RelationTuples = append(resp.RelationTuples, tupleToProto(tuple))
+	}
+	return resp, nil
+}
+
+func (s *grpcKeto) TransactRelationTuples(ctx context.Context, req *rts.TransactRelationTuplesRequest) (*rts.TransactRelationTuplesResponse, error) {
+	var deltas []Delta
+	for _, delta := range req.GetRelationTupleDeltas() {
+		action := "insert"
+		if delta.GetAction() == rts.RelationTupleDelta_ACTION_DELETE {
+			action = "delete"
+		}
+		deltas = append(deltas, Delta{Action: action, RelationTuple: tupleFromProto(delta.GetRelationTuple())})
+	}
+	snaptoken := s.keto.store.apply(deltas)
+	return &rts.TransactRelationTuplesResponse{Snaptokens: []string{snaptoken}}, nil
+}
+
+func tupleFromProto(tuple *rts.RelationTuple) Tuple {
+	converted := Tuple{
+		Namespace: tuple.GetNamespace(),
+		Object:    tuple.GetObject(),
+		Relation:  tuple.GetRelation(),
+		SubjectId: tuple.GetSubject().GetId(),
+	}
+	if set := tuple.GetSubject().GetSet(); set != nil {
+		converted.SubjectSet = &SubjectSet{Namespace: set.Namespace, Object: set.Object, Relation: set.Relation}
+	}
+	return converted
+}
+
+func tupleToProto(tuple Tuple) *rts.RelationTuple {
+	converted := &rts.RelationTuple{
+		Namespace: tuple.Namespace,
+		Object:    tuple.Object,
+		Relation:  tuple.Relation,
+	}
+	if tuple.SubjectSet != nil {
+		converted.Subject = rts.NewSubjectSet(tuple.SubjectSet.Namespace, tuple.SubjectSet.Object, tuple.SubjectSet.Relation)
+	} else {
+		converted.Subject = rts.NewSubjectID(tuple.SubjectId)
+	}
+	return converted
+}
+
+func treeToProto(node ExpandNode) *rts.SubjectTree {
+	tree := &rts.SubjectTree{NodeType: rts.NodeType_NODE_TYPE_LEAF}
+	if node.Type == "union" {
+		tree.NodeType = rts.NodeType_NODE_TYPE_UNION
+	}
+	if node.Tuple != nil {
+		tree.Tuple = tupleToProto(*node.Tuple)
+	}
+	for _, child := range node.Children {
+		tree.Children = append(tree.Children, treeToProto(child))
+	}
+	return tree
+}
+
+func (s *grpcKeto) ListNamespaces(ctx context.Context, req *rts.ListNamespacesRequest) (*rts.ListNamespacesResponse, error) {
+	resp := &rts.ListNamespacesResponse{}
+	for _, name := range s.keto.store.namespaces() {
+		resp.Namespaces = append(resp.Namespaces, &rts.Namespace{Name: name})
+	}
+	return resp, nil
+}
@@ -0,0 +1,225 @@
+package ozonetest
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	defaultMaxDepth = 5
+	defaultPageSize = 100
+)
+
+// ExpandNode mirrors the JSON expand tree served by Keto.
+type ExpandNode struct {
+	Type     string       `json:"type"`
+	Tuple    *Tuple       `json:"tuple,omitempty"`
+	Children []ExpandNode `json:"children,omitempty"`
+}
+
+// tupleStore evaluates checks, expands and lists over fixture tuples, the
+// same way for every protocol the fake Keto speaks.
+type tupleStore struct {
+	mu      sync.RWMutex
+	tuples  []Tuple
+	version int
+}
+
+func newTupleStore(tuples []Tuple) *tupleStore {
+	return &tupleStore{tuples: append([]Tuple{}, tuples...)}
+}
+
+// Delta inserts or deletes a tuple in a write.
+type Delta struct {
+	Action        string `json:"action"`
+	RelationTuple Tuple  `json:"relation_tuple"`
+}
+
+func (s *tupleStore) add(tuple Tuple) {
+	s.apply([]Delta{{Action: "insert", RelationTuple: tuple}})
+}
+
+// apply writes deltas atomically and returns the snaptoken of the new
+// version of the tuples.
+func (s *tupleStore) apply(deltas []Delta) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, delta := range deltas {
+		if delta.Action == "delete" {
+			s.removeLocked(delta.RelationTuple)
+		} else {
+			s.removeLocked(delta.RelationTuple)
+			s.tuples = append(s.tuples, delta.RelationTuple)
+		}
+	}
+	s.version++
+	return "v" + strconv.Itoa(s.version)
+}
+
+func (s *tupleStore) removeLocked(tuple Tuple) {
+	kept := s.tuples[:0]
+	for _, t := range s.tuples {
+		if !sameTuple(t, tuple) {
+			kept = append(kept, t)
+		}
+	}
+	s.tuples = kept
+}
+
+// check reports whether subject (a subject id, or a subject set when
+// subjectSet is not nil) is related to the object, following subject sets
+// up to maxDepth.
+func (s *tupleStore) check(namespace, object, relation, subjectId string, subjectSet *SubjectSet, maxDepth int) bool {
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxDepth
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkLocked(SubjectSet{Namespace: namespace, Object: object, Relation: relation}, subjectId, subjectSet, maxDepth, map[SubjectSet]bool{})
+}
+
+func (s *tupleStore) checkLocked(set SubjectSet, subjectId string, subjectSet *SubjectSet, depth int, visited map[SubjectSet]bool) bool {
+	if depth == 0 || visited[set] {
+		return false
+	}
+	visited[set] = true
+	for _, tuple := range s.tuples {
+		if tuple.Namespace != set.Namespace || tuple.Object != set.Object || tuple.Relation != set.Relation {
+			continue
+		}
+		if subjectSet == nil && tuple.SubjectId != "" && tuple.SubjectId == subjectId {
+			return true
+		}
+		if tuple.SubjectSet == nil {
+			continue
+		}
+		if subjectSet != nil && *tuple.SubjectSet == *subjectSet {
+			return true
+		}
+		if s.checkLocked(*tuple.SubjectSet, subjectId, subjectSet, depth-1, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// expand returns the tree of subjects related to the object, or nil when no
+// tuple exists for it.
+func (s *tupleStore) expand(namespace, object, relation string, maxDepth int) *ExpandNode {
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxDepth
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.expandLocked(SubjectSet{Namespace: namespace, Object: object, Relation: relation}, maxDepth)
+}
+
+func (s *tupleStore) expandLocked(set SubjectSet, depth int) *ExpandNode {
+	var children []ExpandNode
+	for _, tuple := range s.tuples {
+		if tuple.Namespace != set.Namespace || tuple.Object != set.Object || tuple.Relation != set.Relation {
+			continue
+		}
+		if tuple.SubjectSet == nil {
+			children = append(children, ExpandNode{Type: "leaf", Tuple: &Tuple{SubjectId: tuple.SubjectId}})
+			continue
+		}
+		if depth > 1 {
+			if child := s.expandLocked(*tuple.SubjectSet, depth-1); child != nil {
+				children = append(children, *child)
+				continue
+			}
+		}
+		subjectSet := *tuple.SubjectSet
+		children = append(children, ExpandNode{Type: "leaf", Tuple: &Tuple{SubjectSet: &subjectSet}})
+	}
+	if children == nil {
+		return nil
+	}
+	return &ExpandNode{Type: "union", Tuple: &Tuple{SubjectSet: &set}, Children: children}
+}
+
+// list returns the tuples matching every non empty field of query.
+func (s *tupleStore) list(query Tuple) []Tuple {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var tuples []Tuple
+	for _, tuple := range s.tuples {
+		if matches(tuple, query) {
+			tuples = append(tuples, tuple)
+		}
+	}
+	return tuples
+}
+
+// namespaces returns the namespaces of the tuples, sorted. Keto reads them
+// from its config, the fake knows a namespace once it has a tuple in it.
+func (s *tupleStore) namespaces() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := make(map[string]bool)
+	var names []string
+	for _, tuple := range s.tuples {
+		if !seen[tuple.Namespace] {
+			seen[tuple.Namespace] = true
+			names = append(names, tuple.Namespace)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// paginate returns the page of tuples starting at the offset encoded in
+// pageToken, and the token of the next page or "" on the last page.
+func paginate(tuples []Tuple, pageToken string, pageSize int) ([]Tuple, string) {
+	offset, _ := strconv.Atoi(pageToken)
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if offset > len(tuples) {
+		offset = len(tuples)
+	}
+	end := offset + pageSize
+	nextPageToken := ""
+	if end < len(tuples) {
+		nextPageToken = strconv.Itoa(end)
+	} else {
+		end = len(tuples)
+	}
+	page := tuples[offset:end]
+	if page == nil {
+		page = []Tuple{}
+	}
+	return page, nextPageToken
+}
+
+func matches(tuple Tuple, query Tuple) bool {
+	if (query.Namespace != "" && query.Namespace != tuple.Namespace) ||
+		(query.Object != "" && query.Object != tuple.Object) ||
+		(query.Relation != "" && query.Relation != tuple.Relation) ||
+		(query.SubjectId != "" && query.SubjectId != tuple.SubjectId) {
+		return false
+	}
+	if query.SubjectSet != nil {
+		if tuple.SubjectSet == nil {
+			return false
+		}
+		if (query.SubjectSet.Namespace != "" && query.SubjectSet.Namespace != tuple.SubjectSet.Namespace) ||
+			(query.SubjectSet.Object != "" && query.SubjectSet.Object != tuple.SubjectSet.Object) ||
+			(query.SubjectSet.Relation != "" && query.SubjectSet.Relation != tuple.SubjectSet.Relation) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameTuple(a Tuple, b Tuple) bool {
+	if a.Namespace != b.Namespace || a.Object != b.Object || a.Relation != b.Relation || a.SubjectId != b.SubjectId {
+		return false
+	}
+	if a.SubjectSet == nil || b.SubjectSet == nil {
+		return a.SubjectSet == b.SubjectSet
+	}
+	return *a.SubjectSet == *b.SubjectSet
+}
@@ -0,0 +1,97 @@
+package unit_tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	queryTarget = "/api/v1/auth/relation_tuples?namespace=com.livspace.auth&relation=member&object=com.livspace.auth;bouncer;roles;BOUNCER_VIEWER&subject-id=com.livspace.auth;bouncer;users;9338"
+	listTarget  = "/api/v1/auth/relation_tuples/list?namespace=com.livspace.auth"
+)
+
+func withAccessRules(config *viper.Viper) {
+	config.Set("enforcement.permissions.query", "ozone:admin#query")
+	config.Set("enforcement.access", []map[string]interface{}{
+		{"route": "/api/v1/auth/expand", "public": true},
+		{"route": "/api/v1/auth/relation_tuples/list", "permission": "ozone:admin#list"},
+	})
+}
+
+func TestAccess(t *testing.T) {
+	h := newHarness(t, withAccessRules)
+	h.Keto.AddTuple(ozonetest.Tuple{Namespace: "ozone", Object: "admin", Relation: "query", SubjectId: "com.livspace.auth;bouncer;users;9338"})
+	h.Keto.AddTuple(ozonetest.Tuple{Namespace: "ozone", Object: "admin", Relation: "list", SubjectId: "com.livspace.auth;bouncer;users;6178"})
+
+	tests := map[string]struct {
+		target  string
+		token   string
+		status  int
+		message string
+	}{
+		"QueryWithoutToken": {
+			target:  queryTarget,
+			status:  http.StatusUnauthorized,
+			message: "Bearer token absent",
+		},
+		"QueryWithPermission": {
+			target: queryTarget,
+			token:  "bouncer-user-9338",
+			status: http.StatusOK,
+		},
+		"QueryWithoutPermission": {
+			target:  queryTarget,
+			token:   "bouncer-user-6178",
+			status:  http.StatusForbidden,
+			message: "Subject is not allowed to call /api/v1/auth/relation_tuples",
+		},
+		"QueryWithRevokedToken": {
+			target: queryTarget,
+			token:  "revoked",
+			status: http.StatusUnauthorized,
+		},
+		"PublicExpand": {
+			target: "/api/v1/auth/expand?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users",
+			status: http.StatusOK,
+		},
+		"ListWithRoutePermission": {
+			target: listTarget,
+			token:  "bouncer-user-6178",
+			status: http.StatusOK,
+		},
+		"ListWithQueryPermissionOnly": {
+			target:  listTarget,
+			token:   "bouncer-user-9338",
+			status:  http.StatusForbidden,
+			message: "Subject is not allowed to call /api/v1/auth/relation_tuples/list",
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.token != "" {
+				headers = ozonetest.Bearer(tt.token)
+			}
+			w := h.Do(http.MethodGet, tt.target, headers)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.message != "" {
+				assert.Equal(t, tt.message, errorMessage(t, w.Body.Bytes()))
+			}
+		})
+	}
+}
+
+func TestAccess_DefaultNeedsToken(t *testing.T) {
+	h := newHarness(t)
+
+	w := h.Do(http.MethodGet, "/api/v1/auth/expand?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = h.Do(http.MethodGet, "/api/v1/auth/expand?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users", ozonetest.Bearer("bouncer-user-6178"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
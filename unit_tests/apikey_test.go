@@ -0,0 +1,211 @@
+package unit_tests
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/services"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const partnerCheckTarget = checkTarget + "&issuer=partners"
+
+func writeKeyFile(t *testing.T, path string, content string) {
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+}
+
+func withKeyFile(path string) func(config *viper.Viper) {
+	return func(config *viper.Viper) {
+		config.Set("issuer.partners.type", services.APIKeyIssuerType)
+		config.Set("issuer.partners.source", services.FileKeySource)
+		config.Set("issuer.partners.file", path)
+	}
+}
+
+func TestAPIKey_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	writeKeyFile(t, path, `keys:
+  - hash: `+services.HashAPIKey("acme-key")+`
+    subject: com.livspace.auth;bouncer;users;9338
+    client_id: acme
+    scopes: [read, write]
+  - hash: `+services.HashAPIKey("expired-key")+`
+    subject: com.livspace.auth;bouncer;users;9338
+    client_id: acme
+    expires_at: 2020-01-01T00:00:00Z
+`)
+	h := newHarness(t, withKeyFile(path))
+
+	tests := map[string]struct {
+		target   string
+		key      string
+		expected expectation
+	}{
+		"ValidKey": {
+			target:   partnerCheckTarget,
+			key:      "acme-key",
+			expected: expectation{status: http.StatusOK, out: "\"com.livspace.auth;bouncer;users;9338\""},
+		},
+		"ExpiredKey": {
+			target:   partnerCheckTarget,
+			key:      "expired-key",
+			expected: expectation{status: http.StatusUnauthorized, out: "\"Invalid token\""},
+		},
+		"UnknownKey": {
+			target:   partnerCheckTarget,
+			key:      "forged-key",
+			expected: expectation{status: http.StatusUnauthorized, out: "\"Invalid token\""},
+		},
+		"OAuthTokenOnKeyIssuer": {
+			target:   partnerCheckTarget,
+			key:      "bouncer-user-9338",
+			expected: expectation{status: http.StatusUnauthorized, out: "\"Invalid token\""},
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			w := h.Do(http.MethodGet, tt.target, map[string]string{"Authorization": "Bearer " + tt.key})
+			assert.Equal(t, tt.expected.out, w.Body.String())
+			assert.Equal(t, tt.expected.status, w.Code)
+		})
+	}
+	assert.Equal(t, 0, h.Hydra.Calls())
+
+	t.Run("KeyOnHydraIssuer", func(t *testing.T) {
+		w := h.Do(http.MethodGet, checkTarget, map[string]string{"Authorization": "Bearer acme-key"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("IntrospectionResponse", func(t *testing.T) {
+		status, response, err := h.App.HydraService.Introspect(context.Background(), "partners", true, "Bearer acme-key")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "acme", response.ClientId)
+		assert.Equal(t, "read write", response.Scope)
+		assert.Equal(t, services.APIKeyTokenType, response.TokenType)
+		assert.True(t, response.Active)
+	})
+}
+
+func TestAPIKey_FileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	writeKeyFile(t, path, "keys: []\n")
+	h := newHarness(t, withKeyFile(path))
+
+	w := h.Do(http.MethodGet, partnerCheckTarget, map[string]string{"Authorization": "Bearer new-key"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	writeKeyFile(t, path, `keys:
+  - hash: `+services.HashAPIKey("rotated-key")+`
+    subject: com.livspace.auth;bouncer;users;9338
+`)
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, later, later))
+
+	w = h.Do(http.MethodGet, partnerCheckTarget, map[string]string{"Authorization": "Bearer rotated-key"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAPIKey_SQL(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "keys.db")
+	db, err := sql.Open("sqlite3", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`CREATE TABLE partner_keys (
+		key_hash TEXT PRIMARY KEY,
+		subject TEXT NOT NULL,
+		client_id TEXT,
+		scopes TEXT,
+		expires_at DATETIME,
+		last_used_at DATETIME
+	)`)
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO partner_keys (key_hash, subject, client_id, scopes, expires_at) VALUES ($1, $2, $3, $4, $5), ($6, $7, $8, $9, $10)",
+		services.HashAPIKey("acme-key"), "com.livspace.auth;bouncer;users;9338", "acme", "read", time.Now().Add(time.Hour).UTC(),
+		services.HashAPIKey("expired-key"), "com.livspace.auth;bouncer;users;9338", "acme", "read", time.Now().Add(-time.Hour).UTC())
+	require.NoError(t, err)
+
+	h := newHarness(t, func(config *viper.Viper) {
+		config.Set("issuer.partners.type", services.APIKeyIssuerType)
+		config.Set("issuer.partners.source", services.SQLKeySource)
+		config.Set("issuer.partners.sql.driver", "sqlite3")
+		config.Set("issuer.partners.sql.dsn", dsn)
+		config.Set("issuer.partners.sql.table", "partner_keys")
+	})
+
+	w := h.Do(http.MethodGet, partnerCheckTarget, map[string]string{"Authorization": "Bearer acme-key"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = h.Do(http.MethodGet, partnerCheckTarget, map[string]string{"Authorization": "Bearer expired-key"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	assert.Eventually(t, func() bool {
+		var lastUsed sql.NullTime
+		err := db.QueryRow("SELECT last_used_at FROM partner_keys WHERE key_hash = $1", services.HashAPIKey("acme-key")).Scan(&lastUsed)
+		return err == nil && lastUsed.Valid
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+func TestAPIKey_Config(t *testing.T) {
+	tests := map[string]struct {
+		settings map[string]interface{}
+		valid    bool
+	}{
+		"FileSource": {
+			settings: map[string]interface{}{"type": "apikey", "source": "file", "file": "/etc/app/keys.yaml"},
+			valid:    true,
+		},
+		"SQLSource": {
+			settings: map[string]interface{}{"type": "apikey", "source": "sql", "sql": map[string]interface{}{"dsn": "postgres://keys", "table": "auth.api_keys"}},
+			valid:    true,
+		},
+		"UnknownType": {
+			settings: map[string]interface{}{"type": "ldap"},
+		},
+		"UnknownSource": {
+			settings: map[string]interface{}{"type": "apikey", "source": "vault"},
+		},
+		"MissingFile": {
+			settings: map[string]interface{}{"type": "apikey", "source": "file"},
+		},
+		"InvalidTable": {
+			settings: map[string]interface{}{"type": "apikey", "source": "sql", "sql": map[string]interface{}{"dsn": "postgres://keys", "table": "keys; DROP TABLE keys"}},
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			config := viper.New()
+			config.Set("log.level", "info")
+			config.Set("issuer.bouncer.url", "http://localhost:4445")
+			config.Set("issuer.partners", tt.settings)
+			err := configs.Validate(config)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestAPIKey_UnavailableStore(t *testing.T) {
+	h := newHarness(t, withKeyFile(filepath.Join(t.TempDir(), "missing", "keys.yaml")))
+
+	w := h.Do(http.MethodGet, partnerCheckTarget, map[string]string{"Authorization": "Bearer acme-key"})
+	assert.Equal(t, http.StatusFailedDependency, w.Code)
+	assert.Equal(t, 0, h.Hydra.Calls())
+
+	status, _, err := h.App.HydraService.Introspect(context.Background(), "partners", true, "Bearer acme-key")
+	assert.Equal(t, http.StatusFailedDependency, status)
+	assert.EqualError(t, err, "Issuer is unavailable")
+}
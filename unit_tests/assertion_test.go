@@ -0,0 +1,206 @@
+package unit_tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/livspaceeng/ozone/internal/services"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withAssertions(settings map[string]interface{}) func(config *viper.Viper) {
+	return func(config *viper.Viper) {
+		config.Set("assertion.enabled", true)
+		config.Set("assertion.audience", "livspace-services")
+		for key, value := range settings {
+			config.Set("assertion."+key, value)
+		}
+	}
+}
+
+func jwks(t *testing.T, h *ozonetest.Harness) model.JSONWebKeySet {
+	w := h.Do(http.MethodGet, "/.well-known/jwks.json", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var set model.JSONWebKeySet
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &set))
+	return set
+}
+
+// verifyAssertion checks the ES256 signature of token against set, the way
+// a service behind ozone would, and returns its kid and claims.
+func verifyAssertion(t *testing.T, set model.JSONWebKeySet, token string) (string, model.Assertion) {
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+	var header map[string]string
+	decodeSegment(t, parts[0], &header)
+	assert.Equal(t, services.AssertionAlgorithm, header["alg"])
+
+	var key *model.JSONWebKey
+	for i := range set.Keys {
+		if set.Keys[i].Kid == header["kid"] {
+			key = &set.Keys[i]
+		}
+	}
+	require.NotNil(t, key, "kid %s is not published", header["kid"])
+	publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: coordinate(t, key.X), Y: coordinate(t, key.Y)}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	require.Len(t, signature, 64)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	require.True(t, ecdsa.Verify(publicKey, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])))
+
+	var claims model.Assertion
+	decodeSegment(t, parts[1], &claims)
+	return header["kid"], claims
+}
+
+func decodeSegment(t *testing.T, segment string, v interface{}) {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(decoded, v))
+}
+
+func coordinate(t *testing.T, encoded string) *big.Int {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	require.NoError(t, err)
+	return new(big.Int).SetBytes(decoded)
+}
+
+func TestAssertion_Check(t *testing.T) {
+	h := newHarness(t, withAssertions(map[string]interface{}{"single_replica": true}))
+
+	allowed := h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("bouncer-user-9338"))
+	require.Equal(t, http.StatusOK, allowed.Code)
+	token := allowed.Header().Get(services.DefaultAssertionHeader)
+	require.NotEmpty(t, token)
+
+	_, claims := verifyAssertion(t, jwks(t, h), token)
+	assert.Equal(t, "ozone", claims.Issuer)
+	assert.Equal(t, "livspace-services", claims.Audience)
+	assert.Equal(t, "com.livspace.auth;bouncer;users;9338", claims.Subject)
+	assert.Equal(t, services.DefaultIssuer, claims.TokenIssuer)
+	assert.Equal(t, model.SubjectSet{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get"}, claims.Permission)
+	assert.NotEmpty(t, claims.Id)
+	assert.InDelta(t, time.Now().Add(time.Minute).Unix(), claims.Expiry, 2)
+
+	t.Run("DeniedCheck", func(t *testing.T) {
+		denied := h.Do(http.MethodGet, "/api/v1/auth/check?namespace=com.livspace.auth&relation=post&object=com.livspace.auth;bouncer;users", ozonetest.Bearer("bouncer-user-9338"))
+		assert.Equal(t, http.StatusForbidden, denied.Code)
+		assert.Empty(t, denied.Header().Get(services.DefaultAssertionHeader))
+	})
+
+	t.Run("ExpiresWithToken", func(t *testing.T) {
+		w := h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("short-lived"))
+		require.Equal(t, http.StatusOK, w.Code)
+		_, claims := verifyAssertion(t, jwks(t, h), w.Header().Get(services.DefaultAssertionHeader))
+		assert.LessOrEqual(t, claims.Expiry, time.Now().Add(3*time.Second).Unix())
+	})
+}
+
+func TestAssertion_Disabled(t *testing.T) {
+	h := newHarness(t)
+
+	w := h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("bouncer-user-9338"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(services.DefaultAssertionHeader))
+	assert.Empty(t, jwks(t, h).Keys)
+}
+
+func TestAssertion_Rotation(t *testing.T) {
+	h := newHarness(t, withAssertions(map[string]interface{}{"rotation": 60, "ttl": 30, "single_replica": true}))
+
+	first := h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("bouncer-user-9338")).Header().Get(services.DefaultAssertionHeader)
+	h.Clock.Advance(61 * time.Second)
+	second := h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("bouncer-user-9338")).Header().Get(services.DefaultAssertionHeader)
+
+	set := jwks(t, h)
+	require.Len(t, set.Keys, 2)
+	firstKid, _ := verifyAssertion(t, set, first)
+	secondKid, claims := verifyAssertion(t, set, second)
+	assert.NotEqual(t, firstKid, secondKid)
+	assert.Equal(t, secondKid, set.Keys[0].Kid)
+	assert.Equal(t, h.Clock.Now().Add(30*time.Second).Unix(), claims.Expiry, "expiry follows the clock")
+
+	// A key retired more than ttl ago is dropped by the next rotation
+	h.Clock.Advance(61 * time.Second)
+	set = jwks(t, h)
+	require.Len(t, set.Keys, 2)
+	assert.NotEqual(t, secondKid, set.Keys[0].Kid)
+	assert.Equal(t, secondKid, set.Keys[1].Kid)
+}
+
+func TestAssertion_KeysDir(t *testing.T) {
+	dir := t.TempDir()
+	writeKey := func(name string, modTime time.Time) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	writeKey("previous.pem", time.Now().Add(-time.Hour))
+	writeKey("current.pem", time.Now())
+
+	h := newHarness(t, withAssertions(map[string]interface{}{"keys_dir": dir}))
+	set := jwks(t, h)
+	require.Len(t, set.Keys, 2)
+
+	w := h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("bouncer-user-9338"))
+	kid, _ := verifyAssertion(t, set, w.Header().Get(services.DefaultAssertionHeader))
+	assert.Equal(t, set.Keys[0].Kid, kid)
+}
+
+func TestAssertion_Config(t *testing.T) {
+	tests := map[string]struct {
+		settings map[string]interface{}
+		valid    bool
+	}{
+		"KeysDir": {
+			settings: map[string]interface{}{"keys_dir": t.TempDir()},
+			valid:    true,
+		},
+		"SingleReplica": {
+			settings: map[string]interface{}{"single_replica": true},
+			valid:    true,
+		},
+		"InMemoryKeysWithoutSingleReplica": {
+			settings: map[string]interface{}{},
+			valid:    false,
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			config := viper.New()
+			config.Set("log.level", "info")
+			config.Set("issuer.bouncer.url", "http://localhost:4445")
+			withAssertions(tt.settings)(config)
+			err := configs.Validate(config)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -2,39 +2,40 @@ package unit_tests
 
 import (
 	"net/http"
-	"net/http/httptest"
 	"testing"
 
-	"github.com/livspaceeng/ozone/internal/server"
-	"github.com/livspaceeng/ozone/internal/utils"
-	"github.com/livspaceeng/ozone/configs"
-	log "github.com/sirupsen/logrus"
+	"github.com/livspaceeng/ozone/ozonetest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-type input struct{
-	namespace string
-	relation string
-	object string
-	subjectId string
+type input struct {
+	namespace           string
+	relation            string
+	object              string
+	subjectId           string
 	subjectSetNamespace string
-	subjectSetObject string
-	subjectSetRelation string
-	authPrefix string
-	maxDepth string
+	subjectSetObject    string
+	subjectSetRelation  string
+	authPrefix          string
+	token               string
+	maxDepth            string
 }
 
-type expectation struct{
+type expectation struct {
 	status int
-	out string
-	err error
+	out    string
+	err    error
+}
+
+func newHarness(t *testing.T, opts ...ozonetest.ConfigOption) *ozonetest.Harness {
+	fixtures, err := ozonetest.LoadFixtures("testdata/fixtures.yaml")
+	require.NoError(t, err)
+	return ozonetest.New(t, fixtures, opts...)
 }
 
 func TestAuthController_Check(t *testing.T) {
-	configs.Init()
-	utils.Init()
-	config := configs.GetConfig()
-	r := server.NewRouter()
+	h := newHarness(t)
 
 	tests := map[string]struct {
 		in       input
@@ -42,65 +43,66 @@ func TestAuthController_Check(t *testing.T) {
 	}{
 		"ValidPolicy": {
 			in: input{
-				namespace: "com.livspace.auth",
-				relation: "get",
-				object: "com.livspace.auth;bouncer;users",
+				namespace:  "com.livspace.auth",
+				relation:   "get",
+				object:     "com.livspace.auth;bouncer;users",
 				authPrefix: "Bearer ",
+				token:      "bouncer-user-9338",
 			},
 			expected: expectation{
 				status: 200,
-				out: "\"com.livspace.auth;bouncer;users;9338\"",
-				err: nil,
-				},
+				out:    "\"com.livspace.auth;bouncer;users;9338\"",
+				err:    nil,
 			},
+		},
 		"InvalidPolicy": {
 			in: input{
-				namespace: "com.livspace.auth",
-				relation: "post",
-				object: "com.livspace.auth;bouncer;users",
+				namespace:  "com.livspace.auth",
+				relation:   "post",
+				object:     "com.livspace.auth;bouncer;users",
 				authPrefix: "Bearer ",
+				token:      "bouncer-user-9338",
 			},
 			expected: expectation{
 				status: 403,
-				out: "\"com.livspace.auth;bouncer;users;9338\"",
-				err: nil,
-				},
+				out:    "\"com.livspace.auth;bouncer;users;9338\"",
+				err:    nil,
 			},
+		},
 		"InvalidQueryParams": {
 			in: input{
-				namespace: "",
-				relation: "",
-				object: "",
+				namespace:  "",
+				relation:   "",
+				object:     "",
 				authPrefix: "Bearer ",
+				token:      "bouncer-user-9338",
 			},
 			expected: expectation{
 				status: 400,
-				out: "\"Invalid query params\"",
-				err: nil,
-				},
+				out:    "\"Invalid query params\"",
+				err:    nil,
 			},
+		},
 		"UnauthorizedRequest": {
 			in: input{
-				namespace: "com.livspace.auth",
-				relation: "get",
-				object: "com.livspace.auth;bouncer;users",
+				namespace:  "com.livspace.auth",
+				relation:   "get",
+				object:     "com.livspace.auth;bouncer;users",
 				authPrefix: "",
+				token:      "bouncer-user-9338",
 			},
 			expected: expectation{
 				status: 401,
-				out: "\"Authorization header format is not valid\"",
-				err: nil,
-				},
+				out:    "\"Authorization header format is not valid\"",
+				err:    nil,
 			},
+		},
 	}
 
 	for scenario, tt := range tests {
 		t.Run(scenario, func(t *testing.T) {
-			req, _ := http.NewRequest("GET", "/api/v1/auth/check?namespace="+tt.in.namespace+"&relation="+tt.in.relation+"&object="+tt.in.object, nil)
-			req.Header.Add("Authorization",tt.in.authPrefix+config.GetString("hydra.bouncer.access_token"))
-			w := httptest.NewRecorder()
-			r.ServeHTTP(w, req)
-			log.Info("Response: ", w.Body)
+			w := h.Do(http.MethodGet, "/api/v1/auth/check?namespace="+tt.in.namespace+"&relation="+tt.in.relation+"&object="+tt.in.object,
+				map[string]string{"Authorization": tt.in.authPrefix + tt.in.token})
 			assert.Equal(t, tt.expected.out, w.Body.String())
 			assert.Equal(t, tt.expected.status, w.Code)
 		})
@@ -108,8 +110,7 @@ func TestAuthController_Check(t *testing.T) {
 }
 
 func TestAuthController_Query(t *testing.T) {
-	configs.Init()
-	r := server.NewRouter()
+	h := newHarness(t)
 
 	tests := map[string]struct {
 		in       input
@@ -118,98 +119,96 @@ func TestAuthController_Query(t *testing.T) {
 		"QueryPolicy": {
 			in: input{
 				namespace: "com.livspace.auth",
-				relation: "member",
-				object: "com.livspace.auth;bouncer;roles;BOUNCER_VIEWER",
+				relation:  "member",
+				object:    "com.livspace.auth;bouncer;roles;BOUNCER_VIEWER",
 				subjectId: "com.livspace.auth;bouncer;users;9338",
 			},
 			expected: expectation{
 				status: 200,
-				out: "\"com.livspace.auth;bouncer;users;9338\"",
-				err: nil,
-				},
+				out:    "\"com.livspace.auth;bouncer;users;9338\"",
+				err:    nil,
 			},
+		},
 		"QueryInvalidPolicy": {
 			in: input{
 				namespace: "com.livspace.auth",
-				relation: "member",
-				object: "com.livspace.auth;bouncer;roles;BOUNCER_VIEWER",
+				relation:  "member",
+				object:    "com.livspace.auth;bouncer;roles;BOUNCER_VIEWER",
 				subjectId: "com.livspace.auth;bouncer;users;6178",
 			},
 			expected: expectation{
 				status: 403,
-				out: "\"com.livspace.auth;bouncer;users;6178\"",
-				err: nil,
-				},
+				out:    "\"com.livspace.auth;bouncer;users;6178\"",
+				err:    nil,
 			},
+		},
 		"QueryPolicyWithSet": {
 			in: input{
-				namespace: "com.livspace.auth",
-				relation: "get",
-				object: "com.livspace.auth;bouncer;users",
+				namespace:           "com.livspace.auth",
+				relation:            "get",
+				object:              "com.livspace.auth;bouncer;users",
 				subjectSetNamespace: "com.livspace.auth",
-				subjectSetRelation: "member",
-				subjectSetObject: "com.livspace.auth;bouncer;roles;BOUNCER_VIEWER",
+				subjectSetRelation:  "member",
+				subjectSetObject:    "com.livspace.auth;bouncer;roles;BOUNCER_VIEWER",
 			},
 			expected: expectation{
 				status: 200,
-				out: "\"Policy exists\"",
-				err: nil,
-				},
+				out:    "\"Policy exists\"",
+				err:    nil,
 			},
+		},
 		"QueryInvalidPolicyWithSet": {
 			in: input{
-				namespace: "com.livspace.auth",
-				relation: "get",
-				object: "com.livspace.auth;bouncer;user",
+				namespace:           "com.livspace.auth",
+				relation:            "get",
+				object:              "com.livspace.auth;bouncer;user",
 				subjectSetNamespace: "com.livspace.auth",
-				subjectSetRelation: "member",
-				subjectSetObject: "com.livspace.auth;bouncer;roles;BOUNCER_VIEWER",
+				subjectSetRelation:  "member",
+				subjectSetObject:    "com.livspace.auth;bouncer;roles;BOUNCER_VIEWER",
 			},
 			expected: expectation{
 				status: 403,
-				out: "\"Policy does not exist\"",
-				err: nil,
-				},
+				out:    "\"Policy does not exist\"",
+				err:    nil,
 			},
+		},
 		"InvalidQueryParams": {
 			in: input{
 				namespace: "",
-				relation: "",
-				object: "",
+				relation:  "",
+				object:    "",
 				subjectId: "com",
 			},
 			expected: expectation{
 				status: 400,
-				out: "\"Invalid query params\"",
-				err: nil,
-				},
+				out:    "\"Invalid query params\"",
+				err:    nil,
 			},
+		},
 		"InvalidQueryParamsWithSet": {
 			in: input{
-				namespace: "",
-				relation: "",
-				object: "",
+				namespace:           "",
+				relation:            "",
+				object:              "",
 				subjectSetNamespace: "",
-				subjectSetRelation: "",
-				subjectSetObject: "",
+				subjectSetRelation:  "",
+				subjectSetObject:    "",
 			},
 			expected: expectation{
 				status: 400,
-				out: "\"Invalid query params\"",
-				err: nil,
-				},
+				out:    "\"Invalid query params\"",
+				err:    nil,
 			},
+		},
 	}
 
 	for scenario, tt := range tests {
 		t.Run(scenario, func(t *testing.T) {
-			req, _ := http.NewRequest("GET", "/api/v1/auth/relation_tuples?namespace="+tt.in.namespace+"&relation="+tt.in.relation+"&object="+tt.in.object+"&subject_id="+tt.in.subjectId, nil)
+			target := "/api/v1/auth/relation_tuples?namespace=" + tt.in.namespace + "&relation=" + tt.in.relation + "&object=" + tt.in.object + "&subject-id=" + tt.in.subjectId
 			if len(tt.in.subjectId) == 0 {
-				req, _ = http.NewRequest("GET", "/api/v1/auth/relation_tuples?namespace="+tt.in.namespace+"&relation="+tt.in.relation+"&object="+tt.in.object+"&subject_set.namespace="+tt.in.subjectSetNamespace+"&subject_set.relation="+tt.in.subjectSetRelation+"&subject_set.object="+tt.in.subjectSetObject, nil)
+				target = "/api/v1/auth/relation_tuples?namespace=" + tt.in.namespace + "&relation=" + tt.in.relation + "&object=" + tt.in.object + "&subject-set-namespace=" + tt.in.subjectSetNamespace + "&subject-set-relation=" + tt.in.subjectSetRelation + "&subject-set-object=" + tt.in.subjectSetObject
 			}
-			w := httptest.NewRecorder()
-			r.ServeHTTP(w, req)
-			log.Info("Response: ", w.Body)
+			w := h.Do(http.MethodGet, target, ozonetest.Bearer("bouncer-user-9338"))
 			assert.Equal(t, tt.expected.out, w.Body.String())
 			assert.Equal(t, tt.expected.status, w.Code)
 		})
@@ -217,8 +216,7 @@ func TestAuthController_Query(t *testing.T) {
 }
 
 func TestAuthController_Expand(t *testing.T) {
-	configs.Init()
-	r := server.NewRouter()
+	h := newHarness(t)
 
 	tests := map[string]struct {
 		in       input
@@ -227,59 +225,102 @@ func TestAuthController_Expand(t *testing.T) {
 		"ExpandPolicy": {
 			in: input{
 				namespace: "com.livspace.auth",
-				relation: "get",
-				object: "com.livspace.auth;bouncer;users",
-				maxDepth: "2",
+				relation:  "get",
+				object:    "com.livspace.auth;bouncer;users",
+				maxDepth:  "2",
 			},
 			expected: expectation{
 				status: 200,
-				err: nil,
-				},
+				err:    nil,
 			},
+		},
 		"ExpandInvalidPolicy": {
 			in: input{
 				namespace: "com.livspace.auth",
-				relation: "get",
-				object: "com.livspace.auth;bouncer;user",
-				maxDepth: "1",
+				relation:  "get",
+				object:    "com.livspace.auth;bouncer;user",
+				maxDepth:  "1",
 			},
 			expected: expectation{
 				status: 404,
-				err: nil,
-				},
+				err:    nil,
 			},
+		},
 		"InvalidMaxDepth": {
 			in: input{
 				namespace: "com.livspace.auth",
-				relation: "get",
-				object: "com.livspace.auth;bouncer;users",
-				maxDepth: "abc",
+				relation:  "get",
+				object:    "com.livspace.auth;bouncer;users",
+				maxDepth:  "abc",
 			},
 			expected: expectation{
 				status: 400,
-				err: nil,
-				},
+				err:    nil,
 			},
+		},
 		"InvalidQueryParams": {
 			in: input{
 				namespace: "",
-				relation: "",
-				object: "",
-				maxDepth: "0",
+				relation:  "",
+				object:    "",
+				maxDepth:  "0",
 			},
 			expected: expectation{
 				status: 400,
-				err: nil,
-				},
+				err:    nil,
 			},
+		},
 	}
 
 	for scenario, tt := range tests {
 		t.Run(scenario, func(t *testing.T) {
-			req, _ := http.NewRequest("GET", "/api/v1/auth/expand?namespace="+tt.in.namespace+"&relation="+tt.in.relation+"&object="+tt.in.object+"&max-depth="+tt.in.maxDepth, nil)
-			w := httptest.NewRecorder()
-			r.ServeHTTP(w, req)
+			w := h.Do(http.MethodGet, "/api/v1/auth/expand?namespace="+tt.in.namespace+"&relation="+tt.in.relation+"&object="+tt.in.object+"&max-depth="+tt.in.maxDepth, ozonetest.Bearer("bouncer-user-9338"))
 			assert.Equal(t, tt.expected.status, w.Code)
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestAuthController_UpstreamFailures(t *testing.T) {
+	tests := map[string]struct {
+		hydraDown bool
+		ketoDown  bool
+		target    string
+		token     string
+		expected  expectation
+	}{
+		"HydraDown": {
+			hydraDown: true,
+			target:    "/api/v1/auth/check?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users",
+			token:     "bouncer-user-9338",
+			expected:  expectation{status: http.StatusFailedDependency},
+		},
+		"KetoDownOnCheck": {
+			ketoDown: true,
+			target:   "/api/v1/auth/check?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users",
+			token:    "bouncer-user-9338",
+			expected: expectation{status: http.StatusFailedDependency},
+		},
+		"KetoDownOnQuery": {
+			ketoDown: true,
+			token:    "bouncer-user-9338",
+			target:   "/api/v1/auth/relation_tuples?namespace=com.livspace.auth&relation=member&object=com.livspace.auth;bouncer;roles;BOUNCER_VIEWER&subject-id=com.livspace.auth;bouncer;users;9338",
+			expected: expectation{status: http.StatusFailedDependency},
+		},
+		"KetoDownOnExpand": {
+			ketoDown: true,
+			token:    "bouncer-user-9338",
+			target:   "/api/v1/auth/expand?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users&max-depth=2",
+			expected: expectation{status: http.StatusFailedDependency},
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			h := newHarness(t)
+			h.Hydra.SetFailing(tt.hydraDown)
+			h.Keto.SetFailing(tt.ketoDown)
+			w := h.Do(http.MethodGet, tt.target, ozonetest.Bearer(tt.token))
+			assert.Equal(t, tt.expected.status, w.Code)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package unit_tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const checkPath = "/api/v1/auth/check"
+
+func decodeDecision(t *testing.T, w *httptest.ResponseRecorder) model.Decision {
+	var decision model.Decision
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &decision))
+	return decision
+}
+
+func TestCheckDecision(t *testing.T) {
+	h := newHarness(t)
+	h.Keto.AddTuple(ozonetest.Tuple{Namespace: "com.livspace.auth", Object: "reports;region=south;q=1", Relation: "view", SubjectId: "com.livspace.auth;bouncer;users;9338"})
+
+	tests := map[string]struct {
+		body    model.CheckRequest
+		token   string
+		status  int
+		allowed bool
+		reason  string
+	}{
+		"Allowed": {
+			body:    model.CheckRequest{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get"},
+			token:   "bouncer-user-9338",
+			status:  http.StatusOK,
+			allowed: true,
+			reason:  "Subject has the permission",
+		},
+		"Denied": {
+			body:   model.CheckRequest{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "post"},
+			token:  "bouncer-user-9338",
+			status: http.StatusForbidden,
+			reason: "Subject does not have the permission",
+		},
+		"ObjectWithSeparators": {
+			body:    model.CheckRequest{Namespace: "com.livspace.auth", Object: "reports;region=south;q=1", Relation: "view"},
+			token:   "bouncer-user-9338",
+			status:  http.StatusOK,
+			allowed: true,
+			reason:  "Subject has the permission",
+		},
+		"WithContext": {
+			body:    model.CheckRequest{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get", Context: map[string]interface{}{"office": "bangalore"}},
+			token:   "bouncer-user-9338",
+			status:  http.StatusOK,
+			allowed: true,
+			reason:  "Subject has the permission",
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			w := h.DoJSON(http.MethodPost, checkPath, ozonetest.Bearer(tt.token), tt.body)
+			require.Equal(t, tt.status, w.Code)
+			decision := decodeDecision(t, w)
+			assert.Equal(t, tt.allowed, decision.Allowed)
+			assert.Equal(t, "enforce", decision.Mode)
+			assert.Equal(t, "com.livspace.auth;bouncer;users;9338", decision.Subject)
+			assert.Equal(t, "bouncer", decision.Issuer)
+			assert.Equal(t, model.SubjectSet{Namespace: tt.body.Namespace, Object: tt.body.Object, Relation: tt.body.Relation}, decision.Permission)
+			assert.Equal(t, tt.reason, decision.Reason)
+		})
+	}
+}
+
+func TestCheckDecision_Errors(t *testing.T) {
+	h := newHarness(t)
+
+	tests := map[string]struct {
+		body     string
+		headers  map[string]string
+		expected expectation
+	}{
+		"MalformedBody": {
+			body:     `{"namespace":`,
+			headers:  ozonetest.Bearer("bouncer-user-9338"),
+			expected: expectation{status: http.StatusBadRequest, out: "\"Invalid request body\""},
+		},
+		"MissingRelation": {
+			body:     `{"namespace":"com.livspace.auth","object":"com.livspace.auth;bouncer;users"}`,
+			headers:  ozonetest.Bearer("bouncer-user-9338"),
+			expected: expectation{status: http.StatusBadRequest, out: "\"Invalid request body\""},
+		},
+		"MissingToken": {
+			body:     `{"namespace":"com.livspace.auth","object":"com.livspace.auth;bouncer;users","relation":"get"}`,
+			headers:  map[string]string{},
+			expected: expectation{status: http.StatusUnauthorized, out: "\"Bearer token absent\""},
+		},
+		"UnknownIssuerToken": {
+			body:     `{"namespace":"com.livspace.auth","object":"com.livspace.auth;bouncer;users","relation":"get","issuer":"accounts"}`,
+			headers:  ozonetest.Bearer("unknown"),
+			expected: expectation{status: http.StatusUnauthorized, out: "\"Invalid token\""},
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, checkPath, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expected.out, w.Body.String())
+			assert.Equal(t, tt.expected.status, w.Code)
+		})
+	}
+
+	t.Run("KetoDown", func(t *testing.T) {
+		h.Keto.SetFailing(true)
+		defer h.Keto.SetFailing(false)
+		w := h.DoJSON(http.MethodPost, checkPath, ozonetest.Bearer("bouncer-user-9338"), model.CheckRequest{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get"})
+		assert.Equal(t, http.StatusFailedDependency, w.Code)
+	})
+}
+
+func TestCheckDecision_ShadowMode(t *testing.T) {
+	h := newHarness(t, withEnforcementRules(map[string]interface{}{"namespace": "com.livspace.auth", "mode": "shadow"}))
+
+	w := h.DoJSON(http.MethodPost, checkPath, ozonetest.Bearer("bouncer-user-6178"), model.CheckRequest{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get"})
+	require.Equal(t, http.StatusOK, w.Code)
+	decision := decodeDecision(t, w)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, "shadow", decision.Mode)
+	assert.Equal(t, "Not enforced in shadow mode, the check answered 403", decision.Reason)
+	assert.Equal(t, "deny", w.Header().Get("X-Ozone-Shadow-Decision"))
+}
@@ -0,0 +1,51 @@
+package unit_tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// leaderGivesUp serves a check whose client goes away after 50ms and, while
+// its upstream call is in flight, a follower check of the same token and
+// tuple. It returns the status the follower got.
+func leaderGivesUp(h *ozonetest.Harness) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		req := httptest.NewRequest(http.MethodGet, checkTarget, nil).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer bouncer-user-9338")
+		h.Router.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	follower := h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("bouncer-user-9338"))
+	wg.Wait()
+	return follower.Code
+}
+
+func TestCoalescing_LeaderGivesUp(t *testing.T) {
+	t.Run("Hydra", func(t *testing.T) {
+		h := newHarness(t)
+		h.Hydra.SetDelay(200 * time.Millisecond)
+		assert.Equal(t, http.StatusOK, leaderGivesUp(h))
+		assert.Equal(t, 1, h.Hydra.Calls())
+	})
+
+	t.Run("Keto", func(t *testing.T) {
+		h := newHarness(t)
+		assert.Equal(t, http.StatusOK, h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("bouncer-user-9338")).Code)
+		calls := h.Keto.Calls()
+		h.Keto.SetDelay(200 * time.Millisecond)
+		assert.Equal(t, http.StatusOK, leaderGivesUp(h))
+		assert.Equal(t, calls+1, h.Keto.Calls())
+	})
+}
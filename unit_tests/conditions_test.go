@@ -0,0 +1,186 @@
+package unit_tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/livspaceeng/ozone/internal/services"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withConditions(conditions ...map[string]interface{}) func(config *viper.Viper) {
+	return func(config *viper.Viper) {
+		config.Set("conditions", conditions)
+	}
+}
+
+func TestConditions_Check(t *testing.T) {
+	h := newHarness(t, withConditions(
+		map[string]interface{}{"name": "office", "namespace": "com.livspace.auth", "relation": "get", "expression": `context.office == "bangalore" && request.headers["x-channel"] == "web"`},
+		map[string]interface{}{"name": "network", "namespace": "com.livspace.auth", "expression": `inCidr(request.ip, "192.0.2.0/24") && token.sub.startsWith("com.livspace.auth;bouncer")`},
+	))
+	h.Keto.AddTuple(ozonetest.Tuple{Namespace: "com.livspace.auth", Object: "reports", Relation: "view", SubjectId: "com.livspace.auth;bouncer;users;9338"})
+	h.Keto.AddTuple(ozonetest.Tuple{Namespace: "com.livspace.auth", Object: "reports", Relation: "export", SubjectId: "com.livspace.auth;bouncer;users;9338"})
+
+	tests := map[string]struct {
+		body      model.CheckRequest
+		channel   string
+		status    int
+		condition *model.ConditionResult
+		reason    string
+	}{
+		"Met": {
+			body:      model.CheckRequest{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get", Context: map[string]interface{}{"office": "bangalore"}},
+			channel:   "web",
+			status:    http.StatusOK,
+			condition: &model.ConditionResult{Name: "office", Result: services.ConditionMet},
+			reason:    "Subject has the permission",
+		},
+		"NotMet": {
+			body:      model.CheckRequest{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get", Context: map[string]interface{}{"office": "mumbai"}},
+			channel:   "web",
+			status:    http.StatusForbidden,
+			condition: &model.ConditionResult{Name: "office", Result: services.ConditionNotMet},
+			reason:    "Condition office is not met",
+		},
+		"NamespaceWide": {
+			body:      model.CheckRequest{Namespace: "com.livspace.auth", Object: "reports", Relation: "view"},
+			status:    http.StatusOK,
+			condition: &model.ConditionResult{Name: "network", Result: services.ConditionMet},
+			reason:    "Subject has the permission",
+		},
+		"NotEvaluatedWhenKetoDenies": {
+			body:   model.CheckRequest{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "post", Context: map[string]interface{}{"office": "bangalore"}},
+			status: http.StatusForbidden,
+			reason: "Subject does not have the permission",
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			headers := ozonetest.Bearer("bouncer-user-9338")
+			headers["X-Channel"] = tt.channel
+			w := h.DoJSON(http.MethodPost, checkPath, headers, tt.body)
+			require.Equal(t, tt.status, w.Code)
+			decision := decodeDecision(t, w)
+			assert.Equal(t, tt.status == http.StatusOK, decision.Allowed)
+			assert.Equal(t, tt.condition, decision.Condition)
+			assert.Equal(t, tt.reason, decision.Reason)
+		})
+	}
+
+	t.Run("EvaluationErrorDenies", func(t *testing.T) {
+		w := h.DoJSON(http.MethodPost, checkPath, ozonetest.Bearer("bouncer-user-9338"), model.CheckRequest{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get"})
+		require.Equal(t, http.StatusForbidden, w.Code)
+		decision := decodeDecision(t, w)
+		assert.False(t, decision.Allowed)
+		require.NotNil(t, decision.Condition)
+		assert.Equal(t, services.ConditionError, decision.Condition.Result)
+		assert.NotEmpty(t, decision.Condition.Error)
+		assert.Equal(t, "Condition office could not be evaluated", decision.Reason)
+	})
+
+	t.Run("CheckOverGET", func(t *testing.T) {
+		denied := h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("bouncer-user-9338"))
+		assert.Equal(t, http.StatusForbidden, denied.Code)
+		assert.Equal(t, "\"Condition office could not be evaluated\"", denied.Body.String())
+		assert.Equal(t, http.StatusOK, h.Do(http.MethodGet, "/api/v1/auth/check?namespace=com.livspace.auth&relation=export&object=reports", ozonetest.Bearer("bouncer-user-9338")).Code)
+	})
+}
+
+// request.time is the time of the App clock when the check is received.
+func TestConditions_TimeOfDay(t *testing.T) {
+	h := newHarness(t, withConditions(
+		map[string]interface{}{"name": "office_hours", "namespace": "com.livspace.auth", "relation": "get", "expression": `request.time.getHours("UTC") >= 9 && request.time.getHours("UTC") < 18`},
+	))
+	untilHour := func(hour int) time.Duration {
+		now := h.Clock.Now().UTC()
+		next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+		if !next.After(now) {
+			next = next.Add(24 * time.Hour)
+		}
+		return next.Sub(now)
+	}
+	check := func() *httptest.ResponseRecorder {
+		return h.DoJSON(http.MethodPost, checkPath, ozonetest.Bearer("bouncer-user-9338"), model.CheckRequest{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get"})
+	}
+
+	h.Clock.Advance(untilHour(20))
+	w := check()
+	require.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, &model.ConditionResult{Name: "office_hours", Result: services.ConditionNotMet}, decodeDecision(t, w).Condition)
+
+	h.Clock.Advance(untilHour(10))
+	w = check()
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, &model.ConditionResult{Name: "office_hours", Result: services.ConditionMet}, decodeDecision(t, w).Condition)
+}
+
+func TestConditions_ShadowMode(t *testing.T) {
+	h := newHarness(t,
+		withConditions(map[string]interface{}{"name": "office", "namespace": "com.livspace.auth", "relation": "get", "expression": `context.office == "bangalore"`}),
+		withEnforcementRules(map[string]interface{}{"namespace": "com.livspace.auth", "mode": "shadow"}),
+	)
+
+	w := h.DoJSON(http.MethodPost, checkPath, ozonetest.Bearer("bouncer-user-9338"), model.CheckRequest{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get", Context: map[string]interface{}{"office": "mumbai"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	decision := decodeDecision(t, w)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, &model.ConditionResult{Name: "office", Result: services.ConditionNotMet}, decision.Condition)
+	assert.Equal(t, "deny", w.Header().Get("X-Ozone-Shadow-Decision"))
+}
+
+func TestConditions_Config(t *testing.T) {
+	tests := map[string]struct {
+		conditions []map[string]interface{}
+		valid      bool
+	}{
+		"Valid": {
+			conditions: []map[string]interface{}{
+				{"name": "office", "namespace": "com.livspace.auth", "relation": "get", "expression": `context.office in ["bangalore", "mumbai"]`},
+				{"name": "hours", "namespace": "com.livspace.auth", "expression": `request.time.getHours("Asia/Kolkata") < 20`},
+			},
+			valid: true,
+		},
+		"SyntaxError": {
+			conditions: []map[string]interface{}{{"name": "office", "namespace": "com.livspace.auth", "expression": `context.office ==`}},
+		},
+		"UnknownVariable": {
+			conditions: []map[string]interface{}{{"name": "office", "namespace": "com.livspace.auth", "expression": `office == "bangalore"`}},
+		},
+		"NotBool": {
+			conditions: []map[string]interface{}{{"name": "office", "namespace": "com.livspace.auth", "expression": `permission.object + "x"`}},
+		},
+		"Duplicate": {
+			conditions: []map[string]interface{}{
+				{"name": "office", "namespace": "com.livspace.auth", "relation": "get", "expression": `true`},
+				{"name": "network", "namespace": "com.livspace.auth", "relation": "get", "expression": `false`},
+			},
+		},
+		"MissingNamespace": {
+			conditions: []map[string]interface{}{{"name": "office", "expression": `true`}},
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			config := viper.New()
+			config.Set("log.level", "info")
+			config.Set("issuer.bouncer.url", "http://localhost:4445")
+			config.Set("conditions", tt.conditions)
+			err := configs.Validate(config)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
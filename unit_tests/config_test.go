@@ -0,0 +1,121 @@
+package unit_tests
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConfig_Shipped loads the config shipped with the image, which every
+// other test replaces with one built in code.
+func TestConfig_Shipped(t *testing.T) {
+	config := viper.New()
+	config.SetConfigFile("../configs/config.yaml")
+	require.NoError(t, config.ReadInConfig())
+	require.NoError(t, configs.Validate(config))
+}
+
+// reloadConfig reloads a copy of the current config of the harness with
+// adjust applied to it.
+func reloadConfig(h *ozonetest.Harness, adjust func(config *viper.Viper)) error {
+	config := viper.New()
+	for key, value := range h.Store.Get().AllSettings() {
+		config.Set(key, value)
+	}
+	adjust(config)
+	return h.Store.Reload(config)
+}
+
+func TestStore_InvalidReloadKeepsConfig(t *testing.T) {
+	h := newHarness(t)
+	previous := h.Store.Get()
+	reloads := 0
+	h.Store.OnReload(func(config *viper.Viper) { reloads++ })
+
+	assert.Error(t, reloadConfig(h, func(config *viper.Viper) {
+		config.Set("issuer.bouncer.type", "saml")
+	}))
+	assert.Same(t, previous, h.Store.Get())
+	assert.Equal(t, 0, reloads)
+
+	w := h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("bouncer-user-9338"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, h.Hydra.Calls())
+}
+
+func TestStore_ReloadNotifiesSubscribers(t *testing.T) {
+	h := newHarness(t)
+	var seen []*viper.Viper
+	h.Store.OnReload(func(config *viper.Viper) {
+		// Subscribers run once the new config is served
+		assert.Same(t, config, h.Store.Get())
+		seen = append(seen, config)
+	})
+
+	require.NoError(t, reloadConfig(h, func(config *viper.Viper) {
+		config.Set("ratelimit.max_invalid_tokens", 7)
+	}))
+	require.Len(t, seen, 1)
+	assert.Equal(t, 7, seen[0].GetInt("ratelimit.max_invalid_tokens"))
+}
+
+// An issuer and its timeout are swapped in together, while requests keep
+// being served.
+func TestStore_ReloadSwapsIssuersAndTimeouts(t *testing.T) {
+	vendors := ozonetest.NewFakeHydra([]ozonetest.Token{
+		{Token: "vendors-user-9338", Subject: "com.livspace.auth;bouncer;users;9338", ClientId: "vendors-web", ExpiresIn: 3600},
+	})
+	t.Cleanup(vendors.Close)
+	vendors.SetDelay(1200 * time.Millisecond)
+	h := newHarness(t)
+
+	// Unknown issuers resolve to the default one, which does not know the token
+	status, _, _ := h.App.HydraService.Introspect(context.Background(), "vendors", true, "Bearer vendors-user-9338")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				status, _, err := h.App.HydraService.Introspect(context.Background(), "bouncer", true, "Bearer bouncer-user-9338")
+				assert.Equal(t, http.StatusOK, status)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, reloadConfig(h, func(config *viper.Viper) {
+			config.Set("issuer.vendors.url", vendors.URL)
+			config.Set("issuer.vendors.path.introspect", ozonetest.IntrospectPath)
+			config.Set("issuer.vendors.timeout", 1)
+		}))
+	}
+	close(done)
+	wg.Wait()
+
+	status, _, _ = h.App.HydraService.Introspect(context.Background(), "vendors", true, "Bearer vendors-user-9338")
+	assert.NotEqual(t, http.StatusOK, status, "1s timeout")
+	require.NoError(t, reloadConfig(h, func(config *viper.Viper) {
+		config.Set("issuer.vendors.timeout", 2)
+	}))
+	status, response, err := h.App.HydraService.Introspect(context.Background(), "vendors", true, "Bearer vendors-user-9338")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "vendors-web", response.ClientId)
+}
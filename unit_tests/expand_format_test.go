@@ -0,0 +1,90 @@
+package unit_tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/livspaceeng/ozone/internal/services"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const expandTarget = "/api/v1/auth/expand?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users&max-depth=3"
+
+func TestAuthController_ExpandTree(t *testing.T) {
+	h := newHarness(t)
+
+	w := h.Do(http.MethodGet, expandTarget, ozonetest.Bearer("bouncer-user-9338"))
+	require.Equal(t, http.StatusOK, w.Code)
+	var tree model.ExpandTree
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tree))
+	assert.Equal(t, services.UnionNode, tree.Type)
+	require.NotNil(t, tree.Tuple)
+	require.NotNil(t, tree.Tuple.SubjectSet)
+	assert.Equal(t, "com.livspace.auth;bouncer;users", tree.Tuple.SubjectSet.Object)
+}
+
+func TestAuthController_ExpandFlat(t *testing.T) {
+	h := newHarness(t)
+
+	w := h.Do(http.MethodGet, expandTarget+"&format=flat", ozonetest.Bearer("bouncer-user-9338"))
+	require.Equal(t, http.StatusOK, w.Code)
+	var flat model.FlatExpandTree
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &flat))
+
+	var ids []string
+	for _, subject := range flat.Subjects {
+		if subject.SubjectId != "" {
+			ids = append(ids, subject.SubjectId)
+		}
+	}
+	assert.Contains(t, ids, "com.livspace.auth;bouncer;users;9338")
+	for _, subject := range flat.Subjects {
+		if subject.SubjectId == "com.livspace.auth;bouncer;users;9338" {
+			require.NotEmpty(t, subject.Path)
+			assert.Equal(t, "com.livspace.auth;bouncer;users", subject.Path[0].Object)
+		}
+	}
+}
+
+func TestAuthController_ExpandDot(t *testing.T) {
+	h := newHarness(t)
+
+	w := h.Do(http.MethodGet, expandTarget+"&format=dot", ozonetest.Bearer("bouncer-user-9338"))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/vnd.graphviz; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "digraph expand {")
+	assert.Contains(t, w.Body.String(), `"com.livspace.auth;bouncer;users;9338" [label="com.livspace.auth;bouncer;users;9338", shape=ellipse];`)
+}
+
+func TestAuthController_ExpandInvalidFormat(t *testing.T) {
+	h := newHarness(t)
+
+	w := h.Do(http.MethodGet, expandTarget+"&format=xml", ozonetest.Bearer("bouncer-user-9338"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "\"Invalid query params\"", w.Body.String())
+}
+
+func TestFlattenExpandTree_Exclusion(t *testing.T) {
+	leaf := func(id string) model.ExpandTree {
+		return model.ExpandTree{Type: services.LeafNode, Tuple: &model.RelationTuple{SubjectId: id}}
+	}
+	union := func(children ...model.ExpandTree) model.ExpandTree {
+		return model.ExpandTree{Type: services.UnionNode, Children: children}
+	}
+	tree := model.ExpandTree{
+		Type: services.ExclusionNode,
+		Children: []model.ExpandTree{
+			union(leaf("alice"), leaf("bob"), leaf("alice")),
+			union(leaf("bob")),
+		},
+	}
+
+	flat, err := services.FlattenExpandTree(tree)
+	require.NoError(t, err)
+	require.Len(t, flat.Subjects, 1)
+	assert.Equal(t, "alice", flat.Subjects[0].SubjectId)
+}
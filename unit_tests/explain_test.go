@@ -0,0 +1,107 @@
+package unit_tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/livspaceeng/ozone/internal/utils"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const explainTarget = "/api/v1/auth/explain?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users"
+
+func withAdminPermission(config *viper.Viper) {
+	config.Set("enforcement.permissions.admin", "ozone:admin#member")
+}
+
+func grantAdmin(h *ozonetest.Harness) {
+	h.Keto.AddTuple(ozonetest.Tuple{Namespace: "ozone", Object: "admin", Relation: "member", SubjectId: "com.livspace.auth;bouncer;users;9338"})
+}
+
+func TestAuthController_Explain(t *testing.T) {
+	users := model.SubjectSet{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get"}
+	viewers := model.SubjectSet{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;roles;BOUNCER_VIEWER", Relation: "member"}
+
+	for _, protocol := range []string{utils.RestProtocol, utils.GrpcProtocol} {
+		t.Run(protocol, func(t *testing.T) {
+			h := newHarness(t, withAdminPermission, func(config *viper.Viper) {
+				config.Set("keto.read.protocol", protocol)
+			})
+			grantAdmin(h)
+
+			t.Run("Allowed", func(t *testing.T) {
+				w := h.Do(http.MethodGet, explainTarget, ozonetest.Bearer("bouncer-user-9338"))
+				require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+				var explanation model.Explanation
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &explanation))
+				assert.True(t, explanation.Allowed)
+				assert.Equal(t, "com.livspace.auth;bouncer;users;9338", explanation.SubjectId)
+				assert.Equal(t, []model.SubjectSet{users, viewers}, explanation.SubjectSets)
+				assert.Equal(t, []model.RelationTuple{
+					{Namespace: users.Namespace, Object: users.Object, Relation: users.Relation, SubjectSet: &viewers},
+					{Namespace: viewers.Namespace, Object: viewers.Object, Relation: viewers.Relation, SubjectId: "com.livspace.auth;bouncer;users;9338"},
+				}, explanation.Tuples)
+			})
+
+			t.Run("Denied", func(t *testing.T) {
+				w := h.Do(http.MethodGet, explainTarget+"&subject_id=com.livspace.auth;bouncer;users;6178", ozonetest.Bearer("bouncer-user-9338"))
+				require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+				var explanation model.Explanation
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &explanation))
+				assert.False(t, explanation.Allowed)
+				assert.Empty(t, explanation.Tuples)
+				assert.Empty(t, explanation.SubjectSets)
+				assert.Equal(t, "Subject has no grant path to the permission", explanation.Reason)
+			})
+
+			t.Run("NotAdmin", func(t *testing.T) {
+				w := h.Do(http.MethodGet, explainTarget, ozonetest.Bearer("bouncer-user-6178"))
+				assert.Equal(t, http.StatusForbidden, w.Code)
+				assert.Equal(t, "\"Subject is not allowed to explain decisions\"", w.Body.String())
+			})
+
+			t.Run("InvalidQueryParams", func(t *testing.T) {
+				w := h.Do(http.MethodGet, "/api/v1/auth/explain?namespace=com.livspace.auth", ozonetest.Bearer("bouncer-user-9338"))
+				assert.Equal(t, http.StatusBadRequest, w.Code)
+				assert.Equal(t, "\"Invalid query params\"", w.Body.String())
+			})
+		})
+	}
+}
+
+func TestAuthController_ExplainFollowsUnexpandedSubjectSets(t *testing.T) {
+	h := newHarness(t, withAdminPermission)
+	grantAdmin(h)
+	// A chain of teams deeper than Keto's max depth, so expand leaves the
+	// last teams unexpanded and check denies.
+	member := ozonetest.SubjectSet{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;roles;BOUNCER_VIEWER", Relation: "member"}
+	for i := 1; i <= 6; i++ {
+		team := ozonetest.SubjectSet{Namespace: "com.livspace.auth", Object: fmt.Sprintf("com.livspace.auth;bouncer;teams;%d", i), Relation: "member"}
+		h.Keto.AddTuple(ozonetest.Tuple{Namespace: member.Namespace, Object: member.Object, Relation: member.Relation, SubjectSet: &team})
+		member = team
+	}
+	h.Keto.AddTuple(ozonetest.Tuple{Namespace: member.Namespace, Object: member.Object, Relation: member.Relation, SubjectId: "com.livspace.auth;bouncer;users;4242"})
+
+	w := h.Do(http.MethodGet, explainTarget+"&subject_id=com.livspace.auth;bouncer;users;4242", ozonetest.Bearer("bouncer-user-9338"))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var explanation model.Explanation
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &explanation))
+	assert.False(t, explanation.Allowed)
+	require.Len(t, explanation.SubjectSets, 8)
+	assert.Equal(t, "com.livspace.auth;bouncer;teams;6", explanation.SubjectSets[7].Object)
+	assert.Len(t, explanation.Tuples, 8)
+}
+
+func TestAuthController_ExplainNotEnabled(t *testing.T) {
+	h := newHarness(t)
+
+	w := h.Do(http.MethodGet, explainTarget, ozonetest.Bearer("bouncer-user-9338"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "\"Explain is not enabled\"", w.Body.String())
+}
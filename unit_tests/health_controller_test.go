@@ -5,14 +5,16 @@ import (
 	"net/http/httptest"
 	"testing"
 
-	"github.com/livspaceeng/ozone/internal/server"
 	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/server"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestHealthController_Status(t *testing.T) {
-	configs.Init()
-	r := server.NewRouter()
+	app, err := server.NewApp(configs.NewStore(viper.New()))
+	assert.NoError(t, err)
+	r := server.NewRouter(app)
 
 	req, _ := http.NewRequest("GET", "/health", nil)
 
@@ -20,4 +22,4 @@ func TestHealthController_Status(t *testing.T) {
 	r.ServeHTTP(w, req)
 	assert.Equal(t, "OK!", w.Body.String())
 	assert.Equal(t, http.StatusOK, w.Code)
-}
\ No newline at end of file
+}
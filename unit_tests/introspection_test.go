@@ -0,0 +1,157 @@
+package unit_tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/internal/services"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const keycloakCheckTarget = checkTarget + "&issuer=keycloak"
+
+func newIntrospectionHarness(t *testing.T, settings map[string]interface{}) (*ozonetest.Harness, *ozonetest.FakeIntrospection) {
+	server := ozonetest.NewFakeIntrospection("ozone", "s3cret")
+	t.Cleanup(server.Close)
+	h := newHarness(t, func(config *viper.Viper) {
+		config.Set("issuer.keycloak.type", services.IntrospectionIssuerType)
+		config.Set("issuer.keycloak.url", server.URL+ozonetest.RealmPath)
+		config.Set("issuer.keycloak.client_id", "ozone")
+		config.Set("issuer.keycloak.client_secret", "s3cret")
+		for key, value := range settings {
+			config.Set("issuer.keycloak."+key, value)
+		}
+	})
+	return h, server
+}
+
+func TestIntrospection_Discovery(t *testing.T) {
+	h, server := newIntrospectionHarness(t, map[string]interface{}{"discovery": true})
+	server.AddToken("kc-9338", map[string]interface{}{
+		"sub":       "com.livspace.auth;bouncer;users;9338",
+		"client_id": "partner-portal",
+		"scope":     "openid profile",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	})
+
+	for i := 0; i < 2; i++ {
+		w := h.Do(http.MethodGet, keycloakCheckTarget, ozonetest.Bearer("kc-9338"))
+		assert.Equal(t, "\"com.livspace.auth;bouncer;users;9338\"", w.Body.String())
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, 1, server.Calls())
+	assert.Equal(t, 1, server.Discoveries())
+	assert.Equal(t, services.ClientSecretBasic, server.AuthMethod())
+	assert.Equal(t, 0, h.Hydra.Calls())
+
+	t.Run("InactiveToken", func(t *testing.T) {
+		w := h.Do(http.MethodGet, keycloakCheckTarget, ozonetest.Bearer("revoked"))
+		assert.Equal(t, "\"Invalid token\"", w.Body.String())
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("TokenOnOtherIssuer", func(t *testing.T) {
+		w := h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("kc-9338"))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestIntrospection_ClaimMapping(t *testing.T) {
+	h, server := newIntrospectionHarness(t, map[string]interface{}{
+		"auth_method":      services.ClientSecretPost,
+		"path":             map[string]interface{}{"introspect": ozonetest.RealmIntrospectPath},
+		"claims.subject":   "ext.uid",
+		"claims.scope":     "scp",
+		"claims.expiry":    "expires_at",
+		"claims.client_id": "azp",
+	})
+	expiry := time.Now().Add(time.Hour).Unix()
+	server.AddToken("auth0-9338", map[string]interface{}{
+		"sub":        "auth0|abc",
+		"ext":        map[string]interface{}{"uid": "com.livspace.auth;bouncer;users;9338"},
+		"scp":        []string{"read:users", "write:users"},
+		"azp":        "legacy-app",
+		"expires_at": expiry,
+	})
+
+	status, response, err := h.App.HydraService.Introspect(context.Background(), "keycloak", true, "Bearer auth0-9338")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "com.livspace.auth;bouncer;users;9338", response.Subject)
+	assert.Equal(t, "read:users write:users", response.Scope)
+	assert.Equal(t, "legacy-app", response.ClientId)
+	assert.Equal(t, int(expiry), response.Expiry)
+	assert.Equal(t, services.ClientSecretPost, server.AuthMethod())
+	assert.Equal(t, 0, server.Discoveries())
+}
+
+func TestIntrospection_UpstreamFailures(t *testing.T) {
+	tests := map[string]struct {
+		settings map[string]interface{}
+	}{
+		"WrongClientSecret": {
+			settings: map[string]interface{}{"discovery": true, "client_secret": "wrong"},
+		},
+		"NoDiscoveryDocument": {
+			settings: map[string]interface{}{"discovery": true, "url": "http://127.0.0.1:1/realms/none"},
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			h, server := newIntrospectionHarness(t, tt.settings)
+			server.AddToken("kc-9338", map[string]interface{}{"sub": "com.livspace.auth;bouncer;users;9338"})
+			w := h.Do(http.MethodGet, keycloakCheckTarget, ozonetest.Bearer("kc-9338"))
+			assert.Equal(t, http.StatusFailedDependency, w.Code)
+		})
+	}
+}
+
+func TestIntrospection_Config(t *testing.T) {
+	tests := map[string]struct {
+		settings map[string]interface{}
+		valid    bool
+	}{
+		"Discovery": {
+			settings: map[string]interface{}{"type": "introspection", "url": "https://sso.example.com/realms/partners", "client_id": "ozone", "discovery": true},
+			valid:    true,
+		},
+		"ExplicitPathWithPost": {
+			settings: map[string]interface{}{"type": "introspection", "url": "https://example.auth0.com", "client_id": "ozone", "auth_method": "client_secret_post", "path": map[string]interface{}{"introspect": "/oauth/introspect"}},
+			valid:    true,
+		},
+		"NoEndpoint": {
+			settings: map[string]interface{}{"type": "introspection", "url": "https://sso.example.com", "client_id": "ozone"},
+		},
+		"NoClient": {
+			settings: map[string]interface{}{"type": "introspection", "url": "https://sso.example.com", "discovery": true},
+		},
+		"InvalidUrl": {
+			settings: map[string]interface{}{"type": "introspection", "url": "sso", "client_id": "ozone", "discovery": true},
+		},
+		"UnknownAuthMethod": {
+			settings: map[string]interface{}{"type": "introspection", "url": "https://sso.example.com", "client_id": "ozone", "discovery": true, "auth_method": "private_key_jwt"},
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			config := viper.New()
+			config.Set("log.level", "info")
+			config.Set("issuer.bouncer.url", "http://localhost:4445")
+			config.Set("issuer.sso", tt.settings)
+			err := configs.Validate(config)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package unit_tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/livspaceeng/ozone/internal/utils"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestKetoService_Conformance runs the same requests through the REST and
+// gRPC Keto services against the same fake Keto and expects identical
+// responses.
+func TestKetoService_Conformance(t *testing.T) {
+	requests := map[string]struct {
+		target  string
+		headers map[string]string
+	}{
+		"CheckAllowed": {
+			target:  "/api/v1/auth/check?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users",
+			headers: ozonetest.Bearer("bouncer-user-9338"),
+		},
+		"CheckForbidden": {
+			target:  "/api/v1/auth/check?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users",
+			headers: ozonetest.Bearer("bouncer-user-6178"),
+		},
+		"QuerySubjectId": {
+			target:  "/api/v1/auth/relation_tuples?namespace=com.livspace.auth&relation=member&object=com.livspace.auth;bouncer;roles;BOUNCER_VIEWER&subject-id=com.livspace.auth;bouncer;users;9338",
+			headers: ozonetest.Bearer("bouncer-user-9338"),
+		},
+		"QuerySubjectSet": {
+			target:  "/api/v1/auth/relation_tuples?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users&subject-set-namespace=com.livspace.auth&subject-set-object=com.livspace.auth;bouncer;roles;BOUNCER_VIEWER&subject-set-relation=member",
+			headers: ozonetest.Bearer("bouncer-user-9338"),
+		},
+		"QueryMissingParams": {
+			target:  "/api/v1/auth/relation_tuples?namespace=com.livspace.auth&relation=get",
+			headers: ozonetest.Bearer("bouncer-user-9338"),
+		},
+		"Expand": {
+			target:  "/api/v1/auth/expand?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users&max-depth=3",
+			headers: ozonetest.Bearer("bouncer-user-9338"),
+		},
+		"ExpandFlat": {
+			target:  "/api/v1/auth/expand?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users&max-depth=3&format=flat",
+			headers: ozonetest.Bearer("bouncer-user-9338"),
+		},
+		"ExpandNotFound": {
+			target:  "/api/v1/auth/expand?namespace=com.livspace.auth&relation=delete&object=com.livspace.auth;bouncer;users",
+			headers: ozonetest.Bearer("bouncer-user-9338"),
+		},
+		"ListAll": {
+			target:  "/api/v1/auth/relation_tuples/list?namespace=com.livspace.auth",
+			headers: ozonetest.Bearer("bouncer-user-9338"),
+		},
+		"ListPaged": {
+			target:  "/api/v1/auth/relation_tuples/list?namespace=com.livspace.auth&page_size=1&page_token=1",
+			headers: ozonetest.Bearer("bouncer-user-9338"),
+		},
+		"ListBySubjectSet": {
+			target:  "/api/v1/auth/relation_tuples/list?subject_set.namespace=com.livspace.auth&subject_set.object=com.livspace.auth;bouncer;roles;BOUNCER_VIEWER&subject_set.relation=member",
+			headers: ozonetest.Bearer("bouncer-user-9338"),
+		},
+	}
+
+	rest := newHarness(t)
+	grpc := newHarness(t, func(config *viper.Viper) {
+		config.Set("keto.read.protocol", utils.GrpcProtocol)
+	})
+	for name, request := range requests {
+		t.Run(name, func(t *testing.T) {
+			restResponse := rest.Do(http.MethodGet, request.target, request.headers)
+			grpcResponse := grpc.Do(http.MethodGet, request.target, request.headers)
+			assert.Equal(t, restResponse.Code, grpcResponse.Code)
+			assert.JSONEq(t, restResponse.Body.String(), grpcResponse.Body.String())
+		})
+	}
+
+	t.Run("KetoDown", func(t *testing.T) {
+		grpc.Keto.SetFailing(true)
+		defer grpc.Keto.SetFailing(false)
+		for name, request := range requests {
+			if name == "QueryMissingParams" {
+				continue
+			}
+			w := grpc.Do(http.MethodGet, request.target, request.headers)
+			assert.Equal(t, http.StatusFailedDependency, w.Code, name)
+		}
+	})
+}
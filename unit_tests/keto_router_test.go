@@ -0,0 +1,74 @@
+package unit_tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/livspaceeng/ozone/internal/utils"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKetoRouter_RoutesByNamespace(t *testing.T) {
+	legacy := ozonetest.NewFakeKeto([]ozonetest.Tuple{
+		{Namespace: "com.livspace.legacy", Object: "orders", Relation: "get", SubjectId: "com.livspace.auth;bouncer;users;9338"},
+	})
+	defer legacy.Close()
+
+	h := newHarness(t, func(config *viper.Viper) {
+		config.Set("keto.routes", []map[string]interface{}{
+			{"name": "legacy", "url": legacy.URL, "namespaces": []string{"com.livspace.legacy"}},
+		})
+	})
+
+	w := h.Do(http.MethodGet, "/api/v1/auth/check?namespace=com.livspace.legacy&object=orders&relation=get", ozonetest.Bearer("bouncer-user-9338"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, legacy.Calls())
+	assert.Equal(t, 0, h.Keto.Calls())
+
+	w = h.Do(http.MethodGet, "/api/v1/auth/check?namespace=com.livspace.auth&object=com.livspace.auth;bouncer;users&relation=get", ozonetest.Bearer("bouncer-user-9338"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, legacy.Calls())
+	assert.Equal(t, 1, h.Keto.Calls())
+}
+
+func TestKetoRouter_Ready(t *testing.T) {
+	legacy := ozonetest.NewFakeKeto(nil)
+	h := newHarness(t, func(config *viper.Viper) {
+		config.Set("keto.routes", []map[string]interface{}{
+			{"name": "legacy", "url": legacy.URL, "namespaces": []string{"com.livspace.legacy"}},
+		})
+	})
+
+	w := h.Do(http.MethodGet, "/health/ready", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	legacy.Close()
+	w = h.Do(http.MethodGet, "/health/ready", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var backends map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &backends))
+	assert.Equal(t, "ok", backends["default"])
+	assert.NotEqual(t, "ok", backends["legacy"])
+}
+
+func TestKetoRouter_ReloadKeepsProtocol(t *testing.T) {
+	h := newHarness(t, func(config *viper.Viper) {
+		config.Set("keto.read.protocol", utils.GrpcProtocol)
+	})
+
+	assert.Error(t, reloadConfig(h, func(config *viper.Viper) {
+		config.Set("keto.read.grpc.address", "")
+		config.Set("keto.read.protocol", utils.RestProtocol)
+	}))
+	assert.Error(t, reloadConfig(h, func(config *viper.Viper) {
+		config.Set("keto.read.protocol", utils.RestProtocol)
+	}))
+	assert.NoError(t, reloadConfig(h, func(config *viper.Viper) {}))
+
+	w := h.Do(http.MethodGet, "/api/v1/auth/check?namespace=com.livspace.auth&object=com.livspace.auth;bouncer;users&relation=get", ozonetest.Bearer("bouncer-user-9338"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
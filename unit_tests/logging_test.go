@@ -0,0 +1,167 @@
+package unit_tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/livspaceeng/ozone/internal/logging"
+	"github.com/livspaceeng/ozone/ozonetest"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLog sends the standard logger to a buffer until the test ends.
+func captureLog(t *testing.T) *bytes.Buffer {
+	logger := log.StandardLogger()
+	level, formatter, output := logger.GetLevel(), logger.Formatter, logger.Out
+	t.Cleanup(func() {
+		log.SetLevel(level)
+		log.SetFormatter(formatter)
+		log.SetOutput(output)
+	})
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	return &buf
+}
+
+func logConfig(settings map[string]interface{}) *viper.Viper {
+	config := viper.New()
+	for key, value := range settings {
+		config.Set(key, value)
+	}
+	return config
+}
+
+func TestLogging_Configure(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		err      bool
+		level    log.Level
+		check    func(t *testing.T, output string)
+	}{
+		{
+			name:     "json by default",
+			settings: map[string]interface{}{"log.level": "info"},
+			level:    log.InfoLevel,
+			check: func(t *testing.T, output string) {
+				var line map[string]interface{}
+				require.NoError(t, json.Unmarshal([]byte(output), &line))
+				assert.Equal(t, "hello", line["msg"])
+			},
+		},
+		{
+			name:     "text",
+			settings: map[string]interface{}{"log.level": "debug", "log.format": "text"},
+			level:    log.DebugLevel,
+			check: func(t *testing.T, output string) {
+				assert.Contains(t, output, "msg=hello")
+			},
+		},
+		{
+			name:     "level filters",
+			settings: map[string]interface{}{"log.level": "warn", "log.format": "json"},
+			level:    log.WarnLevel,
+			check: func(t *testing.T, output string) {
+				assert.Empty(t, output)
+			},
+		},
+		{
+			name:     "unknown level",
+			settings: map[string]interface{}{"log.level": "loud"},
+			err:      true,
+		},
+		{
+			name:     "unknown format",
+			settings: map[string]interface{}{"log.level": "info", "log.format": "xml"},
+			err:      true,
+		},
+		{
+			name:     "sampling rate out of range",
+			settings: map[string]interface{}{"log.level": "info", "log.sampling.rate": 1.5},
+			err:      true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := captureLog(t)
+			log.SetLevel(log.TraceLevel)
+
+			err := logging.Configure(logConfig(tc.settings))
+			if tc.err {
+				assert.Error(t, err)
+				// A rejected config leaves the logger as it was
+				assert.Equal(t, log.TraceLevel, log.GetLevel())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.level, log.GetLevel())
+			log.Info("hello")
+			tc.check(t, buf.String())
+		})
+	}
+}
+
+// Sampling drops info lines but never warnings or errors.
+func TestLogging_Sampling(t *testing.T) {
+	buf := captureLog(t)
+	require.NoError(t, logging.Configure(logConfig(map[string]interface{}{
+		"log.level":         "info",
+		"log.format":        "text",
+		"log.sampling.rate": 0,
+	})))
+
+	for i := 0; i < 10; i++ {
+		log.Info("sampled")
+	}
+	log.Warn("kept warning")
+	log.Error("kept error")
+
+	output := buf.String()
+	assert.NotContains(t, output, "sampled")
+	assert.Contains(t, output, "kept warning")
+	assert.Contains(t, output, "kept error")
+	assert.Len(t, strings.Split(strings.TrimSpace(output), "\n"), 2)
+}
+
+// The access log carries the request headers without credentials.
+func TestLogging_RedactsHeaders(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	h := newHarness(t)
+
+	headers := ozonetest.Bearer("bouncer-user-9338")
+	headers["Cookie"] = "session=secret-session"
+	headers["Proxy-Authorization"] = "Basic c2VjcmV0"
+	headers["X-Request-Source"] = "unit-test"
+	w := h.Do(http.MethodGet, checkTarget, headers)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var access *log.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "access" {
+			access = entry
+		}
+	}
+	require.NotNil(t, access)
+	logged, ok := access.Data["headers"].(http.Header)
+	require.True(t, ok)
+	assert.Equal(t, "REDACTED", logged.Get("Authorization"))
+	assert.Equal(t, "REDACTED", logged.Get("Cookie"))
+	assert.Equal(t, "REDACTED", logged.Get("Proxy-Authorization"))
+	assert.Equal(t, "unit-test", logged.Get("X-Request-Source"))
+
+	for _, entry := range hook.AllEntries() {
+		line, err := entry.String()
+		require.NoError(t, err)
+		assert.NotContains(t, line, "bouncer-user-9338")
+		assert.NotContains(t, line, "secret-session")
+		assert.NotContains(t, line, "c2VjcmV0")
+	}
+}
@@ -0,0 +1,159 @@
+package unit_tests
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/livspaceeng/ozone/internal/utils"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	billingSpiffeId = "spiffe://livspace.io/ns/default/sa/billing"
+	billingSubject  = "certificate;" + billingSpiffeId
+	mtlsCheck       = "/api/v1/auth/check?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users"
+)
+
+func newMTLS(t *testing.T, clientAuth string, opts ...ozonetest.ConfigOption) (*ozonetest.Harness, *ozonetest.CA, string) {
+	dir := t.TempDir()
+	ca := ozonetest.NewCA(t, "ozonetest-ca")
+	certFile, keyFile := ozonetest.WriteKeyPair(t, dir, "server", ca.Issue(t, "ozone"))
+	caFile := ca.WriteFile(t, dir)
+
+	h := newHarness(t, func(config *viper.Viper) {
+		config.Set("server.tls.enabled", true)
+		config.Set("server.tls.cert_file", certFile)
+		config.Set("server.tls.key_file", keyFile)
+		config.Set("server.tls.client_ca_file", caFile)
+		config.Set("server.tls.client_auth", clientAuth)
+		for _, opt := range opts {
+			opt(config)
+		}
+	})
+	h.Keto.AddTuple(ozonetest.Tuple{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get", SubjectId: billingSubject})
+	return h, ca, h.ServeTLS(t).URL
+}
+
+func tlsClient(ca *ozonetest.CA, certificates ...tls.Certificate) *http.Client {
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      ca.Pool(),
+		Certificates: certificates,
+	}}}
+}
+
+func get(t *testing.T, client *http.Client, target string, headers map[string]string) (int, string, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	require.NoError(t, err)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body), nil
+}
+
+func TestMTLS_ClientCertificate(t *testing.T) {
+	_, ca, url := newMTLS(t, utils.RequestClientAuth)
+
+	tests := map[string]struct {
+		certificates []tls.Certificate
+		headers      map[string]string
+		status       int
+		body         string
+	}{
+		"SpiffeId": {
+			certificates: []tls.Certificate{ca.Issue(t, "billing", billingSpiffeId)},
+			status:       http.StatusOK,
+			body:         `"` + billingSubject + `"`,
+		},
+		"CommonNameIgnored": {
+			certificates: []tls.Certificate{ca.Issue(t, "ozone-worker")},
+			status:       http.StatusUnauthorized,
+			body:         `"Bearer token absent"`,
+		},
+		"TokenWinsOverCertificate": {
+			certificates: []tls.Certificate{ca.Issue(t, "billing", billingSpiffeId)},
+			headers:      ozonetest.Bearer("bouncer-user-9338"),
+			status:       http.StatusOK,
+			body:         `"com.livspace.auth;bouncer;users;9338"`,
+		},
+		"NoCertificateNoToken": {
+			status: http.StatusUnauthorized,
+			body:   `"Bearer token absent"`,
+		},
+		"NoCertificateWithToken": {
+			headers: ozonetest.Bearer("bouncer-user-6178"),
+			status:  http.StatusForbidden,
+			body:    `"com.livspace.auth;bouncer;users;6178"`,
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			status, body, err := get(t, tlsClient(ca, tt.certificates...), url+mtlsCheck, tt.headers)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, status)
+			assert.Equal(t, tt.body, body)
+		})
+	}
+}
+
+func TestMTLS_UntrustedCertificate(t *testing.T) {
+	_, ca, url := newMTLS(t, utils.RequestClientAuth)
+	other := ozonetest.NewCA(t, "other-ca")
+
+	// The client does not offer a certificate of a CA the server does not
+	// accept, the request is not authenticated.
+	status, _, err := get(t, tlsClient(ca, other.Issue(t, "billing", billingSpiffeId)), url+mtlsCheck, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestMTLS_RequireCertificate(t *testing.T) {
+	_, ca, url := newMTLS(t, utils.RequireClientAuth)
+
+	_, _, err := get(t, tlsClient(ca), url+mtlsCheck, ozonetest.Bearer("bouncer-user-9338"))
+	assert.Error(t, err)
+
+	status, _, err := get(t, tlsClient(ca, ca.Issue(t, "billing", billingSpiffeId)), url+mtlsCheck, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestMTLS_SubjectNamespace(t *testing.T) {
+	h, ca, url := newMTLS(t, utils.RequestClientAuth)
+	// A tuple granting the bare SPIFFE ID, as a token subject could be
+	h.Keto.AddTuple(ozonetest.Tuple{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;roles", Relation: "get", SubjectId: billingSpiffeId})
+
+	status, body, err := get(t, tlsClient(ca, ca.Issue(t, "billing", billingSpiffeId)), url+"/api/v1/auth/check?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;roles", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, `"`+billingSubject+`"`, body)
+}
+
+func TestMTLS_CommonNameSubject(t *testing.T) {
+	h, ca, url := newMTLS(t, utils.RequestClientAuth, func(config *viper.Viper) {
+		config.Set("server.tls.common_name_subject", true)
+	})
+	h.Keto.AddTuple(ozonetest.Tuple{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get", SubjectId: "certificate;ozone-worker"})
+
+	status, body, err := get(t, tlsClient(ca, ca.Issue(t, "ozone-worker")), url+mtlsCheck, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `"certificate;ozone-worker"`, body)
+
+	status, body, err = get(t, tlsClient(ca, ca.Issue(t, "billing", billingSpiffeId)), url+mtlsCheck, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `"`+billingSubject+`"`, body)
+}
@@ -0,0 +1,143 @@
+package unit_tests
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/livspaceeng/ozone/middleware"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func withSubjectRateLimit(config *viper.Viper) {
+	config.Set("ratelimit.enabled", true)
+	config.Set("ratelimit.max_invalid_tokens", 4)
+	config.Set("ratelimit.rules", []map[string]interface{}{
+		{"route": "/api/v1/auth/check", "key": "subject", "rate": 100, "burst": 100},
+		{"route": "/api/v1/auth/relation_tuples", "key": "client_id", "rate": 100, "burst": 100},
+	})
+}
+
+// An invalid token is counted once per request, however many middlewares
+// resolve it before the controller.
+func TestRateLimit_InvalidTokensCountedOnce(t *testing.T) {
+	h := newHarness(t, withSubjectRateLimit)
+
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, http.StatusUnauthorized, h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("unknown")).Code, "request %d", i+1)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("unknown")).Code)
+	assert.Equal(t, 1, h.Hydra.Calls())
+}
+
+// The rate limiter resolves the subject of a POST check with the issuer of
+// its body, as the controller does.
+func TestRateLimit_PostCheckIssuer(t *testing.T) {
+	accounts := ozonetest.NewFakeHydra([]ozonetest.Token{
+		{Token: "accounts-user-9338", Subject: "com.livspace.auth;bouncer;users;9338", ClientId: "accounts-web", ExpiresIn: 3600},
+	})
+	t.Cleanup(accounts.Close)
+	h := newHarness(t, withSubjectRateLimit, func(config *viper.Viper) {
+		config.Set("issuer.accounts.url", accounts.URL)
+		config.Set("ratelimit.max_invalid_tokens", 1)
+	})
+
+	body := map[string]interface{}{"namespace": "com.livspace.auth", "object": "com.livspace.auth;bouncer;users", "relation": "get", "issuer": "accounts"}
+	for i := 0; i < 3; i++ {
+		w := h.DoJSON(http.MethodPost, "/api/v1/auth/check", ozonetest.Bearer("accounts-user-9338"), body)
+		assert.Equal(t, http.StatusOK, w.Code, "request %d", i+1)
+		// One bucket for the subject
+		assert.Equal(t, strconv.Itoa(99-i), w.Header().Get("X-RateLimit-Remaining"), "request %d", i+1)
+	}
+	assert.Equal(t, 0, h.Hydra.Calls())
+	assert.Equal(t, 1, accounts.Calls())
+}
+
+type rateLimitStep struct {
+	name       string
+	token      string
+	clientIP   string
+	advance    time.Duration
+	status     int
+	remaining  string
+	retryAfter string
+}
+
+var rateLimitCases = []struct {
+	name  string
+	rule  map[string]interface{}
+	steps []rateLimitStep
+}{
+	{
+		name: "ip",
+		rule: map[string]interface{}{"route": "/api/v1/auth/check", "key": "ip", "rate": 1, "burst": 2},
+		steps: []rateLimitStep{
+			{name: "burst", token: "bouncer-user-9338", status: http.StatusOK, remaining: "1"},
+			{name: "other token, same ip", token: "bouncer-user-6178", status: http.StatusForbidden, remaining: "0"},
+			{name: "empty", token: "bouncer-user-9338", status: http.StatusTooManyRequests, remaining: "0", retryAfter: "1"},
+			{name: "other ip", token: "bouncer-user-9338", clientIP: "198.51.100.7", status: http.StatusOK, remaining: "1"},
+			{name: "refilled", token: "bouncer-user-9338", advance: time.Second, status: http.StatusOK, remaining: "0"},
+		},
+	},
+	{
+		name: "subject",
+		rule: map[string]interface{}{"route": "/api/v1/auth/check", "key": "subject", "rate": 0.5, "burst": 1},
+		steps: []rateLimitStep{
+			{name: "burst", token: "bouncer-user-9338", status: http.StatusOK, remaining: "0"},
+			{name: "same subject, other ip", token: "bouncer-user-9338", clientIP: "198.51.100.7", status: http.StatusTooManyRequests, remaining: "0", retryAfter: "2"},
+			{name: "other subject", token: "bouncer-user-6178", status: http.StatusForbidden, remaining: "0"},
+			{name: "partly refilled", token: "bouncer-user-9338", advance: time.Second, status: http.StatusTooManyRequests, remaining: "0", retryAfter: "1"},
+			{name: "refilled", token: "bouncer-user-9338", advance: time.Second, status: http.StatusOK, remaining: "0"},
+		},
+	},
+	{
+		name: "client_id",
+		rule: map[string]interface{}{"route": "/api/v1/auth/check", "key": "client_id", "rate": 1, "burst": 1},
+		steps: []rateLimitStep{
+			{name: "burst", token: "bouncer-user-9338", status: http.StatusOK, remaining: "0"},
+			{name: "other subject, same client", token: "bouncer-user-6178", status: http.StatusTooManyRequests, remaining: "0", retryAfter: "1"},
+			{name: "refilled", token: "bouncer-user-6178", advance: time.Second, status: http.StatusForbidden, remaining: "0"},
+		},
+	},
+}
+
+// Token buckets refill at the rule rate up to its burst, with one bucket per
+// key value, and behave the same with every backend.
+func TestRateLimit_Buckets(t *testing.T) {
+	backends := map[string]func(t *testing.T, config *viper.Viper){
+		middleware.MemoryBackend: func(t *testing.T, config *viper.Viper) {},
+		middleware.RedisBackend: func(t *testing.T, config *viper.Viper) {
+			config.Set("ratelimit.redis.address", miniredis.RunT(t).Addr())
+		},
+	}
+	for backend, setup := range backends {
+		for _, tc := range rateLimitCases {
+			t.Run(backend+"/"+tc.name, func(t *testing.T) {
+				h := newHarness(t, func(config *viper.Viper) {
+					config.Set("ratelimit.enabled", true)
+					config.Set("ratelimit.backend", backend)
+					config.Set("ratelimit.rules", []map[string]interface{}{tc.rule})
+					setup(t, config)
+				})
+
+				for _, step := range tc.steps {
+					h.Clock.Advance(step.advance)
+					headers := ozonetest.Bearer(step.token)
+					if step.clientIP != "" {
+						headers["X-Forwarded-For"] = step.clientIP
+					}
+					w := h.Do(http.MethodGet, checkTarget, headers)
+					assert.Equal(t, step.status, w.Code, step.name)
+					assert.Equal(t, strconv.Itoa(tc.rule["burst"].(int)), w.Header().Get("X-RateLimit-Limit"), step.name)
+					assert.Equal(t, step.remaining, w.Header().Get("X-RateLimit-Remaining"), step.name)
+					assert.NotEmpty(t, w.Header().Get("X-RateLimit-Reset"), step.name)
+					assert.Equal(t, step.retryAfter, w.Header().Get("Retry-After"), step.name)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package unit_tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/livspaceeng/ozone/internal/utils"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withConfigSchema(config *viper.Viper) {
+	config.Set("schema.source", "config")
+	config.Set("schema.namespaces", []map[string]interface{}{
+		{"name": "com.livspace.auth", "relations": []string{"get", "post", "member"}, "object_pattern": "^[^;]+(;[^;]+)*$"},
+		{"name": "ozone"},
+	})
+}
+
+func errorMessage(t *testing.T, body []byte) string {
+	var message string
+	require.NoError(t, json.Unmarshal(body, &message))
+	return message
+}
+
+func TestSchema_Config(t *testing.T) {
+	h := newHarness(t, withConfigSchema, withWritePermission)
+	grantWrite(h)
+
+	tests := map[string]struct {
+		target  string
+		status  int
+		message string
+	}{
+		"Known": {
+			target: "/api/v1/auth/check?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users",
+			status: http.StatusOK,
+		},
+		"RelationTypo": {
+			target:  "/api/v1/auth/check?namespace=com.livspace.auth&relation=gett&object=com.livspace.auth;bouncer;users",
+			status:  http.StatusBadRequest,
+			message: `Unknown relation "gett" in namespace com.livspace.auth, did you mean "get"?`,
+		},
+		"UnknownRelation": {
+			target:  "/api/v1/auth/check?namespace=com.livspace.auth&relation=administer&object=com.livspace.auth;bouncer;users",
+			status:  http.StatusBadRequest,
+			message: `Unknown relation "administer" in namespace com.livspace.auth, known are: get, member, post`,
+		},
+		"NamespaceTypo": {
+			target:  "/api/v1/auth/check?namespace=com.livspace.auht&relation=get&object=com.livspace.auth;bouncer;users",
+			status:  http.StatusBadRequest,
+			message: `Unknown namespace "com.livspace.auht", did you mean "com.livspace.auth"?`,
+		},
+		"InvalidObject": {
+			target:  "/api/v1/auth/check?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;;users",
+			status:  http.StatusBadRequest,
+			message: `Object "com.livspace.auth;;users" does not match ^[^;]+(;[^;]+)*$, the object pattern of namespace com.livspace.auth`,
+		},
+		"UnknownSubjectSetRelation": {
+			target:  "/api/v1/auth/relation_tuples?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users&subject-set-namespace=com.livspace.auth&subject-set-relation=membr&subject-set-object=com.livspace.auth;bouncer;roles;BOUNCER_VIEWER",
+			status:  http.StatusBadRequest,
+			message: `Unknown relation "membr" in namespace com.livspace.auth, did you mean "member"?`,
+		},
+		"ExpandUnknownNamespace": {
+			target:  "/api/v1/auth/expand?namespace=unknown&relation=get&object=com.livspace.auth;bouncer;users",
+			status:  http.StatusBadRequest,
+			message: `Unknown namespace "unknown", known are: com.livspace.auth, ozone`,
+		},
+		"ListPartialQuery": {
+			target: "/api/v1/auth/relation_tuples/list?namespace=com.livspace.auth",
+			status: http.StatusOK,
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			w := h.Do(http.MethodGet, tt.target, ozonetest.Bearer("bouncer-user-9338"))
+			assert.Equal(t, tt.status, w.Code)
+			if tt.message != "" {
+				assert.Equal(t, tt.message, errorMessage(t, w.Body.Bytes()))
+			}
+		})
+	}
+
+	t.Run("WriteUnknownRelation", func(t *testing.T) {
+		deltas := grantDeltas()
+		deltas[0].RelationTuple.Relation = "pots"
+		w := h.DoJSON(http.MethodPatch, writeTarget, ozonetest.Bearer("bouncer-user-9338"), deltas)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, `Unknown relation "pots" in namespace com.livspace.auth, did you mean "post"?`, errorMessage(t, w.Body.Bytes()))
+	})
+}
+
+func TestSchema_Keto(t *testing.T) {
+	for _, protocol := range []string{utils.RestProtocol, utils.GrpcProtocol} {
+		t.Run(protocol, func(t *testing.T) {
+			h := newHarness(t, func(config *viper.Viper) {
+				config.Set("keto.read.protocol", protocol)
+				config.Set("schema.source", "keto")
+			})
+
+			w := h.Do(http.MethodGet, "/api/v1/auth/check?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users", ozonetest.Bearer("bouncer-user-9338"))
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			w = h.Do(http.MethodGet, "/api/v1/auth/check?namespace=com.livspace.aut&relation=get&object=com.livspace.auth;bouncer;users", ozonetest.Bearer("bouncer-user-9338"))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, `Unknown namespace "com.livspace.aut", did you mean "com.livspace.auth"?`, errorMessage(t, w.Body.Bytes()))
+		})
+	}
+}
+
+func TestSchema_KetoDownAllows(t *testing.T) {
+	h := newHarness(t, func(config *viper.Viper) {
+		config.Set("schema.source", "keto")
+	})
+	h.Keto.SetFailing(true)
+
+	w := h.Do(http.MethodGet, "/api/v1/auth/relation_tuples/list?namespace=unknown", ozonetest.Bearer("bouncer-user-9338"))
+	assert.Equal(t, http.StatusFailedDependency, w.Code)
+	h.Keto.SetFailing(false)
+
+	// A failed listing is retried by the next request.
+	w = h.Do(http.MethodGet, "/api/v1/auth/relation_tuples/list?namespace=unknown", ozonetest.Bearer("bouncer-user-9338"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSchema_KetoRefresh(t *testing.T) {
+	h := newHarness(t, func(config *viper.Viper) {
+		config.Set("schema.source", "keto")
+		config.Set("schema.refresh", 60)
+	})
+	target := "/api/v1/auth/check?namespace=com.livspace.new&relation=get&object=projects"
+	bearer := ozonetest.Bearer("bouncer-user-9338")
+
+	w := h.Do(http.MethodGet, target, bearer)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	h.Keto.AddTuple(ozonetest.Tuple{Namespace: "com.livspace.new", Object: "projects", Relation: "get", SubjectId: "com.livspace.auth;bouncer;users;9338"})
+
+	// Within schema.refresh the list is not listed again.
+	w = h.Do(http.MethodGet, target, bearer)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Past it, the stale list is served without waiting on a slow Keto while
+	// it is listed again in the background.
+	h.Clock.Advance(time.Minute)
+	h.Keto.SetDelay(200 * time.Millisecond)
+	started := time.Now()
+	w = h.Do(http.MethodGet, target, bearer)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Less(t, time.Since(started), 200*time.Millisecond)
+	h.Keto.SetDelay(0)
+
+	assert.Eventually(t, func() bool {
+		return h.Do(http.MethodGet, target, bearer).Code == http.StatusOK
+	}, 2*time.Second, 20*time.Millisecond)
+}
@@ -0,0 +1,64 @@
+package unit_tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const shadowTarget = "/api/v1/auth/check?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users"
+
+func withEnforcementRules(rules ...map[string]interface{}) ozonetest.ConfigOption {
+	return func(config *viper.Viper) {
+		config.Set("enforcement.rules", rules)
+	}
+}
+
+func TestShadowMode_Namespace(t *testing.T) {
+	h := newHarness(t, withEnforcementRules(map[string]interface{}{"namespace": "com.livspace.auth", "mode": "shadow"}))
+
+	tests := map[string]struct {
+		token    string
+		legacy   string
+		decision string
+		status   string
+		mismatch string
+	}{
+		"Allowed":         {token: "bouncer-user-9338", decision: "allow", status: "200"},
+		"Denied":          {token: "bouncer-user-6178", decision: "deny", status: "403"},
+		"InvalidToken":    {token: "revoked", decision: "deny", status: "401"},
+		"LegacyAgrees":    {token: "bouncer-user-6178", legacy: "deny", decision: "deny", status: "403"},
+		"LegacyDisagrees": {token: "bouncer-user-6178", legacy: "Allow", decision: "deny", status: "403", mismatch: "true"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			headers := ozonetest.Bearer(test.token)
+			if test.legacy != "" {
+				headers["X-Legacy-Decision"] = test.legacy
+			}
+			w := h.Do(http.MethodGet, shadowTarget, headers)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, test.decision, w.Header().Get("X-Ozone-Shadow-Decision"))
+			assert.Equal(t, test.status, w.Header().Get("X-Ozone-Shadow-Status"))
+			assert.Equal(t, test.mismatch, w.Header().Get("X-Ozone-Shadow-Mismatch"))
+		})
+	}
+}
+
+func TestShadowMode_Route(t *testing.T) {
+	h := newHarness(t, withEnforcementRules(
+		map[string]interface{}{"route": "/api/v1/auth/check", "mode": "shadow"},
+		map[string]interface{}{"namespace": "com.livspace.auth", "mode": "enforce"},
+	))
+
+	w := h.Do(http.MethodGet, shadowTarget, ozonetest.Bearer("bouncer-user-6178"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("X-Ozone-Shadow-Decision"))
+
+	w = h.Do(http.MethodGet, "/api/v1/auth/check?namespace=com.livspace.orders&relation=get&object=orders", ozonetest.Bearer("bouncer-user-6178"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "deny", w.Header().Get("X-Ozone-Shadow-Decision"))
+}
@@ -0,0 +1,129 @@
+package unit_tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/livspaceeng/ozone/internal/utils"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	writeTarget     = "/api/v1/auth/relation_tuples"
+	writerCheck     = "/api/v1/auth/check?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users"
+	writePermission = "ozone:relation_tuples#write"
+)
+
+func withWritePermission(config *viper.Viper) {
+	config.Set("enforcement.permissions.write", writePermission)
+}
+
+func grantWrite(h *ozonetest.Harness) {
+	h.Keto.AddTuple(ozonetest.Tuple{Namespace: "ozone", Object: "relation_tuples", Relation: "write", SubjectId: "com.livspace.auth;bouncer;users;9338"})
+}
+
+func grantDeltas() []model.RelationTupleDelta {
+	return []model.RelationTupleDelta{{
+		Action: "insert",
+		RelationTuple: model.RelationTuple{
+			Namespace: "com.livspace.auth",
+			Object:    "com.livspace.auth;bouncer;users",
+			Relation:  "get",
+			SubjectId: "com.livspace.auth;bouncer;users;6178",
+		},
+	}}
+}
+
+func TestSnaptoken_WriteThenCheck(t *testing.T) {
+	for _, protocol := range []string{utils.RestProtocol, utils.GrpcProtocol} {
+		t.Run(protocol, func(t *testing.T) {
+			h := newHarness(t, withWritePermission, func(config *viper.Viper) {
+				config.Set("keto.read.protocol", protocol)
+			})
+			grantWrite(h)
+
+			w := h.DoJSON(http.MethodPatch, writeTarget, ozonetest.Bearer("bouncer-user-9338"), grantDeltas())
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			var written model.WriteResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &written))
+			if protocol == utils.GrpcProtocol {
+				assert.NotEmpty(t, written.Snaptoken)
+			} else {
+				// As documented, REST writes have no snaptoken to return
+				assert.JSONEq(t, `{}`, w.Body.String())
+			}
+
+			w = h.Do(http.MethodGet, writerCheck+"&snaptoken=v42", ozonetest.Bearer("bouncer-user-6178"))
+			assert.Equal(t, http.StatusOK, w.Code)
+			w = h.Do(http.MethodGet, "/api/v1/auth/expand?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users&snaptoken=v43", ozonetest.Bearer("bouncer-user-9338"))
+			assert.Equal(t, http.StatusOK, w.Code)
+			w = h.Do(http.MethodGet, "/api/v1/auth/relation_tuples/list?namespace=com.livspace.auth&snaptoken=v44", ozonetest.Bearer("bouncer-user-9338"))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, []string{"v42", "v43", "v44"}, h.Keto.Snaptokens())
+		})
+	}
+}
+
+func TestSnaptoken_DecisionCache(t *testing.T) {
+	h := newHarness(t, func(config *viper.Viper) {
+		config.Set("keto.cache.enabled", true)
+		config.Set("keto.cache.ttl", 60)
+	})
+
+	w := h.Do(http.MethodGet, writerCheck, ozonetest.Bearer("bouncer-user-6178"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	calls := h.Keto.Calls()
+
+	// A write that did not go through ozone leaves the cached deny in place
+	h.Keto.AddTuple(ozonetest.Tuple{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get", SubjectId: "com.livspace.auth;bouncer;users;6178"})
+	w = h.Do(http.MethodGet, writerCheck, ozonetest.Bearer("bouncer-user-6178"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, calls, h.Keto.Calls())
+
+	w = h.Do(http.MethodGet, writerCheck+"&snaptoken=v1", ozonetest.Bearer("bouncer-user-6178"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, calls+1, h.Keto.Calls())
+}
+
+func TestSnaptoken_DecisionCacheKeys(t *testing.T) {
+	h := newHarness(t, func(config *viper.Viper) {
+		config.Set("keto.cache.enabled", true)
+		config.Set("keto.cache.ttl", 60)
+	})
+	query := "/api/v1/auth/relation_tuples?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users&"
+
+	w := h.Do(http.MethodGet, query+"subject-set-namespace=com.livspace.auth&subject-set-object=com.livspace.auth;bouncer;roles;BOUNCER_VIEWER&subject-set-relation=member", ozonetest.Bearer("bouncer-user-9338"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// A subject id spelled like the subject set is another tuple
+	w = h.Do(http.MethodGet, query+"subject-id=com.livspace.auth:com.livspace.auth;bouncer;roles;BOUNCER_VIEWER%23member", ozonetest.Bearer("bouncer-user-9338"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// So is an object holding the separator of the relation
+	h.Keto.AddTuple(ozonetest.Tuple{Namespace: "com.livspace.auth", Object: "reports", Relation: "view#get", SubjectId: "com.livspace.auth;bouncer;users;9338"})
+	w = h.Do(http.MethodGet, "/api/v1/auth/relation_tuples?namespace=com.livspace.auth&relation=view%23get&object=reports&subject-id=com.livspace.auth;bouncer;users;9338", ozonetest.Bearer("bouncer-user-9338"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = h.Do(http.MethodGet, "/api/v1/auth/relation_tuples?namespace=com.livspace.auth&relation=get&object=reports%23view&subject-id=com.livspace.auth;bouncer;users;9338", ozonetest.Bearer("bouncer-user-9338"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestSnaptoken_WritePermission(t *testing.T) {
+	h := newHarness(t)
+	w := h.DoJSON(http.MethodPatch, writeTarget, ozonetest.Bearer("bouncer-user-9338"), grantDeltas())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	h = newHarness(t, withWritePermission)
+	w = h.DoJSON(http.MethodPatch, writeTarget, ozonetest.Bearer("bouncer-user-9338"), grantDeltas())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	grantWrite(h)
+	w = h.DoJSON(http.MethodPatch, writeTarget, ozonetest.Bearer("bouncer-user-9338"), []model.RelationTupleDelta{{Action: "upsert"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = h.DoJSON(http.MethodPatch, writeTarget, nil, grantDeltas())
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -0,0 +1,182 @@
+package unit_tests
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/livspaceeng/ozone/internal/model"
+	"github.com/livspaceeng/ozone/internal/server"
+	"github.com/livspaceeng/ozone/internal/services"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	viewerGrant = `
+  - namespace: com.livspace.auth
+    object: com.livspace.auth;bouncer;users
+    relation: get
+    subject_set:
+      namespace: com.livspace.auth
+      object: com.livspace.auth;bouncer;roles;BOUNCER_VIEWER
+      relation: member`
+	editorGrant = `
+  - namespace: com.livspace.auth
+    object: com.livspace.auth;bouncer;users
+    relation: post
+    subject_set:
+      namespace: com.livspace.auth
+      object: com.livspace.auth;bouncer;roles;BOUNCER_EDITOR
+      relation: member`
+	editorMember = `
+  - namespace: com.livspace.auth
+    object: com.livspace.auth;bouncer;roles;BOUNCER_EDITOR
+    relation: member
+    subject_id: com.livspace.auth;bouncer;users;9338`
+)
+
+func writeManifest(t *testing.T, dir string, tuples ...string) {
+	content := "tuples:"
+	for _, tuple := range tuples {
+		content += tuple
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bouncer.yaml"), []byte(content+"\n"), 0600))
+}
+
+func runSync(h *ozonetest.Harness, args ...string) (int, string) {
+	var out bytes.Buffer
+	code := server.Sync(h.Store, args, &out)
+	return code, out.String()
+}
+
+func TestSync(t *testing.T) {
+	h := newHarness(t)
+	dir := t.TempDir()
+	writeManifest(t, dir, viewerGrant, editorGrant, editorMember)
+	editorCheck := "/api/v1/auth/check?namespace=com.livspace.auth&relation=post&object=com.livspace.auth;bouncer;users"
+
+	code, out := runSync(h, "-dir", dir, "-check")
+	assert.Equal(t, server.SyncDrift, code)
+	assert.Contains(t, out, "+ com.livspace.auth:com.livspace.auth;bouncer;users#post@com.livspace.auth:com.livspace.auth;bouncer;roles;BOUNCER_EDITOR#member\n")
+	assert.Contains(t, out, "~ com.livspace.auth:com.livspace.auth;bouncer;users#get@com.livspace.auth:com.livspace.auth;bouncer;roles;BOUNCER_VIEWER#member\n")
+	assert.Contains(t, out, "2 to create, 1 to adopt, 0 to prune\n")
+
+	code, _ = runSync(h, "-dir", dir, "-dry-run")
+	assert.Equal(t, server.SyncOK, code)
+	assert.Equal(t, http.StatusForbidden, h.Do(http.MethodGet, editorCheck, ozonetest.Bearer("bouncer-user-9338")).Code)
+
+	code, _ = runSync(h, "-dir", dir)
+	require.Equal(t, server.SyncOK, code)
+	assert.Equal(t, http.StatusOK, h.Do(http.MethodGet, editorCheck, ozonetest.Bearer("bouncer-user-9338")).Code)
+
+	code, out = runSync(h, "-dir", dir, "-check")
+	assert.Equal(t, server.SyncOK, code)
+	assert.Equal(t, "0 to create, 0 to adopt, 0 to prune\n", out)
+
+	t.Run("Prune", func(t *testing.T) {
+		writeManifest(t, dir, viewerGrant, editorMember)
+
+		code, out := runSync(h, "-dir", dir)
+		assert.Equal(t, server.SyncOK, code)
+		assert.Contains(t, out, "! stale, kept without -prune: com.livspace.auth:com.livspace.auth;bouncer;users#post@")
+		assert.Equal(t, http.StatusOK, h.Do(http.MethodGet, editorCheck, ozonetest.Bearer("bouncer-user-9338")).Code)
+
+		code, _ = runSync(h, "-dir", dir, "-check")
+		assert.Equal(t, server.SyncDrift, code)
+
+		code, out = runSync(h, "-dir", dir, "-prune")
+		assert.Equal(t, server.SyncOK, code)
+		assert.Contains(t, out, "- com.livspace.auth:com.livspace.auth;bouncer;users#post@")
+		assert.Equal(t, http.StatusForbidden, h.Do(http.MethodGet, editorCheck, ozonetest.Bearer("bouncer-user-9338")).Code)
+
+		code, _ = runSync(h, "-dir", dir, "-check")
+		assert.Equal(t, server.SyncOK, code)
+	})
+
+	t.Run("UnmanagedTuplesKept", func(t *testing.T) {
+		writeManifest(t, dir)
+
+		code, _ := runSync(h, "-dir", dir, "-prune")
+		assert.Equal(t, server.SyncOK, code)
+		_, tuples, err := h.App.KetoService.ListRelationTuples(context.Background(), model.RelationTuple{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;roles;BOUNCER_VIEWER"}, "", "")
+		require.NoError(t, err)
+		assert.Len(t, tuples.RelationTuples, 1)
+		_, tuples, err = h.App.KetoService.ListRelationTuples(context.Background(), model.RelationTuple{Namespace: services.DefaultMarkerNamespace}, "", "")
+		require.NoError(t, err)
+		assert.Empty(t, tuples.RelationTuples)
+	})
+}
+
+func TestSync_Owners(t *testing.T) {
+	h := newHarness(t)
+	bouncer, xpert := t.TempDir(), t.TempDir()
+	writeManifest(t, bouncer, editorGrant)
+	writeManifest(t, xpert, editorMember)
+
+	code, _ := runSync(h, "-dir", bouncer, "-owner", "bouncer")
+	require.Equal(t, server.SyncOK, code)
+	code, _ = runSync(h, "-dir", xpert, "-owner", "xpert")
+	require.Equal(t, server.SyncOK, code)
+
+	writeManifest(t, bouncer)
+	code, out := runSync(h, "-dir", bouncer, "-owner", "bouncer", "-prune")
+	require.Equal(t, server.SyncOK, code)
+	assert.Equal(t, "- com.livspace.auth:com.livspace.auth;bouncer;users#post@com.livspace.auth:com.livspace.auth;bouncer;roles;BOUNCER_EDITOR#member\n0 to create, 0 to adopt, 1 to prune\n", out)
+
+	code, out = runSync(h, "-dir", xpert, "-owner", "xpert", "-check")
+	assert.Equal(t, server.SyncOK, code, out)
+}
+
+func TestSync_Errors(t *testing.T) {
+	h := newHarness(t)
+	invalid := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(invalid, "roles.yml"), []byte("tuples:\n  - namespace: com.livspace.auth\n    relation: member\n"), 0600))
+	valid := t.TempDir()
+	writeManifest(t, valid, editorGrant)
+
+	tests := map[string][]string{
+		"MissingDir":      {},
+		"UnknownDir":      {"-dir", filepath.Join(valid, "missing")},
+		"InvalidManifest": {"-dir", invalid},
+		"UnknownFlag":     {"-dir", valid, "-force"},
+	}
+	for scenario, args := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			code, _ := runSync(h, args...)
+			assert.Equal(t, server.SyncError, code)
+		})
+	}
+
+	t.Run("KetoDown", func(t *testing.T) {
+		h.Keto.SetFailing(true)
+		defer h.Keto.SetFailing(false)
+		code, _ := runSync(h, "-dir", valid, "-check")
+		assert.Equal(t, server.SyncError, code)
+	})
+}
+
+func TestParseTuple(t *testing.T) {
+	tests := map[string]model.RelationTuple{
+		"com.livspace.auth:com.livspace.auth;bouncer;users#get@com.livspace.auth;bouncer;users;9338": {
+			Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;users", Relation: "get", SubjectId: "com.livspace.auth;bouncer;users;9338",
+		},
+		"com.livspace.auth:reports;region=south#view@com.livspace.auth:com.livspace.auth;bouncer;roles;BOUNCER_VIEWER#member": {
+			Namespace: "com.livspace.auth", Object: "reports;region=south", Relation: "view",
+			SubjectSet: &model.SubjectSet{Namespace: "com.livspace.auth", Object: "com.livspace.auth;bouncer;roles;BOUNCER_VIEWER", Relation: "member"},
+		},
+	}
+	for tuple, expected := range tests {
+		parsed, err := services.ParseTuple(tuple)
+		require.NoError(t, err)
+		assert.Equal(t, expected, parsed)
+		assert.Equal(t, tuple, services.TupleString(parsed))
+	}
+
+	_, err := services.ParseTuple("com.livspace.auth:reports#view")
+	assert.Error(t, err)
+}
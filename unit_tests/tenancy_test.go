@@ -0,0 +1,140 @@
+package unit_tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func withTenants(config *viper.Viper) {
+	config.Set("tenancy.enabled", true)
+	config.Set("tenancy.tenants", []map[string]interface{}{
+		{
+			"name":       "bouncer",
+			"client_ids": []string{"bouncer-web"},
+			"grants": []map[string]interface{}{
+				{"namespace": "com.livspace.auth", "operations": []string{"query", "expand"}},
+			},
+		},
+		{
+			"name":    "xpert",
+			"issuers": []string{"xpert"},
+			"grants": []map[string]interface{}{
+				{"namespace": "com.livspace.xpert", "operations": []string{"query"}},
+			},
+			"quota": map[string]interface{}{"rate": 0.001, "burst": 2},
+		},
+	})
+}
+
+func TestTenancy(t *testing.T) {
+	h := newHarness(t, withTenants, withWritePermission)
+	grantWrite(h)
+	h.Hydra.AddToken(ozonetest.Token{Token: "xpert-app", Subject: "xpert;users;12", ClientId: "xpert-app", ExpiresIn: 3600})
+	h.Hydra.AddToken(ozonetest.Token{Token: "stranger", Subject: "users;1", ClientId: "stranger-app", ExpiresIn: 3600})
+
+	tests := map[string]struct {
+		target  string
+		token   string
+		status  int
+		message string
+	}{
+		"QueryInTenant": {
+			target: "/api/v1/auth/relation_tuples?namespace=com.livspace.auth&relation=member&object=com.livspace.auth;bouncer;roles;BOUNCER_VIEWER&subject-id=com.livspace.auth;bouncer;users;9338",
+			token:  "bouncer-user-9338",
+			status: http.StatusOK,
+		},
+		"ExpandInTenant": {
+			target: "/api/v1/auth/expand?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users",
+			token:  "bouncer-user-9338",
+			status: http.StatusOK,
+		},
+		"QueryOutOfTenant": {
+			target:  "/api/v1/auth/relation_tuples?namespace=com.livspace.xpert&relation=member&object=projects;1&subject-id=xpert;users;12",
+			token:   "bouncer-user-9338",
+			status:  http.StatusForbidden,
+			message: "Tenant bouncer may not query namespace com.livspace.xpert",
+		},
+		"ExpandWithoutGrant": {
+			target:  "/api/v1/auth/expand?namespace=com.livspace.xpert&relation=member&object=projects;1&issuer=xpert",
+			token:   "xpert-app",
+			status:  http.StatusForbidden,
+			message: "Tenant xpert may not expand namespace com.livspace.xpert",
+		},
+		"ListWithoutNamespace": {
+			target:  "/api/v1/auth/relation_tuples/list?relation=member",
+			token:   "bouncer-user-9338",
+			status:  http.StatusForbidden,
+			message: "Tenant bouncer has to name a namespace to query",
+		},
+		"UnknownTenant": {
+			target:  "/api/v1/auth/relation_tuples/list?namespace=com.livspace.auth",
+			token:   "stranger",
+			status:  http.StatusForbidden,
+			message: "Client does not belong to a tenant",
+		},
+		"NoToken": {
+			target: "/api/v1/auth/relation_tuples/list?namespace=com.livspace.auth",
+			status: http.StatusUnauthorized,
+		},
+		"CheckIsNotTenanted": {
+			target: "/api/v1/auth/check?namespace=com.livspace.auth&relation=get&object=com.livspace.auth;bouncer;users",
+			token:  "stranger",
+			status: http.StatusForbidden,
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.token != "" {
+				headers = ozonetest.Bearer(tt.token)
+			}
+			w := h.Do(http.MethodGet, tt.target, headers)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.message != "" {
+				assert.Equal(t, tt.message, errorMessage(t, w.Body.Bytes()))
+			}
+		})
+	}
+
+	t.Run("WriteWithoutGrant", func(t *testing.T) {
+		w := h.DoJSON(http.MethodPatch, writeTarget, ozonetest.Bearer("bouncer-user-9338"), grantDeltas())
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, "Tenant bouncer may not write namespace com.livspace.auth", errorMessage(t, w.Body.Bytes()))
+	})
+}
+
+func TestTenancy_Quota(t *testing.T) {
+	h := newHarness(t, withTenants)
+	h.Hydra.AddToken(ozonetest.Token{Token: "xpert-app", Subject: "xpert;users;12", ClientId: "xpert-app", ExpiresIn: 3600})
+	target := "/api/v1/auth/relation_tuples/list?namespace=com.livspace.xpert&issuer=xpert"
+
+	for i := 0; i < 2; i++ {
+		w := h.Do(http.MethodGet, target, ozonetest.Bearer("xpert-app"))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	w := h.Do(http.MethodGet, target, ozonetest.Bearer("xpert-app"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "Tenant quota exceeded", errorMessage(t, w.Body.Bytes()))
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// Quotas are per tenant.
+	w = h.Do(http.MethodGet, "/api/v1/auth/relation_tuples/list?namespace=com.livspace.auth", ozonetest.Bearer("bouncer-user-9338"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// With rate limiting, access rules and tenancy all resolving the token, an
+// invalid token is still counted once per request.
+func TestTenancy_InvalidTokensCountedOnce(t *testing.T) {
+	h := newHarness(t, withTenants, withSubjectRateLimit)
+	query := "/api/v1/auth/relation_tuples?namespace=com.livspace.auth&relation=member&object=com.livspace.auth;bouncer;roles;BOUNCER_VIEWER&subject-id=com.livspace.auth;bouncer;users;9338"
+
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, http.StatusUnauthorized, h.Do(http.MethodGet, query, ozonetest.Bearer("unknown")).Code, "request %d", i+1)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, h.Do(http.MethodGet, query, ozonetest.Bearer("unknown")).Code)
+}
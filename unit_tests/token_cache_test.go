@@ -0,0 +1,120 @@
+package unit_tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/livspaceeng/ozone/configs"
+	"github.com/livspaceeng/ozone/ozonetest"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func withBouncerCache(settings map[string]interface{}) func(config *viper.Viper) {
+	return func(config *viper.Viper) {
+		for key, value := range settings {
+			config.Set("issuer.bouncer.cache."+key, value)
+		}
+	}
+}
+
+func TestTokenCache_Policy(t *testing.T) {
+	tests := map[string]struct {
+		settings      map[string]interface{}
+		token         string
+		wait          time.Duration
+		expectedCalls int
+	}{
+		"CachedWithinMaxTTL": {
+			settings:      map[string]interface{}{"max_ttl": 60},
+			token:         "bouncer-user-9338",
+			expectedCalls: 1,
+		},
+		"ExpiredByMaxTTL": {
+			settings:      map[string]interface{}{"max_ttl": 1},
+			token:         "bouncer-user-9338",
+			wait:          1100 * time.Millisecond,
+			expectedCalls: 2,
+		},
+		"NotCachedBelowMinTTL": {
+			settings:      map[string]interface{}{"min_ttl": 5},
+			token:         "short-lived",
+			expectedCalls: 2,
+		},
+		"NotCachedWithNegativeTTL": {
+			settings:      map[string]interface{}{"failsafe_interval": 7200},
+			token:         "bouncer-user-9338",
+			expectedCalls: 2,
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			h := newHarness(t, withBouncerCache(tt.settings))
+			first := h.Do(http.MethodGet, checkTarget, ozonetest.Bearer(tt.token))
+			time.Sleep(tt.wait)
+			second := h.Do(http.MethodGet, checkTarget, ozonetest.Bearer(tt.token))
+			assert.Equal(t, http.StatusOK, first.Code)
+			assert.Equal(t, http.StatusOK, second.Code)
+			assert.Equal(t, tt.expectedCalls, h.Hydra.Calls())
+		})
+	}
+}
+
+func TestTokenCache_RefreshAhead(t *testing.T) {
+	h := newHarness(t, withBouncerCache(map[string]interface{}{"max_ttl": 2, "refresh_ahead": 0.9}))
+
+	assert.Equal(t, http.StatusOK, h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("bouncer-user-9338")).Code)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("bouncer-user-9338")).Code)
+	assert.Eventually(t, func() bool { return h.Hydra.Calls() == 2 }, time.Second, 10*time.Millisecond)
+
+	t.Run("RevokedTokenEvicted", func(t *testing.T) {
+		h.Hydra.AddToken(ozonetest.Token{Token: "bouncer-user-9338", Subject: "com.livspace.auth;bouncer;users;9338", Inactive: true})
+		time.Sleep(300 * time.Millisecond)
+		assert.Equal(t, http.StatusOK, h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("bouncer-user-9338")).Code)
+		assert.Eventually(t, func() bool {
+			return h.Do(http.MethodGet, checkTarget, ozonetest.Bearer("bouncer-user-9338")).Code == http.StatusUnauthorized
+		}, time.Second, 20*time.Millisecond)
+	})
+}
+
+func TestTokenCache_Config(t *testing.T) {
+	tests := map[string]struct {
+		settings map[string]interface{}
+		valid    bool
+	}{
+		"Bounds": {
+			settings: map[string]interface{}{"min_ttl": 5, "max_ttl": 300, "refresh_ahead": 0.2, "jitter": 0.5},
+			valid:    true,
+		},
+		"MaxBelowMin": {
+			settings: map[string]interface{}{"min_ttl": 60, "max_ttl": 30},
+		},
+		"NegativeFailsafe": {
+			settings: map[string]interface{}{"failsafe_interval": -1},
+		},
+		"RefreshWholeTTL": {
+			settings: map[string]interface{}{"refresh_ahead": 1},
+		},
+		"JitterAboveOne": {
+			settings: map[string]interface{}{"refresh_ahead": 0.2, "jitter": 1.5},
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			config := viper.New()
+			config.Set("log.level", "info")
+			config.Set("issuer.bouncer.url", "http://localhost:4445")
+			config.Set("issuer.bouncer.cache", tt.settings)
+			err := configs.Validate(config)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}